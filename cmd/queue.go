@@ -2,17 +2,24 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/bytedance/sonic"
 	"github.com/google/uuid"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/kondohiroki/go-boilerplate/config"
 	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
 	"github.com/kondohiroki/go-boilerplate/internal/job"
 	"github.com/kondohiroki/go-boilerplate/internal/logger"
 	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/kondohiroki/go-boilerplate/pkg/color"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -24,15 +31,34 @@ func init() {
 		queueClearCommand,
 		queueFlushCommand,
 		queueForgetCommand,
+		queueCancelCommand,
 		queueRetryCommand,
 		queueRestoreCommand,
+		queueHandlersCommand,
+		queuePauseCommand,
+		queueResumeCommand,
+		queueStatusCommand,
+		queueWorkersCommand,
+		queueMonitorCommand,
+		queuePruneCommand,
+		queueRelayCommand,
 	)
 
-	queueWorkCommand.Flags().StringP("queue", "q", "default", "(optional) queue name. for example: -q emails")
+	queueWorkCommand.Flags().StringP("queue", "q", "default", "(optional) queue names separated by commas, in priority order. for example: -q critical,default,low")
 	queueWorkCommand.Flags().IntP("worker", "w", 1, "(optional) The number of worker goroutines to run. for example: -w 2")
+	queueWorkCommand.Flags().StringP("mode", "m", queue.ModeStrict, "(optional) how multiple queues are consumed: strict (drain queues in order) or weighted (round robin by configured weight)")
+	queueWorkCommand.Flags().Duration("grace-period", 30*time.Second, "(optional) how long the jobs in flight may run on shutdown before they are stopped and released back to the queue")
+	queueWorkCommand.Flags().Int("max-jobs", 0, "(optional) the number of jobs each worker processes before it stops, 0 for no limit")
+	queueWorkCommand.Flags().Duration("max-time", 0, "(optional) how long the workers run before they stop, 0 for no limit. for example: --max-time 1h")
+	queueWorkCommand.Flags().Uint64("memory-limit", 0, "(optional) the heap size in MB from which the workers stop, 0 for no limit")
+	queueWorkCommand.Flags().Bool("stop-when-empty", false, "(optional) stop the workers once the queues are empty")
 	queueWorkCommand.Example = "  queue:work"
 	queueWorkCommand.Example += "\n  queue:work -w 2"
 	queueWorkCommand.Example += "\n  queue:work -q emails -w 2"
+	queueWorkCommand.Example += "\n  queue:work -q critical,default,low"
+	queueWorkCommand.Example += "\n  queue:work -q critical,default,low -m weighted"
+	queueWorkCommand.Example += "\n  queue:work --max-jobs 1000 --max-time 1h --memory-limit 512"
+	queueWorkCommand.Example += "\n  queue:work -q imports --stop-when-empty"
 
 	queueRetryCommand.Flags().StringP("queue", "q", "default", "(optional) queue name. for example: -q emails")
 	queueRetryCommand.Flags().StringP("id", "i", "", "(optional) job id. for example: --id df6df3af-d53d-49c2-bd50-80ba1d32b17b")
@@ -54,9 +80,56 @@ func init() {
 	queueForgetCommand.MarkFlagRequired("id")
 	queueForgetCommand.Example = `  queue:forget -i df6df3af-d53d-49c2-bd50-80ba1d32b17b`
 
+	queueCancelCommand.Flags().StringP("id", "i", "", "job id. for example: --id df6df3af-d53d-49c2-bd50-80ba1d32b17b")
+	queueCancelCommand.MarkFlagRequired("id")
+	queueCancelCommand.Example = `  queue:cancel -i df6df3af-d53d-49c2-bd50-80ba1d32b17b`
+
 	queueRestoreCommand.Flags().StringP("queue", "q", "default", "(optional) queue name. for example: -q emails")
 	queueRestoreCommand.Example = "  queue:restore"
 	queueRestoreCommand.Example += "\n  queue:restore -q emails"
+
+	queueHandlersCommand.Example = "  queue:handlers"
+
+	queuePauseCommand.Flags().StringP("queue", "q", "default", "(optional) queue name. for example: -q emails")
+	queuePauseCommand.Example = "  queue:pause"
+	queuePauseCommand.Example += "\n  queue:pause -q emails"
+
+	queueResumeCommand.Flags().StringP("queue", "q", "default", "(optional) queue name. for example: -q emails")
+	queueResumeCommand.Example = "  queue:resume"
+	queueResumeCommand.Example += "\n  queue:resume -q emails"
+
+	queueStatusCommand.Example = "  queue:status"
+
+	queueWorkersCommand.Example = "  queue:workers"
+
+	queueMonitorCommand.Flags().DurationP("interval", "n", 2*time.Second, "(optional) how often the dashboard is refreshed")
+	queueMonitorCommand.Flags().Bool("once", false, "(optional) print the metrics once and exit")
+	queueMonitorCommand.Flags().Bool("json", false, "(optional) print the metrics as JSON, one document per refresh")
+	queueMonitorCommand.Flags().Int64("warn-ready", 100, "(optional) number of ready jobs from which a queue is shown as a warning")
+	queueMonitorCommand.Flags().Int64("crit-ready", 1000, "(optional) number of ready jobs from which a queue is shown as critical")
+	queueMonitorCommand.Flags().Duration("warn-age", time.Minute, "(optional) age of the oldest job from which a queue is shown as a warning")
+	queueMonitorCommand.Flags().Duration("crit-age", 5*time.Minute, "(optional) age of the oldest job from which a queue is shown as critical")
+	queueMonitorCommand.Example = "  queue:monitor"
+	queueMonitorCommand.Example += "\n  queue:monitor -n 5s --warn-ready 50"
+	queueMonitorCommand.Example += "\n  queue:monitor --once --json"
+
+	queuePruneCommand.Flags().StringP("queue", "q", "", "(optional) only prune this queue. for example: -q emails")
+	queuePruneCommand.Flags().Int("completed", 0, "(optional) days to keep completed jobs, overriding queue.prune.completed of the config")
+	queuePruneCommand.Flags().Int("failed", 0, "(optional) days to keep failed jobs, overriding queue.prune.failed of the config")
+	queuePruneCommand.Flags().Int("cancelled", 0, "(optional) days to keep cancelled jobs, overriding queue.prune.cancelled of the config")
+	queuePruneCommand.Flags().Int("batch-size", 0, "(optional) jobs deleted per statement, overriding queue.prune.batchSize of the config")
+	queuePruneCommand.Flags().Bool("archive", false, "(optional) copy the pruned jobs to the archived_jobs table before deleting them")
+	queuePruneCommand.Flags().Bool("dry-run", false, "(optional) only count the jobs that would be pruned")
+	queuePruneCommand.Example = "  queue:prune"
+	queuePruneCommand.Example += "\n  queue:prune --dry-run"
+	queuePruneCommand.Example += "\n  queue:prune -q emails --completed 1 --failed 7 --cancelled 1 --archive"
+
+	queueRelayCommand.Flags().DurationP("interval", "n", time.Second, "(optional) how often the outbox is polled once it is empty")
+	queueRelayCommand.Flags().Int("batch-size", queue.DefaultRelayBatchSize, "(optional) the number of jobs relayed per transaction")
+	queueRelayCommand.Flags().Bool("once", false, "(optional) relay the jobs waiting in the outbox and exit")
+	queueRelayCommand.Example = "  queue:relay"
+	queueRelayCommand.Example += "\n  queue:relay -n 500ms --batch-size 500"
+	queueRelayCommand.Example += "\n  queue:relay --once"
 }
 
 var queueWorkCommand = &cobra.Command{
@@ -69,8 +142,21 @@ var queueWorkCommand = &cobra.Command{
 
 		queueName, _ := cmd.Flags().GetString("queue")
 		numberOfWorkers, _ := cmd.Flags().GetInt("worker")
+		opts := queue.WorkerOptions{}
+		opts.Mode, _ = cmd.Flags().GetString("mode")
+		opts.GracePeriod, _ = cmd.Flags().GetDuration("grace-period")
+		opts.MaxJobs, _ = cmd.Flags().GetInt("max-jobs")
+		opts.MaxTime, _ = cmd.Flags().GetDuration("max-time")
+		memoryLimit, _ := cmd.Flags().GetUint64("memory-limit")
+		opts.MemoryLimit = memoryLimit * 1024 * 1024
+		opts.StopWhenEmpty, _ = cmd.Flags().GetBool("stop-when-empty")
+
+		if opts.Mode != queue.ModeStrict && opts.Mode != queue.ModeWeighted {
+			logger.Log.Error(fmt.Sprintf("Unknown mode %s, expected %s or %s", opts.Mode, queue.ModeStrict, queue.ModeWeighted))
+			return
+		}
 
-		logger.Log.Info(fmt.Sprintf("Starting %d queue workers for queue %s", numberOfWorkers, queueName))
+		logger.Log.Info(fmt.Sprintf("Starting %d queue workers for queue %s (%s)", numberOfWorkers, queueName, opts.Mode))
 
 		// Create a context that gets canceled when the program receives a termination signal.
 		ctx, cancel := context.WithCancel(context.Background())
@@ -81,19 +167,37 @@ var queueWorkCommand = &cobra.Command{
 
 		go func() {
 			sig := <-sigCh
-			logger.Log.Info(fmt.Sprintf("Received signal: %v, canceling context", sig))
+			logger.Log.Info(fmt.Sprintf("Received signal: %v, stopping the workers, jobs in flight have %s to finish", sig, opts.GracePeriod))
 			cancel()
 		}()
 
-		q := queue.NewQueue(queueName)
+		var queues []*queue.Queue
+		for _, name := range strings.Split(queueName, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				queues = append(queues, queue.NewQueue(name))
+			}
+		}
+
+		// Move delayed jobs to the queues once they are due
+		for _, q := range queues {
+			q := q
+			go func() {
+				err := q.RunPromoter(ctx, time.Second)
+				if err != nil && err != context.Canceled {
+					logger.Log.Error("Delayed job promoter stopped with error", zap.Error(err))
+				}
+			}()
+		}
 
 		var wg sync.WaitGroup
 		wg.Add(numberOfWorkers)
 
 		for i := 0; i < numberOfWorkers; i++ {
+			// Every worker is a named consumer of the queues
+			workerCtx := queue.WithConsumer(ctx, queue.NewConsumerName(i))
 			go func() {
 				defer wg.Done()
-				err := q.Run(ctx)
+				err := queue.RunWorker(workerCtx, opts, queues...)
 				if err != nil && err != context.Canceled {
 					logger.Log.Error("Queue worker stopped with error", zap.Error(err))
 				} else {
@@ -248,6 +352,34 @@ var queueForgetCommand = &cobra.Command{
 	},
 }
 
+var queueCancelCommand = &cobra.Command{
+	Use:     "queue:cancel",
+	Short:   "Cancel a pending or running queue job",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		jobID, _ := cmd.Flags().GetString("id")
+
+		id, err := uuid.Parse(jobID)
+		if err != nil {
+			logger.Log.Error("Cannot parse job id", zap.Error(err))
+			return
+		}
+
+		queueName, err := queue.CancelJobByID(ctx, id)
+		if err != nil {
+			logger.Log.Error("Queue cancel failed", zap.Error(err))
+			return
+		}
+
+		logger.Log.Info(fmt.Sprintf("Queue cancel completed. Job %s of queue %s is cancelled", jobID, queueName))
+	},
+}
+
 var queueRestoreCommand = &cobra.Command{
 	Use:     "queue:restore",
 	Short:   "Restore a failed and unfinished job to the redis queue",
@@ -293,6 +425,23 @@ var queueRestoreCommand = &cobra.Command{
 				logger.Log.Error("New job error", zap.Error(err))
 				continue
 			}
+			jobItem.Timeout = j.Timeout
+			jobItem.Priority = j.Priority
+			jobItem.UniqueKey = j.UniqueKey
+			jobItem.UniqueUntil = j.UniqueUntil
+			jobItem.UniqueFor = j.UniqueFor
+			jobItem.Input = j.Input
+			jobItem.BatchID = j.BatchID
+			if len(j.Backoff) > 0 {
+				if err := sonic.Unmarshal(j.Backoff, &jobItem.Backoff); err != nil {
+					logger.Log.Error("Restore job backoff error", zap.Error(err))
+				}
+			}
+			if len(j.Chain) > 0 {
+				if err := sonic.Unmarshal(j.Chain, &jobItem.Chain); err != nil {
+					logger.Log.Error("Restore job chain error", zap.Error(err))
+				}
+			}
 
 			if j.Status == job.StatusFailed {
 				err = q.EnqueueFailedJobs(ctx, jobItem)
@@ -309,3 +458,476 @@ var queueRestoreCommand = &cobra.Command{
 
 	},
 }
+
+var queueHandlersCommand = &cobra.Command{
+	Use:     "queue:handlers",
+	Short:   "List all registered job handlers",
+	GroupID: "queue",
+	Run: func(_ *cobra.Command, _ []string) {
+		// Print the handler list as a table in the console
+		tableWriter := table.NewWriter()
+		tableWriter.SetOutputMirror(os.Stdout)
+		tableWriter.AppendHeader(table.Row{"No.", "Handler Name", "Payload", "Middleware", "Encrypted"})
+		for i, registration := range job.Registrations() {
+			tableWriter.AppendRow(table.Row{
+				i + 1,
+				registration.Name,
+				registration.Payload,
+				len(registration.Middleware),
+				registration.Encrypted,
+			})
+		}
+
+		tableWriter.Render()
+	},
+}
+
+var queuePauseCommand = &cobra.Command{
+	Use:     "queue:pause",
+	Short:   "Stop the workers from taking jobs from the specified queue",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		queueName, _ := cmd.Flags().GetString("queue")
+
+		if err := queue.NewQueue(queueName).Pause(ctx); err != nil {
+			logger.Log.Error("Queue pause failed", zap.String("queue", queueName), zap.Error(err))
+		} else {
+			logger.Log.Info(fmt.Sprintf("Queue pause completed. Queue %s paused", queueName))
+		}
+	},
+}
+
+var queueResumeCommand = &cobra.Command{
+	Use:     "queue:resume",
+	Short:   "Let the workers take jobs from the specified paused queue again",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		queueName, _ := cmd.Flags().GetString("queue")
+
+		if err := queue.NewQueue(queueName).Resume(ctx); err != nil {
+			logger.Log.Error("Queue resume failed", zap.String("queue", queueName), zap.Error(err))
+		} else {
+			logger.Log.Info(fmt.Sprintf("Queue resume completed. Queue %s resumed", queueName))
+		}
+	},
+}
+
+var queueStatusCommand = &cobra.Command{
+	Use:     "queue:status",
+	Short:   "List the queues with the number of jobs in each state",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		queueInfos, err := queue.ListQueueKeysAndLengths(ctx)
+		if err != nil {
+			logger.Log.Error("Queue status failed", zap.Error(err))
+			return
+		}
+
+		// Print the queue list as a table in the console
+		tableWriter := table.NewWriter()
+		tableWriter.SetOutputMirror(os.Stdout)
+		tableWriter.AppendHeader(table.Row{"Queue", "Ready", "Reserved", "Delayed", "Failed", "Weight", "Paused"})
+		for _, queueInfo := range queueInfos {
+			tableWriter.AppendRow(table.Row{
+				queueInfo.KeyWithoutPrefix,
+				queueInfo.NumberOfItems,
+				queueInfo.NumberOfReserved,
+				queueInfo.NumberOfDelayed,
+				queueInfo.NumberOfFailed,
+				queueInfo.Weight,
+				queueInfo.Paused,
+			})
+		}
+
+		tableWriter.Render()
+	},
+}
+
+var queueWorkersCommand = &cobra.Command{
+	Use:     "queue:workers",
+	Short:   "List the running queue workers and the job each one is processing",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		workers, err := queue.ListWorkers(ctx)
+		if err != nil {
+			logger.Log.Error("Queue workers failed", zap.Error(err))
+			return
+		}
+
+		// Print the worker list as a table in the console
+		tableWriter := table.NewWriter()
+		tableWriter.SetOutputMirror(os.Stdout)
+		tableWriter.AppendHeader(table.Row{"ID", "Hostname", "PID", "Queues", "Current Job", "Running For", "Progress", "Last Polled", "Last Heartbeat"})
+		for _, worker := range workers {
+			currentJob, runningFor, progress := "-", "-", "-"
+			if worker.CurrentJobID != nil {
+				currentJob = worker.CurrentJobID.String()
+			}
+			if worker.JobStartedAt != nil {
+				runningFor = time.Since(*worker.JobStartedAt).Round(time.Second).String()
+			}
+			if worker.JobProgress != nil {
+				progress = fmt.Sprintf("%.0f%%", worker.JobProgress.Percent)
+				if worker.JobProgress.Message != "" {
+					progress += " " + worker.JobProgress.Message
+				}
+			}
+
+			tableWriter.AppendRow(table.Row{
+				worker.ID,
+				worker.Hostname,
+				worker.PID,
+				strings.Join(worker.Queues, ","),
+				currentJob,
+				runningFor,
+				progress,
+				worker.LastPolledAt.Format(time.DateTime),
+				worker.LastHeartbeat.Format(time.DateTime),
+			})
+		}
+
+		tableWriter.Render()
+	},
+}
+
+var queueMonitorCommand = &cobra.Command{
+	Use:     "queue:monitor",
+	Short:   "Watch the queues on a live dashboard",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		// Setup all the required dependencies
+		setupAll()
+
+		interval, _ := cmd.Flags().GetDuration("interval")
+		once, _ := cmd.Flags().GetBool("once")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		thresholds := monitorThresholds{}
+		thresholds.warnReady, _ = cmd.Flags().GetInt64("warn-ready")
+		thresholds.critReady, _ = cmd.Flags().GetInt64("crit-ready")
+		thresholds.warnAge, _ = cmd.Flags().GetDuration("warn-age")
+		thresholds.critAge, _ = cmd.Flags().GetDuration("crit-age")
+
+		if !once && interval <= 0 {
+			logger.Log.Error("The refresh interval must be positive", zap.Duration("interval", interval))
+			return
+		}
+
+		// Stop refreshing when the program receives a termination signal
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		// A single snapshot needs no ticker
+		var refresh <-chan time.Time
+		if !once {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			refresh = ticker.C
+		}
+
+		for {
+			metrics, err := queue.CollectMetrics(ctx)
+			if err != nil {
+				logger.Log.Error("Queue monitor failed", zap.Error(err))
+				return
+			}
+
+			if asJSON {
+				printQueueMetricsJSON(metrics, thresholds)
+			} else {
+				printQueueMonitor(metrics, thresholds, !once, interval)
+			}
+
+			if once {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-refresh:
+			}
+		}
+	},
+}
+
+const (
+	monitorLevelOK       = "ok"
+	monitorLevelWarning  = "warning"
+	monitorLevelCritical = "critical"
+)
+
+// monitorThresholds decide from which figures queue:monitor shows a queue as a warning or critical.
+type monitorThresholds struct {
+	warnReady int64
+	critReady int64
+	warnAge   time.Duration
+	critAge   time.Duration
+}
+
+func (t monitorThresholds) readyLevel(ready int64) string {
+	switch {
+	case ready >= t.critReady:
+		return monitorLevelCritical
+	case ready >= t.warnReady:
+		return monitorLevelWarning
+	}
+	return monitorLevelOK
+}
+
+func (t monitorThresholds) ageLevel(age time.Duration) string {
+	switch {
+	case age >= t.critAge:
+		return monitorLevelCritical
+	case age >= t.warnAge:
+		return monitorLevelWarning
+	}
+	return monitorLevelOK
+}
+
+func failedLevel(failed int64) string {
+	if failed > 0 {
+		return monitorLevelWarning
+	}
+	return monitorLevelOK
+}
+
+// level is the worst level of the figures of a queue.
+func (t monitorThresholds) level(m queue.QueueMetrics) string {
+	level := monitorLevelOK
+	for _, l := range []string{t.readyLevel(m.NumberOfItems), t.ageLevel(oldestJobAge(m)), failedLevel(m.NumberOfFailed)} {
+		if l == monitorLevelCritical || (l == monitorLevelWarning && level == monitorLevelOK) {
+			level = l
+		}
+	}
+	return level
+}
+
+func oldestJobAge(m queue.QueueMetrics) time.Duration {
+	return time.Duration(m.OldestJobAge * float64(time.Second))
+}
+
+// colorize prints a figure in the color of its level, figures at the ok level keep the default color.
+func colorize(level string, value any) string {
+	switch level {
+	case monitorLevelCritical:
+		return color.Sprint(color.Failed, fmt.Sprint(value))
+	case monitorLevelWarning:
+		return color.Sprint(color.Warning, fmt.Sprint(value))
+	}
+	return fmt.Sprint(value)
+}
+
+func printQueueMonitor(metrics []queue.QueueMetrics, thresholds monitorThresholds, live bool, interval time.Duration) {
+	if live {
+		// Move the cursor home and clear the screen so the dashboard is redrawn in place
+		fmt.Print("\x1b[H\x1b[2J")
+		fmt.Printf("Queue monitor, refreshed every %s at %s (Ctrl+C to quit)\n", interval, time.Now().Format(time.TimeOnly))
+	}
+
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Queue", "Ready", "Reserved", "Failed", "Delayed", "Throughput/min", "Oldest Job", "Paused"})
+	for _, m := range metrics {
+		age := oldestJobAge(m)
+		ageText := "-"
+		if age > 0 {
+			ageText = age.Round(time.Second).String()
+		}
+
+		paused := fmt.Sprint(m.Paused)
+		if m.Paused {
+			paused = color.Sprint(color.Info, paused)
+		}
+
+		tableWriter.AppendRow(table.Row{
+			m.KeyWithoutPrefix,
+			colorize(thresholds.readyLevel(m.NumberOfItems), m.NumberOfItems),
+			m.NumberOfReserved,
+			colorize(failedLevel(m.NumberOfFailed), m.NumberOfFailed),
+			m.NumberOfDelayed,
+			m.Throughput,
+			colorize(thresholds.ageLevel(age), ageText),
+			paused,
+		})
+	}
+
+	tableWriter.Render()
+}
+
+// queueMonitorJSON is a queue in the JSON output of queue:monitor.
+type queueMonitorJSON struct {
+	queue.QueueMetrics
+	Level string `json:"level"` // ok, warning or critical
+}
+
+func printQueueMetricsJSON(metrics []queue.QueueMetrics, thresholds monitorThresholds) {
+	rows := make([]queueMonitorJSON, 0, len(metrics))
+	for _, m := range metrics {
+		rows = append(rows, queueMonitorJSON{
+			QueueMetrics: m,
+			Level:        thresholds.level(m),
+		})
+	}
+
+	output, err := sonic.Marshal(rows)
+	if err != nil {
+		logger.Log.Error("Error encoding queue metrics", zap.Error(err))
+		return
+	}
+	fmt.Println(string(output))
+}
+
+var queuePruneCommand = &cobra.Command{
+	Use:     "queue:prune",
+	Short:   "Delete the records of the completed, failed and cancelled jobs older than their retention",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+
+		// Setup all the required dependencies
+		setupAll()
+
+		opts := queue.PruneOptionsFromConfig(config.GetConfig().Queue.Prune)
+		opts.Only, _ = cmd.Flags().GetString("queue")
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		if cmd.Flags().Changed("completed") || cmd.Flags().Changed("failed") || cmd.Flags().Changed("cancelled") {
+			// The retention given on the command line applies to every queue
+			completed, _ := cmd.Flags().GetInt("completed")
+			failed, _ := cmd.Flags().GetInt("failed")
+			cancelled, _ := cmd.Flags().GetInt("cancelled")
+			for name, retention := range opts.Queues {
+				opts.Queues[name] = overrideRetention(cmd, retention, completed, failed, cancelled)
+			}
+			opts.Default = overrideRetention(cmd, opts.Default, completed, failed, cancelled)
+		}
+		if cmd.Flags().Changed("batch-size") {
+			opts.BatchSize, _ = cmd.Flags().GetInt("batch-size")
+		}
+		if cmd.Flags().Changed("archive") {
+			opts.Archive, _ = cmd.Flags().GetBool("archive")
+		}
+
+		results, err := queue.Prune(ctx, repository.NewRepository().Job, opts)
+		printPruneResults(results, opts.DryRun)
+		if err != nil {
+			logger.Log.Error("Queue prune failed", zap.Error(err))
+			return
+		}
+
+		var total int64
+		for _, result := range results {
+			total += result.Count
+		}
+		if opts.DryRun {
+			logger.Log.Info(fmt.Sprintf("Queue prune dry run completed. %d jobs would be pruned", total))
+		} else {
+			logger.Log.Info(fmt.Sprintf("Queue prune completed. %d jobs pruned", total))
+		}
+	},
+}
+
+// overrideRetention replaces the retention of the statuses whose flag is set.
+func overrideRetention(cmd *cobra.Command, retention queue.Retention, completed int, failed int, cancelled int) queue.Retention {
+	if cmd.Flags().Changed("completed") {
+		retention.Completed = time.Duration(completed) * 24 * time.Hour
+	}
+	if cmd.Flags().Changed("failed") {
+		retention.Failed = time.Duration(failed) * 24 * time.Hour
+	}
+	if cmd.Flags().Changed("cancelled") {
+		retention.Cancelled = time.Duration(cancelled) * 24 * time.Hour
+	}
+	return retention
+}
+
+func printPruneResults(results []queue.PruneResult, dryRun bool) {
+	countHeader := "Pruned"
+	if dryRun {
+		countHeader = "Would Prune"
+	}
+
+	// Print the prune results as a table in the console
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Queue", "Status", "Updated Before", countHeader})
+	for _, result := range results {
+		tableWriter.AppendRow(table.Row{
+			result.Queue,
+			result.Status,
+			result.Before.Format(time.DateTime),
+			result.Count,
+		})
+	}
+
+	tableWriter.Render()
+}
+
+var queueRelayCommand = &cobra.Command{
+	Use:     "queue:relay",
+	Short:   "Move the jobs enqueued within a transaction from the outbox to their queue",
+	GroupID: "queue",
+	Run: func(cmd *cobra.Command, _ []string) {
+		// Setup all the required dependencies
+		setupAll()
+
+		interval, _ := cmd.Flags().GetDuration("interval")
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		once, _ := cmd.Flags().GetBool("once")
+		repo := repository.NewRepository()
+
+		// Stop relaying when the program receives a termination signal
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		if once {
+			var total int
+			for {
+				relayed, err := queue.RelayOutbox(ctx, repo.Outbox, batchSize)
+				total += relayed
+				if err != nil {
+					logger.Log.Error("Queue relay failed", zap.Error(err))
+					return
+				}
+				if relayed == 0 || relayed < batchSize {
+					break
+				}
+			}
+
+			remaining, err := repo.Outbox.CountOutboxJobs(ctx)
+			if err != nil {
+				logger.Log.Error("Queue relay failed", zap.Error(err))
+				return
+			}
+			logger.Log.Info(fmt.Sprintf("Queue relay completed. %d jobs relayed, %d left in the outbox", total, remaining))
+			return
+		}
+
+		logger.Log.Info(fmt.Sprintf("Starting the outbox relay, polling every %s", interval))
+		err := queue.RunRelay(ctx, repo.Outbox, interval, batchSize)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Log.Error("Queue relay stopped with error", zap.Error(err))
+		} else {
+			logger.Log.Info("Queue relay stopped gracefully")
+		}
+	},
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/kondohiroki/go-boilerplate/config"
 	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
 	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
 	"github.com/kondohiroki/go-boilerplate/internal/logger"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -42,6 +43,7 @@ func setupAll() {
 	setUpPostgres()
 	setUpRedis()
 	setUpSentry()
+	setUpWebhooks()
 }
 
 func Execute() {
@@ -145,3 +147,11 @@ func setUpSentry() {
 
 	defer sentry.Flush(2 * time.Second)
 }
+
+func setUpWebhooks() {
+	// Deliver the job events to the webhooks of the config, see queue.SubscribeWebhooks
+	if webhooks := config.GetConfig().Queue.Webhooks; len(webhooks) > 0 {
+		logger.Log.Info("Subscribing webhooks to job events", zap.Int("webhooks", len(webhooks)))
+		queue.SubscribeWebhooks(webhooks)
+	}
+}
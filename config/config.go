@@ -21,10 +21,12 @@ type Config struct {
 	Postgres   Postgres   `yaml:"postgres"`
 	Redis      []Redis    `yaml:"redis"`
 	Sentry     Sentry     `yaml:"sentry"`
+	Queue      Queue      `yaml:"queue"`
 }
 
 type HttpServer struct {
-	Port int `yaml:"port"`
+	Port        int      `yaml:"port"`
+	AdminTokens []string `yaml:"adminTokens"` // bearer tokens accepted by the admin API, none means it is closed
 }
 
 type Log struct {
@@ -44,8 +46,10 @@ type Label struct {
 }
 
 type App struct {
-	Name     string `yaml:"name"`
-	NameSlug string `yaml:"nameSlug"`
+	Key          string   `yaml:"key"`          // secret the job payloads are encrypted with, see job.EncryptedPayload
+	PreviousKeys []string `yaml:"previousKeys"` // keys replaced by Key, still used to decrypt the jobs encrypted with them
+	Name         string   `yaml:"name"`
+	NameSlug     string   `yaml:"nameSlug"`
 }
 
 type Postgres struct {
@@ -83,6 +87,46 @@ type Schedule struct {
 	IsEnabled bool   `yaml:"isEnabled"`
 }
 
+type Queue struct {
+	Driver   string         `yaml:"driver"` // redis, stream, postgres or memory
+	Queues   []QueueWeight  `yaml:"queues"`
+	Prune    QueuePrune     `yaml:"prune"`
+	Webhooks []QueueWebhook `yaml:"webhooks"`
+}
+
+// QueueWebhook receives job events as signed JSON POST requests, see queue.SubscribeWebhooks.
+type QueueWebhook struct {
+	URL         string   `yaml:"url"`
+	Secret      string   `yaml:"secret"`      // key of the HMAC-SHA256 signature of the requests
+	Events      []string `yaml:"events"`      // e.g. job.succeeded or job.dead, all events if empty
+	Queues      []string `yaml:"queues"`      // queues whose job events are sent, all queues if empty
+	Queue       string   `yaml:"queue"`       // queue of the delivery jobs, the queue of the event by default
+	MaxAttempts int      `yaml:"maxAttempts"` // delivery attempts before giving up
+}
+
+// QueuePrune sets how long the records of finished jobs are kept, see queue:prune.
+type QueuePrune struct {
+	Completed int              `yaml:"completed"` // days to keep completed jobs, 0 keeps them forever
+	Failed    int              `yaml:"failed"`    // days to keep failed jobs, 0 keeps them forever
+	Cancelled int              `yaml:"cancelled"` // days to keep cancelled jobs, 0 keeps them forever
+	BatchSize int              `yaml:"batchSize"` // jobs deleted per statement
+	Archive   bool             `yaml:"archive"`   // copy the pruned jobs to archived_jobs
+	Queues    []QueueRetention `yaml:"queues"`
+}
+
+// QueueRetention overrides the retention of the jobs of a queue, an unset status keeps the default.
+type QueueRetention struct {
+	Name      string `yaml:"name"`
+	Completed *int   `yaml:"completed"`
+	Failed    *int   `yaml:"failed"`
+	Cancelled *int   `yaml:"cancelled"`
+}
+
+type QueueWeight struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
 type Authentication struct {
 	Endpoint string `yaml:"endpoint"`
 	Username string `yaml:"username"`
@@ -3,6 +3,7 @@ module github.com/kondohiroki/go-boilerplate
 go 1.20
 
 require (
+	github.com/alicebob/miniredis/v2 v2.31.0
 	github.com/brianvoe/gofakeit/v6 v6.21.0
 	github.com/bytedance/sonic v1.10.0
 	github.com/gavv/httpexpect/v2 v2.15.0
@@ -28,8 +29,10 @@ require (
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/chenzhuoyu/iasm v0.9.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.2 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
 )
 
 require (
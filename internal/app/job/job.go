@@ -0,0 +1,168 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
+	jobHelper "github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/kondohiroki/go-boilerplate/pkg/exception"
+)
+
+type JobApp interface {
+	GetJobByID(ctx context.Context, input GetJobDTI) (GetJobDTO, error)
+	GetJobProgress(ctx context.Context, input GetJobDTI) (GetJobProgressDTO, error)
+}
+
+type jobApp struct {
+	Repo *repository.Repository
+}
+
+func NewJobApp(repo *repository.Repository) JobApp {
+	return &jobApp{
+		Repo: repo,
+	}
+}
+
+type GetJobDTI struct {
+	ID string
+}
+
+type GetJobDTO struct {
+	ID          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	HandlerName string          `json:"handler_name"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	MaxAttempts int             `json:"max_attempts"`
+	Result      json.RawMessage `json:"result"`
+	BatchID     *uuid.UUID      `json:"batch_id"`
+	Progress    *ProgressDTO    `json:"progress"` // nil if the job reported no progress
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Attempts    []JobAttemptDTO `json:"attempts"`
+}
+
+type ProgressDTO struct {
+	Percent   float64   `json:"percent"`
+	Message   string    `json:"message"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type GetJobProgressDTO struct {
+	ID       string       `json:"id"`
+	Status   string       `json:"status"`
+	Finished bool         `json:"finished"` // whether the job completed, failed or was cancelled, its progress does not change anymore
+	Progress *ProgressDTO `json:"progress"`
+}
+
+type JobAttemptDTO struct {
+	Attempt    int       `json:"attempt"`
+	Worker     string    `json:"worker"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	StackTrace string    `json:"stack_trace,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+func (app *jobApp) GetJobByID(ctx context.Context, input GetJobDTI) (GetJobDTO, error) {
+	jobID, err := uuid.Parse(input.ID)
+	if err != nil {
+		return GetJobDTO{}, exception.InvalidIDError
+	}
+
+	j, err := app.Repo.Job.GetJobByID(ctx, jobID)
+	if errors.Is(err, repository.ErrJobNotFound) {
+		return GetJobDTO{}, exception.DataNotFoundError
+	}
+	if err != nil {
+		return GetJobDTO{}, err
+	}
+
+	progress, err := getProgress(ctx, jobID)
+	if err != nil {
+		return GetJobDTO{}, err
+	}
+
+	attempts, err := app.Repo.Job.GetJobAttempts(ctx, jobID)
+	if err != nil {
+		return GetJobDTO{}, err
+	}
+
+	attemptDTOs := make([]JobAttemptDTO, 0, len(attempts))
+	for _, attempt := range attempts {
+		attemptDTOs = append(attemptDTOs, JobAttemptDTO{
+			Attempt:    attempt.Attempt,
+			Worker:     attempt.Worker,
+			Status:     attempt.Status,
+			Error:      attempt.Error,
+			StackTrace: attempt.StackTrace,
+			StartedAt:  attempt.StartedAt,
+			FinishedAt: attempt.FinishedAt,
+			DurationMs: attempt.DurationMs,
+		})
+	}
+
+	return GetJobDTO{
+		ID:          j.ID.String(),
+		Queue:       j.Queue,
+		HandlerName: j.HandlerName,
+		Payload:     j.Payload,
+		Status:      j.Status,
+		MaxAttempts: j.MaxAttempts,
+		Result:      j.Result,
+		BatchID:     j.BatchID,
+		Progress:    progress,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+		Attempts:    attemptDTOs,
+	}, nil
+}
+
+// GetJobProgress returns the status and the last progress of a job, it is polled by the progress stream.
+func (app *jobApp) GetJobProgress(ctx context.Context, input GetJobDTI) (GetJobProgressDTO, error) {
+	jobID, err := uuid.Parse(input.ID)
+	if err != nil {
+		return GetJobProgressDTO{}, exception.InvalidIDError
+	}
+
+	j, err := app.Repo.Job.GetJobByID(ctx, jobID)
+	if errors.Is(err, repository.ErrJobNotFound) {
+		return GetJobProgressDTO{}, exception.DataNotFoundError
+	}
+	if err != nil {
+		return GetJobProgressDTO{}, err
+	}
+
+	progress, err := getProgress(ctx, jobID)
+	if err != nil {
+		return GetJobProgressDTO{}, err
+	}
+
+	return GetJobProgressDTO{
+		ID:       j.ID.String(),
+		Status:   j.Status,
+		Finished: j.Status == jobHelper.StatusCompleted || j.Status == jobHelper.StatusFailed || j.Status == jobHelper.StatusCancelled,
+		Progress: progress,
+	}, nil
+}
+
+// getProgress returns the last progress reported for a job, from redis or else from the job table.
+func getProgress(ctx context.Context, jobID uuid.UUID) (*ProgressDTO, error) {
+	progress, err := queue.JobProgress(ctx, jobID)
+	if err != nil || progress == nil {
+		return nil, err
+	}
+
+	return &ProgressDTO{
+		Percent:   progress.Percent,
+		Message:   progress.Message,
+		UpdatedAt: progress.UpdatedAt,
+	}, nil
+}
@@ -2,23 +2,47 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/helper/pagination"
 	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
 	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/kondohiroki/go-boilerplate/pkg/exception"
 )
 
 type QueueApp interface {
 	GetQueues(ctx context.Context) ([]GetQueueDTO, error)
-	// GetQueueByID(GetQueueDTI) (GetQueueDTO, error)
+	GetQueueByKey(ctx context.Context, input GetQueueDetailDTI) (GetQueueDetailDTO, error)
+	GetFailedJobs(ctx context.Context, input GetFailedJobsDTI) (GetFailedJobsDTO, error)
+	RetryFailedJob(ctx context.Context, input FailedJobDTI) error
+	ForgetFailedJob(ctx context.Context, input FailedJobDTI) error
+	CancelJob(ctx context.Context, input CancelJobDTI) error
+	FlushFailedJobs(ctx context.Context, input GetQueueDTI) (CountDTO, error)
+	ClearQueue(ctx context.Context, input GetQueueDTI) (CountDTO, error)
+	PauseQueue(ctx context.Context, input GetQueueDTI) error
+	ResumeQueue(ctx context.Context, input GetQueueDTI) error
+	EnqueueJob(ctx context.Context, input EnqueueJobDTI) (EnqueueJobDTO, error)
+	EnqueueJobs(ctx context.Context, input EnqueueJobsDTI) (EnqueueJobsDTO, error)
+	GetBatchByID(ctx context.Context, input GetBatchDTI) (GetBatchDTO, error)
+	GetWorkers(ctx context.Context) ([]GetWorkerDTO, error)
 }
 
 type queueApp struct {
-	Repo *repository.Repository
+	Repo     *repository.Repository
+	Handlers job.HandlerMap
 }
 
 func NewQueueApp(repo *repository.Repository) QueueApp {
 	return &queueApp{
-		Repo: repo,
+		Repo:     repo,
+		Handlers: job.NewHandlerMap(),
 	}
 }
 
@@ -26,10 +50,74 @@ type GetQueueDTI struct {
 	Key string `json:"key"`
 }
 
+// DefaultPeek is the number of ready jobs returned with the queue detail.
+const DefaultPeek = 10
+
+// MaxPerPage caps the number of jobs returned by a single request.
+const MaxPerPage = 100
+
+type GetQueueDetailDTI struct {
+	Key  string
+	Peek string
+}
+
+type GetQueueDetailDTO struct {
+	Key      string        `json:"key"`
+	Ready    int64         `json:"ready"`
+	Reserved int64         `json:"reserved"`
+	Delayed  int64         `json:"delayed"`
+	Failed   int64         `json:"failed"`
+	Weight   int           `json:"weight"`
+	Paused   bool          `json:"paused"`
+	Jobs     []QueueJobDTO `json:"jobs"` // the next ready jobs, in the order they will be dequeued
+}
+
+type QueueJobDTO struct {
+	ID          string          `json:"id"`
+	HandlerName string          `json:"handler_name"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Priority    int             `json:"priority"`
+	Errors      []string        `json:"errors"`
+	BatchID     *uuid.UUID      `json:"batch_id"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type GetFailedJobsDTI struct {
+	Key     string
+	Page    string
+	PerPage string
+}
+
+type GetFailedJobsDTO struct {
+	Jobs       []QueueJobDTO            `json:"jobs"`
+	Pagination pagination.PaginationDTO `json:"pagination"`
+}
+
+type FailedJobDTI struct {
+	Key string
+	ID  string
+}
+
+type CancelJobDTI struct {
+	Key string
+	ID  string
+}
+
+type CountDTO struct {
+	Count int64 `json:"count"`
+}
+
 type GetQueueDTO struct {
 	Key              string `json:"key,omitempty"`
 	KeyWithoutPrefix string `json:"key_without_prefix,omitempty"`
 	NumberOfItems    int64  `json:"number_of_items"`
+	NumberOfDelayed  int64  `json:"number_of_delayed"`
+	NumberOfReserved int64  `json:"number_of_reserved"`
+	NumberOfFailed   int64  `json:"number_of_failed"`
+	Weight           int    `json:"weight"`
+	Paused           bool   `json:"paused"`
 }
 
 func (app *queueApp) GetQueues(ctx context.Context) ([]GetQueueDTO, error) {
@@ -44,9 +132,462 @@ func (app *queueApp) GetQueues(ctx context.Context) ([]GetQueueDTO, error) {
 			Key:              q.Key,
 			KeyWithoutPrefix: q.KeyWithoutPrefix,
 			NumberOfItems:    q.NumberOfItems,
+			NumberOfDelayed:  q.NumberOfDelayed,
+			NumberOfReserved: q.NumberOfReserved,
+			NumberOfFailed:   q.NumberOfFailed,
+			Weight:           q.Weight,
+			Paused:           q.Paused,
 		}
 		queues = append(queues, queue)
 	}
 
 	return queues, nil
 }
+
+func (app *queueApp) GetQueueByKey(ctx context.Context, input GetQueueDetailDTI) (GetQueueDetailDTO, error) {
+	peek, err := parsePositiveInt(input.Peek, DefaultPeek)
+	if err != nil || peek > MaxPerPage {
+		return GetQueueDetailDTO{}, exception.InvalidRequestQueryParamError
+	}
+
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return GetQueueDetailDTO{}, err
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		return GetQueueDetailDTO{}, err
+	}
+
+	paused, err := q.IsPaused(ctx)
+	if err != nil {
+		return GetQueueDetailDTO{}, err
+	}
+
+	jobs, err := q.Peek(ctx, int64(peek))
+	if err != nil {
+		return GetQueueDetailDTO{}, err
+	}
+
+	return GetQueueDetailDTO{
+		Key:      q.KeyWithoutPrefix,
+		Ready:    stats.Ready,
+		Reserved: stats.Reserved,
+		Delayed:  stats.Delayed,
+		Failed:   stats.Failed,
+		Weight:   q.Weight,
+		Paused:   paused,
+		Jobs:     toQueueJobDTOs(jobs),
+	}, nil
+}
+
+func (app *queueApp) GetFailedJobs(ctx context.Context, input GetFailedJobsDTI) (GetFailedJobsDTO, error) {
+	page, err := parsePositiveInt(input.Page, 1)
+	if err != nil {
+		return GetFailedJobsDTO{}, exception.InvalidRequestQueryParamError
+	}
+	perPage, err := parsePositiveInt(input.PerPage, DefaultPeek)
+	if err != nil || perPage > MaxPerPage {
+		return GetFailedJobsDTO{}, exception.InvalidRequestQueryParamError
+	}
+
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return GetFailedJobsDTO{}, err
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		return GetFailedJobsDTO{}, err
+	}
+
+	jobs, err := q.PeekFailed(ctx, int64((page-1)*perPage), int64(perPage))
+	if err != nil {
+		return GetFailedJobsDTO{}, err
+	}
+
+	pag, err := pagination.GetResponsePagination(&pagination.PaginationDTI{
+		Page:    strconv.Itoa(page),
+		PerPage: strconv.Itoa(perPage),
+	}, int(stats.Failed))
+	if err != nil {
+		return GetFailedJobsDTO{}, err
+	}
+
+	return GetFailedJobsDTO{
+		Jobs:       toQueueJobDTOs(jobs),
+		Pagination: pag,
+	}, nil
+}
+
+func (app *queueApp) RetryFailedJob(ctx context.Context, input FailedJobDTI) error {
+	q, jobID, err := parseFailedJob(input)
+	if err != nil {
+		return err
+	}
+
+	err = q.RetryFailedByJobID(ctx, jobID)
+	if errors.Is(err, queue.ErrFailedJobNotFound) {
+		return exception.DataNotFoundError
+	}
+	return err
+}
+
+func (app *queueApp) ForgetFailedJob(ctx context.Context, input FailedJobDTI) error {
+	q, jobID, err := parseFailedJob(input)
+	if err != nil {
+		return err
+	}
+
+	err = q.RemoveFailedByID(ctx, jobID)
+	if errors.Is(err, queue.ErrFailedJobNotFound) {
+		return exception.DataNotFoundError
+	}
+	return err
+}
+
+// CancelJob cancels a pending or running job of the queue, see queue.Queue.Cancel.
+func (app *queueApp) CancelJob(ctx context.Context, input CancelJobDTI) error {
+	q, jobID, err := parseFailedJob(FailedJobDTI(input))
+	if err != nil {
+		return err
+	}
+
+	err = q.Cancel(ctx, jobID)
+	if errors.Is(err, repository.ErrJobNotFound) {
+		return exception.DataNotFoundError
+	}
+	if errors.Is(err, queue.ErrJobFinished) {
+		return exception.JobAlreadyFinishedError
+	}
+	return err
+}
+
+func (app *queueApp) FlushFailedJobs(ctx context.Context, input GetQueueDTI) (CountDTO, error) {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return CountDTO{}, err
+	}
+
+	count, err := q.RemoveAllFailed(ctx)
+	if err != nil {
+		return CountDTO{}, err
+	}
+
+	return CountDTO{Count: count}, nil
+}
+
+func (app *queueApp) ClearQueue(ctx context.Context, input GetQueueDTI) (CountDTO, error) {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return CountDTO{}, err
+	}
+
+	count, err := q.Clear(ctx)
+	if err != nil {
+		return CountDTO{}, err
+	}
+
+	return CountDTO{Count: count}, nil
+}
+
+func (app *queueApp) PauseQueue(ctx context.Context, input GetQueueDTI) error {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return err
+	}
+
+	return q.Pause(ctx)
+}
+
+func (app *queueApp) ResumeQueue(ctx context.Context, input GetQueueDTI) error {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return err
+	}
+
+	return q.Resume(ctx)
+}
+
+// newQueue returns the queue with the given name, which must not be empty.
+func newQueue(key string) (*queue.Queue, error) {
+	if key == "" {
+		return nil, exception.BadRequestError
+	}
+
+	return queue.NewQueue(key), nil
+}
+
+// parseFailedJob returns the queue and the job ID of a failed job request.
+func parseFailedJob(input FailedJobDTI) (*queue.Queue, uuid.UUID, error) {
+	jobID, err := uuid.Parse(input.ID)
+	if err != nil {
+		return nil, uuid.Nil, exception.InvalidIDError
+	}
+
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	return q, jobID, nil
+}
+
+// parsePositiveInt parses a query parameter, an empty one gives the default value.
+func parsePositiveInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("%d is not a positive number", n)
+	}
+
+	return n, nil
+}
+
+func toQueueJobDTOs(jobs []*job.Job) []QueueJobDTO {
+	dtos := make([]QueueJobDTO, 0, len(jobs))
+	for _, j := range jobs {
+		dtos = append(dtos, QueueJobDTO{
+			ID:          j.ID.String(),
+			HandlerName: j.HandlerName,
+			Payload:     j.Payload,
+			Attempts:    j.Attempts,
+			MaxAttempts: j.MaxAttempts,
+			Priority:    j.Priority,
+			Errors:      j.Errors,
+			BatchID:     j.BatchID,
+			CreatedAt:   j.CreatedAt,
+		})
+	}
+	return dtos
+}
+
+// DefaultMaxAttempts is the number of attempts of a job dispatched without max_attempts.
+const DefaultMaxAttempts = 3
+
+// IdempotencyKeyTTL is how long dispatching a job with the same idempotency key returns the job dispatched first.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+type EnqueueJobDTI struct {
+	Key            string          `json:"-"`
+	HandlerName    string          `json:"handler_name" validate:"required"`
+	Payload        json.RawMessage `json:"payload"`
+	MaxAttempts    int             `json:"max_attempts" validate:"gte=0"`
+	Delay          int             `json:"delay" validate:"gte=0"` // in seconds
+	IdempotencyKey string          `json:"idempotency_key" validate:"max=255"`
+}
+
+type EnqueueJobDTO struct {
+	ID        string `json:"id"`
+	Duplicate bool   `json:"duplicate"` // the idempotency key was used before, ID is the job dispatched then
+}
+
+type EnqueueJobsDTI struct {
+	Key  string          `json:"-"`
+	Jobs []EnqueueJobDTI `json:"jobs" validate:"required,min=1,max=1000,dive"`
+}
+
+type EnqueueJobsDTO struct {
+	Jobs []EnqueueJobDTO `json:"jobs"`
+}
+
+func (app *queueApp) EnqueueJob(ctx context.Context, input EnqueueJobDTI) (EnqueueJobDTO, error) {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return EnqueueJobDTO{}, err
+	}
+
+	j, err := app.newJob(input)
+	if err != nil {
+		return EnqueueJobDTO{}, err
+	}
+
+	jobID := j.ID
+	if err := q.Enqueue(ctx, j); err != nil {
+		return EnqueueJobDTO{}, err
+	}
+
+	return EnqueueJobDTO{
+		ID:        j.ID.String(),
+		Duplicate: j.ID != jobID,
+	}, nil
+}
+
+// EnqueueJobs validates all the jobs before enqueueing any, then enqueues them together.
+func (app *queueApp) EnqueueJobs(ctx context.Context, input EnqueueJobsDTI) (EnqueueJobsDTO, error) {
+	q, err := newQueue(input.Key)
+	if err != nil {
+		return EnqueueJobsDTO{}, err
+	}
+
+	jobs := make([]*job.Job, 0, len(input.Jobs))
+	invalid := exception.NewExceptionErrors(http.StatusUnprocessableEntity, "validation failed")
+	for i, jobInput := range input.Jobs {
+		j, err := app.newJob(jobInput)
+		var exceptionErrs *exception.ExceptionErrors
+		if errors.As(err, &exceptionErrs) {
+			for _, errItem := range exceptionErrs.ErrItems {
+				invalid.Append(&exception.ExceptionError{
+					Message:      fmt.Sprintf("jobs[%d]: %s", i, errItem.Message),
+					Type:         errItem.Type,
+					ErrorSubcode: errItem.ErrorSubcode,
+				})
+			}
+			continue
+		}
+		if err != nil {
+			return EnqueueJobsDTO{}, err
+		}
+		jobs = append(jobs, j)
+	}
+	if !invalid.IsEmpty() {
+		return EnqueueJobsDTO{}, invalid
+	}
+
+	jobIDs := make([]uuid.UUID, 0, len(jobs))
+	for _, j := range jobs {
+		jobIDs = append(jobIDs, j.ID)
+	}
+
+	if err := q.Enqueue(ctx, jobs...); err != nil {
+		return EnqueueJobsDTO{}, err
+	}
+
+	dtos := make([]EnqueueJobDTO, 0, len(jobs))
+	for i, j := range jobs {
+		dtos = append(dtos, EnqueueJobDTO{
+			ID:        j.ID.String(),
+			Duplicate: j.ID != jobIDs[i],
+		})
+	}
+
+	return EnqueueJobsDTO{Jobs: dtos}, nil
+}
+
+// newJob returns the job to dispatch, once its payload decoded into its handler.
+func (app *queueApp) newJob(input EnqueueJobDTI) (*job.Job, error) {
+	payload := input.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage("null")
+	}
+
+	if _, err := app.Handlers.Decode(input.HandlerName, payload); err != nil {
+		if errors.Is(err, job.ErrUnknownHandler) {
+			return nil, exception.UnknownJobHandlerError
+		}
+		return nil, exception.InvalidJobPayloadError
+	}
+
+	maxAttempts := input.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	j, err := job.NewJob(input.HandlerName, payload, maxAttempts, input.Delay)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.IdempotencyKey != "" {
+		j.Unique("idempotency:"+input.IdempotencyKey, job.UniqueUntilExpired, IdempotencyKeyTTL)
+	}
+
+	return j, nil
+}
+
+type GetBatchDTI struct {
+	ID string
+}
+
+type GetBatchDTO struct {
+	ID           string      `json:"id"`
+	Name         string      `json:"name"`
+	Queue        string      `json:"queue"`
+	TotalJobs    int         `json:"total_jobs"`
+	PendingJobs  int         `json:"pending_jobs"`
+	FailedJobs   int         `json:"failed_jobs"`
+	FailedJobIDs []uuid.UUID `json:"failed_job_ids"`
+	Progress     int         `json:"progress"` // percentage of the jobs that succeeded or failed for good
+	CreatedAt    time.Time   `json:"created_at"`
+	CancelledAt  *time.Time  `json:"cancelled_at"`
+	FinishedAt   *time.Time  `json:"finished_at"`
+}
+
+func (app *queueApp) GetBatchByID(ctx context.Context, input GetBatchDTI) (GetBatchDTO, error) {
+	batchID, err := uuid.Parse(input.ID)
+	if err != nil {
+		return GetBatchDTO{}, exception.InvalidIDError
+	}
+
+	batch, err := app.Repo.Batch.GetBatch(ctx, batchID)
+	if errors.Is(err, repository.ErrBatchNotFound) {
+		return GetBatchDTO{}, exception.DataNotFoundError
+	}
+	if err != nil {
+		return GetBatchDTO{}, err
+	}
+
+	progress := 100
+	if batch.TotalJobs > 0 {
+		progress = (batch.TotalJobs - batch.PendingJobs) * 100 / batch.TotalJobs
+	}
+
+	return GetBatchDTO{
+		ID:           batch.ID.String(),
+		Name:         batch.Name,
+		Queue:        batch.Queue,
+		TotalJobs:    batch.TotalJobs,
+		PendingJobs:  batch.PendingJobs,
+		FailedJobs:   batch.FailedJobs,
+		FailedJobIDs: batch.FailedJobIDs,
+		Progress:     progress,
+		CreatedAt:    batch.CreatedAt,
+		CancelledAt:  batch.CancelledAt,
+		FinishedAt:   batch.FinishedAt,
+	}, nil
+}
+
+type GetWorkerDTO struct {
+	ID            string        `json:"id"`
+	Hostname      string        `json:"hostname"`
+	PID           int           `json:"pid"`
+	Queues        []string      `json:"queues"`
+	CurrentJobID  *uuid.UUID    `json:"current_job_id"`
+	JobStartedAt  *time.Time    `json:"job_started_at"`
+	JobProgress   *job.Progress `json:"job_progress"`
+	StartedAt     time.Time     `json:"started_at"`
+	LastPolledAt  time.Time     `json:"last_polled_at"`
+	LastHeartbeat time.Time     `json:"last_heartbeat"`
+}
+
+func (app *queueApp) GetWorkers(ctx context.Context) ([]GetWorkerDTO, error) {
+	workers, err := queue.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]GetWorkerDTO, 0, len(workers))
+	for _, w := range workers {
+		dtos = append(dtos, GetWorkerDTO{
+			ID:            w.ID,
+			Hostname:      w.Hostname,
+			PID:           w.PID,
+			Queues:        w.Queues,
+			CurrentJobID:  w.CurrentJobID,
+			JobStartedAt:  w.JobStartedAt,
+			JobProgress:   w.JobProgress,
+			StartedAt:     w.StartedAt,
+			LastPolledAt:  w.LastPolledAt,
+			LastHeartbeat: w.LastHeartbeat,
+		})
+	}
+
+	return dtos, nil
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addTimeoutAndFailureReasonToJobTables)
+}
+
+var addTimeoutAndFailureReasonToJobTables = &Migration{
+	Name: "20261017100000_add_timeout_and_failure_reason_to_job_tables",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "timeout" INTEGER DEFAULT 0;
+
+		COMMENT ON COLUMN jobs.timeout IS 'The maximum number of seconds the job may run, 0 means no timeout.';
+
+		ALTER TABLE failed_jobs ADD COLUMN IF NOT EXISTS "reason" VARCHAR(255) DEFAULT 'error';
+
+		COMMENT ON COLUMN failed_jobs.reason IS 'Why the job failed, which can be one of the following: error, timeout, or lease_expired.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE failed_jobs DROP COLUMN IF EXISTS "reason";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "timeout";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
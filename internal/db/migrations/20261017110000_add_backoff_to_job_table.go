@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addBackoffToJobTable)
+}
+
+var addBackoffToJobTable = &Migration{
+	Name: "20261017110000_add_backoff_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "backoff" JSONB;
+
+		COMMENT ON COLUMN jobs.backoff IS 'The retry policy of the job, NULL means the job delay is used between attempts.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "backoff";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addPriorityToJobTable)
+}
+
+var addPriorityToJobTable = &Migration{
+	Name: "20261017120000_add_priority_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "priority" INTEGER DEFAULT 0;
+
+		COMMENT ON COLUMN jobs.priority IS 'Jobs with a priority above 0 are processed before the other jobs of their queue.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "priority";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addReservationColumnsToJobTable)
+}
+
+var addReservationColumnsToJobTable = &Migration{
+	Name: "20261017130000_add_reservation_columns_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "attempts" INTEGER DEFAULT 0;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "available_at" TIMESTAMPTZ DEFAULT NOW();
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "reserved_until" TIMESTAMPTZ;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "errors" JSONB;
+
+		COMMENT ON COLUMN jobs.available_at IS 'The time the job becomes ready, used by the postgres queue driver.';
+		COMMENT ON COLUMN jobs.reserved_until IS 'The lease deadline of a processing job, used by the postgres queue driver.';
+
+		CREATE INDEX IF NOT EXISTS idx_jobs_queue_status_available_at ON jobs (queue, status, available_at);
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			DROP INDEX IF EXISTS idx_jobs_queue_status_available_at;
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "errors";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "reserved_until";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "available_at";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "attempts";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
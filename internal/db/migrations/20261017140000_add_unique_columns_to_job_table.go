@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addUniqueColumnsToJobTable)
+}
+
+var addUniqueColumnsToJobTable = &Migration{
+	Name: "20261017140000_add_unique_columns_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "unique_key" VARCHAR(255);
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "unique_until" VARCHAR(255);
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "unique_for" INTEGER DEFAULT 0;
+
+		COMMENT ON COLUMN jobs.unique_until IS 'The point a unique job releases its lock at, which can be one of the following: processing or completed.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "unique_for";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "unique_until";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "unique_key";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
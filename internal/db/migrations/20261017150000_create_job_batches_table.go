@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, createJobBatchesTable)
+}
+
+var createJobBatchesTable = &Migration{
+	Name: "20261017150000_create_job_batches_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS job_batches (
+			"id" UUID PRIMARY KEY,
+			"name" VARCHAR(255),
+			"queue" VARCHAR(255),
+			"total_jobs" INTEGER DEFAULT 0,
+			"pending_jobs" INTEGER DEFAULT 0,
+			"failed_jobs" INTEGER DEFAULT 0,
+			"failed_job_ids" JSONB DEFAULT '[]'::JSONB,
+			"then_job" JSONB,
+			"catch_job" JSONB,
+			"finally_job" JSONB,
+			"created_at" TIMESTAMPTZ DEFAULT NOW(),
+			"cancelled_at" TIMESTAMPTZ,
+			"finished_at" TIMESTAMPTZ
+		  );
+
+		  COMMENT ON COLUMN job_batches.pending_jobs IS 'The number of jobs of the batch that have neither succeeded nor failed for good yet.';
+
+		  ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "chain" JSONB;
+		  ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "input" JSONB;
+		  ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "batch_id" UUID;
+
+		  CREATE INDEX IF NOT EXISTS idx_jobs_batch_id ON jobs (batch_id);
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			DROP INDEX IF EXISTS idx_jobs_batch_id;
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "batch_id";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "input";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "chain";
+			DROP TABLE IF EXISTS job_batches;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
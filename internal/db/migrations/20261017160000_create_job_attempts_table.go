@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, createJobAttemptsTable)
+}
+
+var createJobAttemptsTable = &Migration{
+	Name: "20261017160000_create_job_attempts_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS job_attempts (
+			"id" SERIAL PRIMARY KEY,
+			"job_id" UUID,
+			"queue" VARCHAR(255),
+			"attempt" INTEGER,
+			"worker" VARCHAR(255),
+			"status" VARCHAR(255),
+			"error" TEXT,
+			"stack_trace" TEXT,
+			"started_at" TIMESTAMPTZ,
+			"finished_at" TIMESTAMPTZ,
+			"duration_ms" BIGINT
+		  );
+
+		  COMMENT ON COLUMN job_attempts.status IS 'The outcome of the attempt, which can be one of the following: completed, failed or released.';
+
+		  CREATE INDEX IF NOT EXISTS idx_job_attempts_job_id ON job_attempts (job_id);
+
+		  ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "result" JSONB;
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "result";
+			DROP TABLE IF EXISTS job_attempts;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
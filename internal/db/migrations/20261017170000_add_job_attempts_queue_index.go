@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addJobAttemptsQueueIndex)
+}
+
+var addJobAttemptsQueueIndex = &Migration{
+	Name: "20261017170000_add_job_attempts_queue_index",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		  CREATE INDEX IF NOT EXISTS idx_job_attempts_queue_finished_at ON job_attempts (queue, finished_at);
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			DROP INDEX IF EXISTS idx_job_attempts_queue_finished_at;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
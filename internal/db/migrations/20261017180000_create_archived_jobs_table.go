@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, createArchivedJobsTable)
+}
+
+var createArchivedJobsTable = &Migration{
+	Name: "20261017180000_create_archived_jobs_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS archived_jobs (
+			"job_id" UUID PRIMARY KEY,
+			"queue" VARCHAR(255),
+			"status" VARCHAR(255),
+			"job" JSONB,
+			"failed_job" JSONB,
+			"attempts" JSONB,
+			"archived_at" TIMESTAMPTZ DEFAULT NOW()
+		  );
+
+		  COMMENT ON TABLE archived_jobs IS 'Jobs removed by queue:prune --archive, with their failed_jobs row and attempts as they were when pruned.';
+
+		  CREATE INDEX IF NOT EXISTS idx_archived_jobs_queue_archived_at ON archived_jobs (queue, archived_at);
+		  CREATE INDEX IF NOT EXISTS idx_jobs_status_queue_updated_at ON jobs (status, queue, updated_at);
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			DROP INDEX IF EXISTS idx_jobs_status_queue_updated_at;
+			DROP TABLE IF EXISTS archived_jobs;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
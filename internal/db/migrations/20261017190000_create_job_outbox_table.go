@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, createJobOutboxTable)
+}
+
+var createJobOutboxTable = &Migration{
+	Name: "20261017190000_create_job_outbox_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS job_outbox (
+			"id" BIGSERIAL PRIMARY KEY,
+			"queue" VARCHAR(255),
+			"job" JSONB,
+			"attempts" INTEGER DEFAULT 0,
+			"last_error" TEXT,
+			"available_at" TIMESTAMPTZ DEFAULT NOW(),
+			"created_at" TIMESTAMPTZ DEFAULT NOW()
+		  );
+
+		  COMMENT ON TABLE job_outbox IS 'Jobs enqueued within a transaction, moved to their queue by queue:relay once it committed.';
+
+		  CREATE INDEX IF NOT EXISTS idx_job_outbox_available_at ON job_outbox (available_at);
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			DROP TABLE IF EXISTS job_outbox;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
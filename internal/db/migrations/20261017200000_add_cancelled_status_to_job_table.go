@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addCancelledStatusToJobTable)
+}
+
+var addCancelledStatusToJobTable = &Migration{
+	Name: "20261017200000_add_cancelled_status_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		COMMENT ON COLUMN jobs.status IS 'The status of the job, which can be one of the following: pending, processing, completed, failed, or cancelled.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			UPDATE jobs SET status = 'failed' WHERE status = 'cancelled';
+
+			COMMENT ON COLUMN jobs.status IS 'The status of the job, which can be one of the following: pending, processing, completed, or failed.';
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+)
+
+func init() {
+	Migrations = append(Migrations, addProgressColumnsToJobTable)
+}
+
+var addProgressColumnsToJobTable = &Migration{
+	Name: "20261017210000_add_progress_columns_to_job_table",
+	Up: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "progress" DOUBLE PRECISION;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "progress_message" TEXT;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS "progress_updated_at" TIMESTAMPTZ;
+
+		COMMENT ON COLUMN jobs.progress IS 'The last percentage reported by the handler of the job, mirrored from redis by the worker.';
+		`)
+
+		if err != nil {
+			return err
+		}
+		return nil
+
+	},
+	Down: func() error {
+		_, err := pgx.GetPgxPool().Exec(context.Background(), `
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "progress_updated_at";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "progress_message";
+			ALTER TABLE jobs DROP COLUMN IF EXISTS "progress";
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Batch struct {
+	ID           uuid.UUID       `json:"id"`
+	Name         string          `json:"name"`
+	Queue        string          `json:"queue"`
+	TotalJobs    int             `json:"total_jobs"`
+	PendingJobs  int             `json:"pending_jobs"` // jobs that have neither succeeded nor failed for good yet
+	FailedJobs   int             `json:"failed_jobs"`
+	FailedJobIDs []uuid.UUID     `json:"failed_job_ids"`
+	ThenJob      json.RawMessage `json:"-"` // dispatched when every job succeeded
+	CatchJob     json.RawMessage `json:"-"` // dispatched when the first job fails for good
+	FinallyJob   json.RawMessage `json:"-"` // dispatched when the batch finished, successfully or not
+	CreatedAt    time.Time       `json:"created_at"`
+	CancelledAt  *time.Time      `json:"cancelled_at"`
+	FinishedAt   *time.Time      `json:"finished_at"`
+}
@@ -14,7 +14,17 @@ type Job struct {
 	Payload     json.RawMessage `json:"payload"`
 	MaxAttempts int             `json:"max_attempts"`
 	Delay       int             `json:"delay"`
-	Status      string          `json:"status"` // "pending", "processing", "completed", "failed"
+	Timeout     int             `json:"timeout"`
+	Backoff     json.RawMessage `json:"backoff"`
+	Priority    int             `json:"priority"`
+	UniqueKey   string          `json:"unique_key"`
+	UniqueUntil string          `json:"unique_until"` // "", "processing", "completed"
+	UniqueFor   int             `json:"unique_for"`
+	Chain       json.RawMessage `json:"chain"`
+	Input       json.RawMessage `json:"input"`
+	BatchID     *uuid.UUID      `json:"batch_id"`
+	Result      json.RawMessage `json:"result"`
+	Status      string          `json:"status"` // "pending", "processing", "completed", "failed", "cancelled"
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 	FailedJob   []FaildJob      `json:"failed_job"`
@@ -26,5 +36,27 @@ type FaildJob struct {
 	Queue    string          `json:"queue"`
 	Payload  json.RawMessage `json:"payload"`
 	Error    string          `json:"error"`
+	Reason   string          `json:"reason"` // "error", "timeout", "lease_expired"
 	FailedAt time.Time       `json:"failed_at"`
 }
+
+type JobAttempt struct {
+	ID         int       `json:"id"`
+	JobID      uuid.UUID `json:"job_id"`
+	Queue      string    `json:"queue"`
+	Attempt    int       `json:"attempt"`
+	Worker     string    `json:"worker"`
+	Status     string    `json:"status"` // "completed", "failed", "released", "cancelled"
+	Error      string    `json:"error"`
+	StackTrace string    `json:"stack_trace"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+type JobProgress struct {
+	JobID     uuid.UUID  `json:"job_id"`
+	Percent   float64    `json:"percent"`
+	Message   string     `json:"message"`
+	UpdatedAt *time.Time `json:"updated_at"` // nil if the job reported no progress
+}
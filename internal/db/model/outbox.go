@@ -0,0 +1,16 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type OutboxJob struct {
+	ID          int64           `json:"id"`
+	Queue       string          `json:"queue"`
+	Job         json.RawMessage `json:"job"` // the job as it is pushed to the queue
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error"`
+	AvailableAt time.Time       `json:"available_at"` // when the relay may publish the job, later after a failed publish
+	CreatedAt   time.Time       `json:"created_at"`
+}
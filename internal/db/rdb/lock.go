@@ -0,0 +1,23 @@
+package rdb
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// releaseLockScript deletes a lock only if it is still held by the given token.
+//
+//	KEYS: lock key
+//	ARGV: token
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ReleaseLock deletes the lock at key if it is still held by token, a lock taken over by someone else is left alone.
+func ReleaseLock(ctx context.Context, client redis.Cmdable, key string, token string) error {
+	return releaseLockScript.Run(ctx, client, []string{key}, token).Err()
+}
@@ -0,0 +1,273 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
+)
+
+// ErrEmptyBatch is returned by DispatchBatch for a batch without jobs.
+var ErrEmptyBatch = errors.New("batch has no jobs")
+
+// ErrUniqueJobInBatch is returned by DispatchBatch for a batch with a unique job, a skipped duplicate would never finish.
+var ErrUniqueJobInBatch = errors.New("batch jobs cannot be unique")
+
+// Batch is a group of jobs whose progress is tracked in postgres, see DispatchBatch.
+// The callback jobs are dispatched to the queue of the batch and receive the batch as their input.
+type Batch struct {
+	Name string
+	Jobs []*job.Job
+
+	Then    *job.Job // Then is dispatched when every job of the batch succeeded.
+	Catch   *job.Job // Catch is dispatched when the first job of the batch failed for good.
+	Finally *job.Job // Finally is dispatched when every job of the batch succeeded or failed for good.
+}
+
+// batchProvider is implemented by the drivers that keep track of batches themselves.
+type batchProvider interface {
+	Batches() repository.BatchRepository
+}
+
+// batches returns the batches of the queue driver, postgres ones if the driver has none.
+func (q *Queue) batches() repository.BatchRepository {
+	if provider, ok := q.driver.(batchProvider); ok {
+		return provider.Batches()
+	}
+	return repository.NewBatchRepository(pgx.GetPgxPool())
+}
+
+// DispatchBatch records the batch and adds its jobs to the queue, it returns the ID of the batch.
+func (q *Queue) DispatchBatch(ctx context.Context, b Batch) (uuid.UUID, error) {
+	if len(b.Jobs) == 0 {
+		return uuid.Nil, ErrEmptyBatch
+	}
+
+	for _, j := range b.Jobs {
+		if j.IsUnique() {
+			return uuid.Nil, ErrUniqueJobInBatch
+		}
+	}
+
+	for _, callback := range []*job.Job{b.Then, b.Catch, b.Finally} {
+		if callback == nil {
+			continue
+		}
+		if err := job.CheckRegistered(callback); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	batch := model.Batch{
+		ID:          uuid.New(),
+		Name:        b.Name,
+		Queue:       q.KeyWithoutPrefix,
+		TotalJobs:   len(b.Jobs),
+		PendingJobs: len(b.Jobs),
+		CreatedAt:   time.Now(),
+	}
+
+	var err error
+	if batch.ThenJob, err = marshalCallback(b.Then); err != nil {
+		return uuid.Nil, err
+	}
+	if batch.CatchJob, err = marshalCallback(b.Catch); err != nil {
+		return uuid.Nil, err
+	}
+	if batch.FinallyJob, err = marshalCallback(b.Finally); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := q.batches().AddBatch(ctx, batch); err != nil {
+		return uuid.Nil, fmt.Errorf("error adding batch: %w", err)
+	}
+
+	for _, j := range b.Jobs {
+		j.BatchID = &batch.ID
+	}
+
+	if err := q.Enqueue(ctx, b.Jobs...); err != nil {
+		// Nothing was queued, so nothing would ever finish the batch
+		if _, cancelErr := q.batches().CancelBatch(ctx, batch.ID); cancelErr != nil {
+			logger.Log.Error("Error canceling batch", zap.String("batch_id", batch.ID.String()), zap.Error(cancelErr))
+		}
+		return uuid.Nil, err
+	}
+
+	return batch.ID, nil
+}
+
+// GetBatch returns the batch with the given ID, or repository.ErrBatchNotFound.
+func (q *Queue) GetBatch(ctx context.Context, batchID uuid.UUID) (model.Batch, error) {
+	return q.batches().GetBatch(ctx, batchID)
+}
+
+// CancelBatch cancels the batch with the given ID. The jobs of a canceled batch that were not processed yet
+// are skipped by the workers, the finally callback still runs once the batch finished.
+func (q *Queue) CancelBatch(ctx context.Context, batchID uuid.UUID) error {
+	canceled, err := q.batches().CancelBatch(ctx, batchID)
+	if err != nil {
+		return err
+	}
+
+	if !canceled {
+		return fmt.Errorf("batch with ID %s not found or already finished", batchID)
+	}
+
+	return nil
+}
+
+// marshalCallback encodes a callback job of a batch, nil if it is not set.
+// Its payload is encrypted first if its handler opts in to it, so it is not stored in clear with the batch.
+func marshalCallback(j *job.Job) ([]byte, error) {
+	if j == nil {
+		return nil, nil
+	}
+	if err := encryptPayloads([]*job.Job{j}); err != nil {
+		return nil, err
+	}
+	return sonic.Marshal(j)
+}
+
+// batchCanceled reports whether the job belongs to a canceled batch.
+func (q *Queue) batchCanceled(ctx context.Context, j *job.Job) bool {
+	if j.BatchID == nil {
+		return false
+	}
+
+	batch, err := q.batches().GetBatch(ctx, *j.BatchID)
+	if err != nil {
+		logger.Log.Error("Error getting batch", zap.String("batch_id", j.BatchID.String()), zap.Error(err))
+		return false
+	}
+
+	return batch.CancelledAt != nil
+}
+
+// settleBatch counts a job that succeeded or failed for good in its batch, and dispatches the callbacks that are due.
+func (q *Queue) settleBatch(ctx context.Context, j *job.Job, failed bool) {
+	if j.BatchID == nil {
+		return
+	}
+
+	batch, err := q.batches().RecordBatchJob(ctx, *j.BatchID, j.ID, failed)
+	if err != nil {
+		logger.Log.Error("Error recording batch job", zap.String("batch_id", j.BatchID.String()), zap.String("job_id", j.ID.String()), zap.Error(err))
+		return
+	}
+
+	if failed && batch.FailedJobs == 1 {
+		q.dispatchCallback(ctx, batch, batch.CatchJob)
+	}
+
+	if batch.PendingJobs > 0 {
+		return
+	}
+
+	logger.Log.Info("Batch finished", zap.String("batch_id", batch.ID.String()), zap.Int("failed_jobs", batch.FailedJobs))
+	if batch.FailedJobs == 0 && batch.CancelledAt == nil {
+		q.dispatchCallback(ctx, batch, batch.ThenJob)
+	}
+	q.dispatchCallback(ctx, batch, batch.FinallyJob)
+}
+
+// dispatchCallback adds a callback job of the batch to the queue of the batch, with the batch as its input.
+func (q *Queue) dispatchCallback(ctx context.Context, batch model.Batch, callback []byte) {
+	if len(callback) == 0 || string(callback) == "null" {
+		return
+	}
+
+	var j job.Job
+	err := sonic.Unmarshal(callback, &j)
+	if err == nil {
+		j.Input, err = sonic.Marshal(batch)
+	}
+	if err == nil {
+		err = NewQueueWithDriver(batch.Queue, q.driver).Enqueue(ctx, &j)
+	}
+
+	if err != nil {
+		logger.Log.Error("Error dispatching batch callback", zap.String("batch_id", batch.ID.String()), zap.Error(err))
+	}
+}
+
+// memoryBatches keeps batches in process memory, for the memory driver.
+type memoryBatches struct {
+	mu      sync.Mutex
+	batches map[uuid.UUID]model.Batch
+}
+
+// NewMemoryBatches returns batches kept in process memory.
+func NewMemoryBatches() repository.BatchRepository {
+	return &memoryBatches{
+		batches: make(map[uuid.UUID]model.Batch),
+	}
+}
+
+func (m *memoryBatches) AddBatch(ctx context.Context, batch model.Batch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch.FailedJobIDs = []uuid.UUID{}
+	m.batches[batch.ID] = batch
+	return nil
+}
+
+func (m *memoryBatches) GetBatch(ctx context.Context, batchID uuid.UUID) (model.Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, ok := m.batches[batchID]
+	if !ok {
+		return model.Batch{}, repository.ErrBatchNotFound
+	}
+	return batch, nil
+}
+
+func (m *memoryBatches) RecordBatchJob(ctx context.Context, batchID uuid.UUID, jobID uuid.UUID, failed bool) (model.Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, ok := m.batches[batchID]
+	if !ok || batch.PendingJobs == 0 {
+		return model.Batch{}, repository.ErrBatchNotFound
+	}
+
+	batch.PendingJobs--
+	if failed {
+		batch.FailedJobs++
+		batch.FailedJobIDs = append(batch.FailedJobIDs, jobID)
+	}
+	if batch.PendingJobs == 0 {
+		now := time.Now()
+		batch.FinishedAt = &now
+	}
+
+	m.batches[batchID] = batch
+	return batch, nil
+}
+
+func (m *memoryBatches) CancelBatch(ctx context.Context, batchID uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, ok := m.batches[batchID]
+	if !ok || batch.CancelledAt != nil || batch.FinishedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	batch.CancelledAt = &now
+	m.batches[batchID] = batch
+	return true, nil
+}
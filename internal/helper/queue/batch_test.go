@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newExampleJob(t *testing.T, data string) *job.Job {
+	j, err := job.NewJob("ProcessExample", &job.ProcessExample{Data: data}, 1, 0)
+	require.NoError(t, err)
+	return j
+}
+
+func Test_chain(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+	first, err := job.NewChain(newExampleJob(t, "a"), newExampleJob(t, "b"), newExampleJob(t, "c"))
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(ctx, first))
+
+	// Only the first job of the chain is queued
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+
+	dequeued, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.acknowledge(ctx, dequeued.ID, []byte(`{"user_id":42}`), nil))
+
+	second, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	assert.JSONEq(t, `{"data":"b"}`, string(second.Payload))
+	assert.JSONEq(t, `{"user_id":42}`, string(second.Input), "the next job receives the output of the previous one")
+	assert.Len(t, second.Chain, 1)
+
+	// A job that fails for good stops the chain
+	require.NoError(t, q.acknowledge(ctx, second.ID, nil, errors.New("boom")))
+
+	isEmpty, err := q.IsEmpty(ctx)
+	require.NoError(t, err)
+	assert.True(t, isEmpty)
+}
+
+func Test_batch(t *testing.T) {
+	ctx := context.Background()
+
+	// processAll acknowledges every job of the batch, failing the ones in fail, and returns the dispatched callbacks.
+	processAll := func(t *testing.T, q *Queue, batchID uuid.UUID, fail map[string]bool) []*job.Job {
+		var callbacks []*job.Job
+		for {
+			j, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			if j == nil {
+				return callbacks
+			}
+
+			if j.BatchID == nil {
+				callbacks = append(callbacks, j)
+				continue
+			}
+			assert.Equal(t, batchID, *j.BatchID)
+
+			var payload job.ProcessExample
+			require.NoError(t, sonic.Unmarshal(j.Payload, &payload))
+
+			var jobError error
+			if fail[payload.Data] {
+				jobError = errors.New("boom")
+			}
+			require.NoError(t, q.RemoveProcessed(ctx, j.ID, jobError))
+		}
+	}
+
+	callbackNames := func(callbacks []*job.Job) []string {
+		var names []string
+		for _, j := range callbacks {
+			var payload job.ProcessExample
+			require.NoError(t, sonic.Unmarshal(j.Payload, &payload))
+			names = append(names, payload.Data)
+		}
+		return names
+	}
+
+	newBatch := func(t *testing.T) Batch {
+		return Batch{
+			Name:    "import users",
+			Jobs:    []*job.Job{newExampleJob(t, "a"), newExampleJob(t, "b"), newExampleJob(t, "c")},
+			Then:    newExampleJob(t, "then"),
+			Catch:   newExampleJob(t, "catch"),
+			Finally: newExampleJob(t, "finally"),
+		}
+	}
+
+	t.Run("a successful batch dispatches then and finally", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		batchID, err := q.DispatchBatch(ctx, newBatch(t))
+		require.NoError(t, err)
+
+		callbacks := processAll(t, q, batchID, nil)
+		assert.Equal(t, []string{"then", "finally"}, callbackNames(callbacks))
+
+		var input model.Batch
+		require.NoError(t, sonic.Unmarshal(callbacks[0].Input, &input))
+		assert.Equal(t, batchID, input.ID, "callbacks receive the batch as their input")
+
+		batch, err := q.GetBatch(ctx, batchID)
+		require.NoError(t, err)
+		assert.Equal(t, 3, batch.TotalJobs)
+		assert.Equal(t, 0, batch.PendingJobs)
+		assert.NotNil(t, batch.FinishedAt)
+	})
+
+	t.Run("a failed job dispatches catch once, and finally", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		batchID, err := q.DispatchBatch(ctx, newBatch(t))
+		require.NoError(t, err)
+
+		callbacks := processAll(t, q, batchID, map[string]bool{"a": true, "b": true})
+		assert.Equal(t, []string{"catch", "finally"}, callbackNames(callbacks))
+
+		batch, err := q.GetBatch(ctx, batchID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, batch.FailedJobs)
+		assert.Len(t, batch.FailedJobIDs, 2)
+	})
+
+	t.Run("the jobs of a canceled batch are skipped", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		batchID, err := q.DispatchBatch(ctx, newBatch(t))
+		require.NoError(t, err)
+		require.NoError(t, q.CancelBatch(ctx, batchID))
+
+		for {
+			j, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			if j == nil {
+				break
+			}
+			if j.BatchID == nil {
+				assert.Equal(t, []string{"finally"}, callbackNames([]*job.Job{j}))
+				continue
+			}
+			require.True(t, q.batchCanceled(ctx, j))
+			require.NoError(t, q.skipCanceled(ctx, j))
+		}
+
+		batch, err := q.GetBatch(ctx, batchID)
+		require.NoError(t, err)
+		assert.NotNil(t, batch.CancelledAt)
+		assert.Equal(t, 0, batch.PendingJobs)
+		assert.Error(t, q.CancelBatch(ctx, batchID), "a finished batch cannot be canceled")
+	})
+
+	t.Run("batches without jobs or with unique jobs are rejected", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+		_, err := q.DispatchBatch(ctx, Batch{Name: "empty"})
+		assert.ErrorIs(t, err, ErrEmptyBatch)
+
+		unique := newExampleJob(t, "a").Unique("", job.UniqueUntilCompleted, 0)
+		_, err = q.DispatchBatch(ctx, Batch{Name: "unique", Jobs: []*job.Job{unique}})
+		assert.ErrorIs(t, err, ErrUniqueJobInBatch)
+	})
+}
@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ErrJobFinished is returned by Cancel for a job that already completed, failed or was cancelled.
+var ErrJobFinished = errors.New("job is already finished")
+
+// CancelledJobTTL is how long a job stays flagged as cancelled. A job that is not reserved within it, such as
+// one delayed for longer, runs as if it was never cancelled.
+const CancelledJobTTL = 7 * 24 * time.Hour
+
+// cancellationPollInterval is how often a worker checks whether the job it runs was cancelled.
+var cancellationPollInterval = time.Second
+
+// CancelledJobs holds the IDs of the jobs that were cancelled but not settled by a worker yet.
+type CancelledJobs interface {
+	Cancel(ctx context.Context, jobID uuid.UUID) error
+	IsCancelled(ctx context.Context, jobID uuid.UUID) (bool, error)
+	// Forget removes the flag of a job once it is settled.
+	Forget(ctx context.Context, jobID uuid.UUID) error
+}
+
+// cancelProvider is implemented by the drivers that keep track of cancelled jobs themselves.
+type cancelProvider interface {
+	CancelledJobs() CancelledJobs
+}
+
+// cancelledJobs returns the cancelled jobs of the queue driver, redis ones if the driver has none.
+func (q *Queue) cancelledJobs() CancelledJobs {
+	if provider, ok := q.driver.(cancelProvider); ok {
+		return provider.CancelledJobs()
+	}
+	return NewRedisCancelledJobs(rdb.GetRedisClient())
+}
+
+/*
+Cancel cancels a pending or running job. A pending job is skipped when a worker reserves it, a running job
+has the context of its handler canceled with job.ErrCancelled as its cause:
+
+	if errors.Is(context.Cause(ctx), job.ErrCancelled) {
+		// stop early, the job will not be retried
+	}
+
+Either way the job ends with the cancelled status, it is not retried and the rest of its chain is not dispatched.
+A handler that ignores its context and finishes its work still succeeds.
+It returns repository.ErrJobNotFound for a job that is not in the queue and ErrJobFinished for a finished one,
+if the driver records the jobs, see historyProvider.
+*/
+func (q *Queue) Cancel(ctx context.Context, jobID uuid.UUID) error {
+	if history := q.history(); history != nil {
+		j, err := history.GetJobByID(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		if j.Queue != q.KeyWithoutPrefix {
+			return repository.ErrJobNotFound
+		}
+		if j.Status != job.StatusPending && j.Status != job.StatusProcessing {
+			return ErrJobFinished
+		}
+	}
+
+	return q.cancelledJobs().Cancel(ctx, jobID)
+}
+
+// CancelJobByID cancels the job with the given ID on the queue the job history knows it in, and returns the queue.
+func CancelJobByID(ctx context.Context, jobID uuid.UUID) (string, error) {
+	driver := DefaultDriver()
+
+	var queue string
+	if history := NewQueueWithDriver("", driver).history(); history != nil {
+		j, err := history.GetJobByID(ctx, jobID)
+		if err != nil {
+			return "", err
+		}
+		queue = j.Queue
+	}
+
+	return queue, NewQueueWithDriver(queue, driver).Cancel(ctx, jobID)
+}
+
+// jobCancelled reports whether the job was cancelled, see Cancel.
+func (q *Queue) jobCancelled(ctx context.Context, jobID uuid.UUID) bool {
+	cancelled, err := q.cancelledJobs().IsCancelled(ctx, jobID)
+	if err != nil {
+		logger.Log.Error("Error checking whether job is cancelled", zap.String("job_id", jobID.String()), zap.Error(err))
+		return false
+	}
+	return cancelled
+}
+
+// watchCancellation returns a context canceled with job.ErrCancelled as its cause once the job is cancelled.
+// The returned function stops watching, it must be called once the handler returned.
+func (q *Queue) watchCancellation(ctx context.Context, jobID uuid.UUID) (context.Context, func()) {
+	watchCtx, cancel := context.WithCancelCause(ctx)
+
+	go func() {
+		ticker := time.NewTicker(cancellationPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-ticker.C:
+				if q.jobCancelled(watchCtx, jobID) {
+					logger.Log.Info("Job cancelled, stopping its handler", zap.String("job_id", jobID.String()), zap.String("queue", q.KeyWithoutPrefix))
+					cancel(job.ErrCancelled)
+					return
+				}
+			}
+		}
+	}()
+
+	return watchCtx, func() { cancel(nil) }
+}
+
+// settleCancelled removes a cancelled job from the queue and records it as cancelled, the rest of its chain is dropped.
+// The job counts as failed in its batch, as its work was not done.
+func (q *Queue) settleCancelled(ctx context.Context, j *job.Job) error {
+	completed, err := q.driver.Complete(ctx, q.KeyWithoutPrefix, j)
+	if err != nil || !completed {
+		return err
+	}
+	logger.Log.Info("Job cancelled", zap.String("job_id", j.ID.String()), zap.String("queue", q.KeyWithoutPrefix))
+
+	if history := q.history(); history != nil {
+		if err := history.UpdateJobStatus(ctx, j.ID, job.StatusCancelled); err != nil {
+			logger.Log.Error("Error recording cancelled job", zap.String("job_id", j.ID.String()), zap.Error(err))
+		}
+	}
+	if err := q.cancelledJobs().Forget(ctx, j.ID); err != nil {
+		logger.Log.Error("Error forgetting cancelled job", zap.String("job_id", j.ID.String()), zap.Error(err))
+	}
+
+	q.releaseUnique(ctx, j, job.UniqueUntilCompleted)
+	q.settleBatch(ctx, j, true)
+	q.publish(ctx, job.EventCancelled, j, nil)
+	return nil
+}
+
+type redisCancelledJobs struct {
+	client redis.Cmdable
+}
+
+// NewRedisCancelledJobs returns cancelled jobs kept as Redis keys expiring after CancelledJobTTL.
+func NewRedisCancelledJobs(client redis.Cmdable) CancelledJobs {
+	return &redisCancelledJobs{
+		client: client,
+	}
+}
+
+// key returns the key of the flag of a job, it must not start with the queue prefix to stay out of ListQueueKeys.
+func (c *redisCancelledJobs) key(jobID uuid.UUID) string {
+	return rdb.AddPrefix("cancelled_job:" + jobID.String())
+}
+
+func (c *redisCancelledJobs) Cancel(ctx context.Context, jobID uuid.UUID) error {
+	return c.client.Set(ctx, c.key(jobID), 1, CancelledJobTTL).Err()
+}
+
+func (c *redisCancelledJobs) IsCancelled(ctx context.Context, jobID uuid.UUID) (bool, error) {
+	count, err := c.client.Exists(ctx, c.key(jobID)).Result()
+	return count > 0, err
+}
+
+func (c *redisCancelledJobs) Forget(ctx context.Context, jobID uuid.UUID) error {
+	return c.client.Del(ctx, c.key(jobID)).Err()
+}
+
+type memoryCancelledJobs struct {
+	mu        sync.Mutex
+	cancelled map[uuid.UUID]bool
+}
+
+// NewMemoryCancelledJobs returns cancelled jobs kept in process memory.
+func NewMemoryCancelledJobs() CancelledJobs {
+	return &memoryCancelledJobs{
+		cancelled: make(map[uuid.UUID]bool),
+	}
+}
+
+func (c *memoryCancelledJobs) Cancel(ctx context.Context, jobID uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cancelled[jobID] = true
+	return nil
+}
+
+func (c *memoryCancelledJobs) IsCancelled(ctx context.Context, jobID uuid.UUID) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cancelled[jobID], nil
+}
+
+func (c *memoryCancelledJobs) Forget(ctx context.Context, jobID uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.cancelled, jobID)
+	return nil
+}
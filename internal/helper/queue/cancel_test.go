@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cancel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a pending job is skipped", func(t *testing.T) {
+		var events []job.EventType
+		unsubscribe := job.SubscribeHandler("EventTest", func(ctx context.Context, event job.Event) {
+			events = append(events, event.Type)
+		})
+		defer unsubscribe()
+
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("EventTest", eventTestPayload{}, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+		require.NoError(t, q.Cancel(ctx, j.ID))
+
+		require.NoError(t, RunWorker(ctx, WorkerOptions{StopWhenEmpty: true}, q))
+		assert.Equal(t, []job.EventType{job.EventEnqueued, job.EventCancelled}, events, "the job should not start")
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{}, stats)
+		assert.False(t, q.jobCancelled(ctx, j.ID), "the flag is removed once the job is settled")
+	})
+
+	t.Run("a running job has its context canceled", func(t *testing.T) {
+		pollInterval := cancellationPollInterval
+		cancellationPollInterval = 10 * time.Millisecond
+		defer func() { cancellationPollInterval = pollInterval }()
+
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("ShutdownTest", shutdownTestPayload{}, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- RunWorker(ctx, WorkerOptions{MaxJobs: 1}, q)
+		}()
+
+		<-shutdownTestStarted
+		require.NoError(t, q.Cancel(ctx, j.ID))
+
+		select {
+		case err := <-stopped:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the cancelled job did not stop")
+		}
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{}, stats, "a cancelled job is neither retried nor failed")
+	})
+}
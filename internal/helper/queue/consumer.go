@@ -0,0 +1,32 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+type consumerKey struct{}
+
+// WithConsumer names the worker running with ctx, drivers with consumer ownership (see DriverStream)
+// reserve jobs on its behalf.
+func WithConsumer(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, consumerKey{}, name)
+}
+
+// ConsumerFromContext returns the worker name carried by ctx, or the default consumer name of the process.
+func ConsumerFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(consumerKey{}).(string); ok && name != "" {
+		return name
+	}
+	return NewConsumerName(0)
+}
+
+// NewConsumerName returns a name unique to the given worker of this process, e.g. "web-1-4242-0".
+func NewConsumerName(worker int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), worker)
+}
@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	DriverRedis    = "redis"    // DriverRedis keeps jobs in Redis lists and mirrors their status to the jobs table.
+	DriverStream   = "stream"   // DriverStream keeps jobs in Redis Streams read by a consumer group and mirrors their status to the jobs table.
+	DriverPostgres = "postgres" // DriverPostgres keeps jobs in the jobs table and reserves them with SELECT ... FOR UPDATE SKIP LOCKED, it still needs Redis, see postgresDriver.
+	DriverMemory   = "memory"   // DriverMemory keeps jobs in process memory, it is meant for unit tests.
+)
+
+// Driver is the storage backend of a Queue.
+// A job moves from ready (or delayed) to reserved when a worker takes it,
+// then it is completed, released for a retry, or buried in the failed list.
+type Driver interface {
+	// Push adds new jobs to the queue. A job with a delay becomes ready once the delay has passed.
+	Push(ctx context.Context, queue string, jobs ...*job.Job) error
+	// Restore adds jobs recovered from the jobs table back to the queue, or to its failed list.
+	Restore(ctx context.Context, queue string, failed bool, jobs ...*job.Job) error
+
+	// Reserve takes the next ready job, increments its attempts and leases it.
+	// It waits up to wait for a job to become ready and returns nil if there is none.
+	Reserve(ctx context.Context, queue string, lease time.Duration, wait time.Duration) (*job.Job, error)
+	// Extend renews the lease of a reserved job, it returns ErrLeaseLost if the job is no longer reserved.
+	Extend(ctx context.Context, queue string, jobID uuid.UUID, lease time.Duration) (time.Time, error)
+	// Reserved returns the reserved job with the given ID, or nil if it is not reserved.
+	Reserved(ctx context.Context, queue string, jobID uuid.UUID) (*job.Job, error)
+	// Expired claims the reserved jobs whose lease has expired and returns their IDs.
+	Expired(ctx context.Context, queue string, lease time.Duration) ([]uuid.UUID, error)
+
+	// Complete, Release and Bury settle a reserved job. They report false, and do nothing,
+	// if the job was not reserved anymore, e.g. because it was reaped meanwhile.
+
+	// Complete removes a reserved job from the queue after it succeeded.
+	Complete(ctx context.Context, queue string, j *job.Job) (bool, error)
+	// Release puts a reserved job back in the queue, to become ready at the given time.
+	Release(ctx context.Context, queue string, j *job.Job, at time.Time) (bool, error)
+	// Bury moves a reserved job to the failed list.
+	Bury(ctx context.Context, queue string, j *job.Job, reason string) (bool, error)
+	// Promote makes the delayed jobs that are due ready and returns how many were promoted.
+	Promote(ctx context.Context, queue string) (int, error)
+
+	// RetryFailed moves a failed job back to the queue with its attempts reset.
+	RetryFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error)
+	// RetryAllFailed moves all failed jobs back to the queue with their attempts reset.
+	RetryAllFailed(ctx context.Context, queue string) (int, error)
+	// RemoveFailed deletes a failed job.
+	RemoveFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error)
+	// FlushFailed deletes all failed jobs and returns how many were deleted.
+	FlushFailed(ctx context.Context, queue string) (int64, error)
+	// Remove deletes a ready job.
+	Remove(ctx context.Context, queue string, jobID uuid.UUID) (bool, error)
+	// Clear deletes all ready and delayed jobs and returns how many were deleted.
+	Clear(ctx context.Context, queue string) (int64, error)
+
+	// Peek returns the next count ready jobs, in the order they will be reserved.
+	Peek(ctx context.Context, queue string, count int64) ([]*job.Job, error)
+	// PeekDelayed returns the count delayed jobs that are due soonest.
+	PeekDelayed(ctx context.Context, queue string, count int64) ([]*job.Job, error)
+	// PeekFailed returns count failed jobs starting at offset, the most recently failed first.
+	PeekFailed(ctx context.Context, queue string, offset int64, count int64) ([]*job.Job, error)
+	// Stats counts the jobs of the queue in every state.
+	Stats(ctx context.Context, queue string) (Stats, error)
+	// Queues lists the queues that hold jobs.
+	Queues(ctx context.Context) ([]string, error)
+}
+
+// Stats holds the number of jobs of a queue in every state.
+type Stats struct {
+	Ready    int64 `json:"ready"`
+	Reserved int64 `json:"reserved"`
+	Delayed  int64 `json:"delayed"`
+	Failed   int64 `json:"failed"`
+}
+
+var defaultDriver Driver
+var m sync.Mutex
+
+// DefaultDriver returns the driver selected by the queue.driver config, redis if it is not set.
+func DefaultDriver() Driver {
+	m.Lock()
+	defer m.Unlock()
+
+	if defaultDriver == nil {
+		name := DriverRedis
+		if config.GetConfig() != nil && config.GetConfig().Queue.Driver != "" {
+			name = config.GetConfig().Queue.Driver
+		}
+
+		switch name {
+		case DriverRedis:
+			defaultDriver = NewRedisDriver(rdb.GetRedisClient(), repository.NewRepository().Job)
+		case DriverStream:
+			defaultDriver = NewStreamDriver(rdb.GetRedisClient(), repository.NewRepository().Job)
+		case DriverPostgres:
+			defaultDriver = NewPostgresDriver(pgx.GetPgxPool())
+		case DriverMemory:
+			defaultDriver = NewMemoryDriver()
+		default:
+			logger.Log.Fatal("Unknown queue driver", zap.String("driver", name))
+		}
+	}
+
+	return defaultDriver
+}
+
+// SetDefaultDriver replaces the driver used by NewQueue and the package level helpers.
+func SetDefaultDriver(driver Driver) {
+	m.Lock()
+	defer m.Unlock()
+
+	defaultDriver = driver
+}
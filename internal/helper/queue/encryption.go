@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+)
+
+// payloadKeyring returns the keyring of the app.key and app.previousKeys config, an empty one if no config is loaded.
+func payloadKeyring() (*job.Keyring, error) {
+	if config.GetConfig() == nil {
+		return job.NewKeyring("")
+	}
+
+	app := config.GetConfig().App
+	return job.NewKeyring(app.Key, app.PreviousKeys...)
+}
+
+// encryptPayloads encrypts the payloads of the jobs whose handler opts in to it, see job.EncryptedPayload.
+func encryptPayloads(jobs []*job.Job) error {
+	keyring, err := payloadKeyring()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		if err := keyring.Encrypt(j); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decryptPayload returns the payload of a dequeued job in clear.
+func decryptPayload(j *job.Job) ([]byte, error) {
+	keyring, err := payloadKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	return keyring.Decrypt(j)
+}
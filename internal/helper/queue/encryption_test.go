@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type encryptionTestPayload struct {
+	Secret string `json:"secret"`
+}
+
+func (encryptionTestPayload) EncryptPayload() bool { return true }
+
+// encryptionTestReceived receives the secret of every EncryptionTest job handled
+var encryptionTestReceived = make(chan string, 10)
+
+func init() {
+	job.Register("EncryptionTest", func(ctx context.Context, payload encryptionTestPayload) error {
+		encryptionTestReceived <- payload.Secret
+		return nil
+	})
+}
+
+func Test_encryption(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+	j, err := job.NewJob("EncryptionTest", encryptionTestPayload{Secret: "4111 1111 1111 1111"}, 3, 0)
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(ctx, j))
+
+	jobs, err := q.Peek(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.NotContains(t, string(jobs[0].Payload), "4111", "the payload is stored encrypted")
+
+	require.NoError(t, RunWorker(ctx, WorkerOptions{StopWhenEmpty: true}, q))
+	assert.Equal(t, "4111 1111 1111 1111", <-encryptionTestReceived, "the handler receives the payload in clear")
+}
+
+func Test_encryptedUniqueJob(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+	newJob := func() *job.Job {
+		j, err := job.NewJob("EncryptionTest", encryptionTestPayload{Secret: "same secret"}, 3, 0)
+		require.NoError(t, err)
+		return j.Unique("", job.UniqueUntilCompleted, time.Minute)
+	}
+
+	first := newJob()
+	require.NoError(t, q.Enqueue(ctx, first))
+	duplicate := newJob()
+	require.NoError(t, q.Enqueue(ctx, duplicate))
+	assert.Equal(t, first.ID, duplicate.ID, "jobs with the same payload in clear are duplicates")
+
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+
+	require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 1}, q))
+	<-encryptionTestReceived
+
+	next := newJob()
+	require.NoError(t, q.Enqueue(ctx, next))
+	assert.NotEqual(t, first.ID, next.ID, "the lock is released once the job completed")
+}
+
+func Test_encryptedStoredJobs(t *testing.T) {
+	ctx := context.Background()
+	newJob := func(t *testing.T) *job.Job {
+		j, err := job.NewJob("EncryptionTest", encryptionTestPayload{Secret: "4111 1111 1111 1111"}, 3, 0)
+		require.NoError(t, err)
+		return j
+	}
+
+	t.Run("outbox jobs are written encrypted and relayed", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		q := NewQueueWithDriver("testing", driver)
+
+		outboxJobs, err := q.newOutboxJobs([]*job.Job{newJob(t)})
+		require.NoError(t, err)
+		require.Len(t, outboxJobs, 1)
+		assert.NotContains(t, string(outboxJobs[0].Job), "4111", "the payload is stored encrypted in the outbox")
+
+		_, err = relayOutbox(ctx, &memoryOutboxRepository{jobs: outboxJobs}, driver, 10)
+		require.NoError(t, err)
+		require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 1}, q))
+		assert.Equal(t, "4111 1111 1111 1111", <-encryptionTestReceived)
+	})
+
+	t.Run("batch callbacks are stored encrypted and dispatched", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		batchID, err := q.DispatchBatch(ctx, Batch{Jobs: []*job.Job{newExampleJob(t, "a")}, Then: newJob(t)})
+		require.NoError(t, err)
+
+		batch, err := q.GetBatch(ctx, batchID)
+		require.NoError(t, err)
+		assert.NotContains(t, string(batch.ThenJob), "4111", "the payload is stored encrypted with the batch")
+
+		require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 2}, q))
+		assert.Equal(t, "4111 1111 1111 1111", <-encryptionTestReceived)
+	})
+}
+
+func Test_undecryptablePayload(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+	// A job encrypted with a key that was since removed from the config
+	keyring, err := job.NewKeyring("rotated-away-key")
+	require.NoError(t, err)
+	j, err := job.NewJob("EncryptionTest", encryptionTestPayload{Secret: "lost"}, 3, 0)
+	require.NoError(t, err)
+	require.NoError(t, keyring.Encrypt(j))
+	require.NoError(t, q.Enqueue(ctx, j))
+
+	require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 1}, q))
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Failed: 1}, stats, "the job fails without further attempts")
+
+	failed, err := q.PeekFailed(ctx, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.NotEmpty(t, failed[0].Errors)
+	assert.Contains(t, failed[0].Errors[len(failed[0].Errors)-1], job.ErrUnknownEncryptionKey.Error())
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
+)
+
+// historyProvider is implemented by the drivers that choose where the attempts and results of jobs are recorded,
+// a nil repository records nothing.
+type historyProvider interface {
+	History() repository.JobRepository
+}
+
+// history returns the repository the attempts and results of jobs are recorded in, postgres if the driver has none.
+func (q *Queue) history() repository.JobRepository {
+	if provider, ok := q.driver.(historyProvider); ok {
+		return provider.History()
+	}
+	return repository.NewJobRepository(pgx.GetPgxPool())
+}
+
+// recordAttempt records an attempt of a job, and its result if the job succeeded.
+// The history is informative only, so errors are logged and do not fail the job.
+func (q *Queue) recordAttempt(ctx context.Context, j *job.Job, startedAt time.Time, handlerError error, result json.RawMessage) {
+	history := q.history()
+	if history == nil {
+		return
+	}
+
+	finishedAt := time.Now()
+	attempt := model.JobAttempt{
+		JobID:      j.ID,
+		Queue:      q.KeyWithoutPrefix,
+		Attempt:    j.Attempts,
+		Worker:     ConsumerFromContext(ctx),
+		Status:     job.AttemptCompleted,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		DurationMs: finishedAt.Sub(startedAt).Milliseconds(),
+	}
+
+	var release *job.ReleaseError
+	var panicked *job.PanicError
+	switch {
+	case errors.As(handlerError, &release):
+		attempt.Status = job.AttemptReleased
+	case errors.Is(handlerError, job.ErrCancelled):
+		attempt.Status = job.AttemptCancelled
+	case handlerError != nil:
+		attempt.Status = job.AttemptFailed
+		attempt.Error = handlerError.Error()
+		if errors.As(handlerError, &panicked) {
+			attempt.StackTrace = string(panicked.Stack)
+		}
+	}
+
+	if _, err := history.AddJobAttempt(ctx, attempt); err != nil {
+		logger.Log.Error("Error recording job attempt", zap.String("job_id", j.ID.String()), zap.Error(err))
+	}
+
+	if handlerError != nil || len(result) == 0 {
+		return
+	}
+
+	if err := history.SetJobResult(ctx, j.ID, result); err != nil {
+		logger.Log.Error("Error recording job result", zap.String("job_id", j.ID.String()), zap.Error(err))
+	}
+}
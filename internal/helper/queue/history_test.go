@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingJobRepository keeps the attempts and results recorded by a queue.
+type recordingJobRepository struct {
+	repository.JobRepository
+	attempts []model.JobAttempt
+	results  map[uuid.UUID][]byte
+}
+
+func (r *recordingJobRepository) AddJobAttempt(ctx context.Context, attempt model.JobAttempt) (int, error) {
+	r.attempts = append(r.attempts, attempt)
+	return len(r.attempts), nil
+}
+
+func (r *recordingJobRepository) SetJobResult(ctx context.Context, jobID uuid.UUID, result []byte) error {
+	r.results[jobID] = result
+	return nil
+}
+
+func (r *recordingJobRepository) CountFinishedAttempts(ctx context.Context, queue string, since time.Time) (int64, error) {
+	var count int64
+	for _, attempt := range r.attempts {
+		if attempt.Queue == queue && !attempt.FinishedAt.Before(since) && attempt.Status != job.AttemptReleased {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// historyDriver is a memory driver recording the history of its jobs.
+type historyDriver struct {
+	Driver
+	history *recordingJobRepository
+}
+
+func (d *historyDriver) History() repository.JobRepository {
+	return d.history
+}
+
+func Test_recordAttempt(t *testing.T) {
+	history := &recordingJobRepository{results: make(map[uuid.UUID][]byte)}
+	q := NewQueueWithDriver("testing", &historyDriver{Driver: NewMemoryDriver(), history: history})
+	ctx := WithConsumer(context.Background(), "worker-1")
+
+	j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+	j.Attempts = 2
+	startedAt := time.Now().Add(-time.Second)
+
+	q.recordAttempt(ctx, j, startedAt, nil, []byte(`{"synced":true}`))
+	q.recordAttempt(ctx, j, startedAt, job.Release(time.Minute), nil)
+	q.recordAttempt(ctx, j, startedAt, errors.New("boom"), []byte(`{"ignored":true}`))
+
+	panicErr := runHandler(ctx, q, j, contextHandlerFunc(func(ctx context.Context) error {
+		panic("kaboom")
+	}))
+	q.recordAttempt(ctx, j, startedAt, panicErr, nil)
+
+	require.Len(t, history.attempts, 4)
+
+	completed := history.attempts[0]
+	assert.Equal(t, job.AttemptCompleted, completed.Status)
+	assert.Equal(t, "worker-1", completed.Worker)
+	assert.Equal(t, 2, completed.Attempt)
+	assert.GreaterOrEqual(t, completed.DurationMs, int64(1000))
+
+	assert.Equal(t, job.AttemptReleased, history.attempts[1].Status)
+	assert.Empty(t, history.attempts[1].Error)
+
+	assert.Equal(t, job.AttemptFailed, history.attempts[2].Status)
+	assert.Equal(t, "boom", history.attempts[2].Error)
+
+	assert.Contains(t, history.attempts[3].Error, "kaboom")
+	assert.Contains(t, history.attempts[3].StackTrace, "runHandler", "a panic records where it happened")
+
+	assert.JSONEq(t, `{"synced":true}`, string(history.results[j.ID]), "only the result of a successful attempt is kept")
+}
@@ -0,0 +1,460 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+)
+
+// memoryQueue holds the jobs of one queue of the memory driver.
+type memoryQueue struct {
+	ready    []*job.Job
+	delayed  []memoryEntry
+	reserved map[uuid.UUID]memoryEntry
+	failed   []*job.Job
+}
+
+// memoryEntry is a job with the time it becomes ready (delayed) or its lease deadline (reserved).
+type memoryEntry struct {
+	job *job.Job
+	at  time.Time
+}
+
+// memoryDriver keeps queues in process memory. Jobs are not shared between processes
+// and are lost on exit, so it is meant for unit tests and local experiments.
+type memoryDriver struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+
+	// pushed is closed and replaced whenever a job becomes ready, to wake up blocked Reserve calls
+	pushed chan struct{}
+
+	locks     UniqueLocks
+	batches   repository.BatchRepository
+	paused    PausedQueues
+	workers   WorkerRegistry
+	cancelled CancelledJobs
+	progress  ProgressStore
+}
+
+func NewMemoryDriver() Driver {
+	return &memoryDriver{
+		queues:    make(map[string]*memoryQueue),
+		pushed:    make(chan struct{}),
+		locks:     NewMemoryLocks(),
+		batches:   NewMemoryBatches(),
+		paused:    NewMemoryPausedQueues(),
+		workers:   NewMemoryWorkerRegistry(),
+		cancelled: NewMemoryCancelledJobs(),
+		progress:  NewMemoryProgressStore(),
+	}
+}
+
+func (d *memoryDriver) Locks() UniqueLocks {
+	return d.locks
+}
+
+func (d *memoryDriver) Batches() repository.BatchRepository {
+	return d.batches
+}
+
+func (d *memoryDriver) PausedQueues() PausedQueues {
+	return d.paused
+}
+
+func (d *memoryDriver) Workers() WorkerRegistry {
+	return d.workers
+}
+
+func (d *memoryDriver) CancelledJobs() CancelledJobs {
+	return d.cancelled
+}
+
+func (d *memoryDriver) Progress() ProgressStore {
+	return d.progress
+}
+
+// History records nothing, attempts and results of in-memory jobs are not kept.
+func (d *memoryDriver) History() repository.JobRepository {
+	return nil
+}
+
+// queue returns the jobs of a queue, it must be called with the lock held.
+func (d *memoryDriver) queue(name string) *memoryQueue {
+	mq, ok := d.queues[name]
+	if !ok {
+		mq = &memoryQueue{reserved: make(map[uuid.UUID]memoryEntry)}
+		d.queues[name] = mq
+	}
+	return mq
+}
+
+// copyJob keeps the stored jobs apart from the ones handed to callers.
+func copyJob(j *job.Job) *job.Job {
+	c := *j
+	c.Errors = append([]string(nil), j.Errors...)
+	return &c
+}
+
+// pushReady adds a job to the ready jobs, it must be called with the lock held.
+// Jobs with a priority are added to the start of the queue, like the redis driver does.
+func (d *memoryDriver) pushReady(mq *memoryQueue, j *job.Job) {
+	if j.Priority > 0 {
+		mq.ready = append([]*job.Job{j}, mq.ready...)
+	} else {
+		mq.ready = append(mq.ready, j)
+	}
+
+	close(d.pushed)
+	d.pushed = make(chan struct{})
+}
+
+// pushDelayed adds a job to the delayed jobs, it must be called with the lock held.
+func (d *memoryDriver) pushDelayed(mq *memoryQueue, j *job.Job, at time.Time) {
+	mq.delayed = append(mq.delayed, memoryEntry{job: j, at: at})
+	sort.SliceStable(mq.delayed, func(a, b int) bool { return mq.delayed[a].at.Before(mq.delayed[b].at) })
+}
+
+func (d *memoryDriver) Push(ctx context.Context, queue string, jobs ...*job.Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	for _, j := range jobs {
+		if j.Delay > 0 {
+			d.pushDelayed(mq, copyJob(j), time.Now().Add(time.Duration(j.Delay)*time.Second))
+		} else {
+			d.pushReady(mq, copyJob(j))
+		}
+	}
+
+	return nil
+}
+
+func (d *memoryDriver) Restore(ctx context.Context, queue string, failed bool, jobs ...*job.Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	for _, j := range jobs {
+		if failed {
+			mq.failed = append(mq.failed, copyJob(j))
+		} else {
+			d.pushReady(mq, copyJob(j))
+		}
+	}
+
+	return nil
+}
+
+func (d *memoryDriver) Reserve(ctx context.Context, queue string, lease time.Duration, wait time.Duration) (*job.Job, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		d.mu.Lock()
+		mq := d.queue(queue)
+		if len(mq.ready) > 0 {
+			j := mq.ready[0]
+			mq.ready = mq.ready[1:]
+
+			j.Attempts++
+			j.LeaseExpiresAt = time.Now().Add(lease)
+			mq.reserved[j.ID] = memoryEntry{job: j, at: j.LeaseExpiresAt}
+			d.mu.Unlock()
+
+			return copyJob(j), nil
+		}
+		pushed := d.pushed
+		d.mu.Unlock()
+
+		if wait <= 0 {
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-pushed:
+		}
+	}
+}
+
+func (d *memoryDriver) Extend(ctx context.Context, queue string, jobID uuid.UUID, lease time.Duration) (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	entry, ok := mq.reserved[jobID]
+	if !ok || entry.at.IsZero() {
+		return time.Time{}, ErrLeaseLost
+	}
+
+	entry.at = time.Now().Add(lease)
+	entry.job.LeaseExpiresAt = entry.at
+	mq.reserved[jobID] = entry
+
+	return entry.at, nil
+}
+
+func (d *memoryDriver) Reserved(ctx context.Context, queue string, jobID uuid.UUID) (*job.Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.queue(queue).reserved[jobID]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyJob(entry.job), nil
+}
+
+func (d *memoryDriver) Expired(ctx context.Context, queue string, lease time.Duration) ([]uuid.UUID, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var jobIDs []uuid.UUID
+	now := time.Now()
+	mq := d.queue(queue)
+	for id, entry := range mq.reserved {
+		if !entry.at.IsZero() && entry.at.Before(now) {
+			// A zero deadline claims the job, so it is reaped only once
+			entry.at = time.Time{}
+			mq.reserved[id] = entry
+			jobIDs = append(jobIDs, id)
+		}
+	}
+
+	return jobIDs, nil
+}
+
+// unreserve removes a reserved job, it reports false if the job was not reserved anymore.
+// It must be called with the lock held.
+func (d *memoryDriver) unreserve(mq *memoryQueue, jobID uuid.UUID) bool {
+	if _, ok := mq.reserved[jobID]; !ok {
+		return false
+	}
+
+	delete(mq.reserved, jobID)
+	return true
+}
+
+func (d *memoryDriver) Complete(ctx context.Context, queue string, j *job.Job) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.unreserve(d.queue(queue), j.ID), nil
+}
+
+func (d *memoryDriver) Release(ctx context.Context, queue string, j *job.Job, at time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	if !d.unreserve(mq, j.ID) {
+		return false, nil
+	}
+
+	if at.After(time.Now()) {
+		d.pushDelayed(mq, copyJob(j), at)
+	} else {
+		d.pushReady(mq, copyJob(j))
+	}
+
+	return true, nil
+}
+
+func (d *memoryDriver) Bury(ctx context.Context, queue string, j *job.Job, reason string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	if !d.unreserve(mq, j.ID) {
+		return false, nil
+	}
+
+	mq.failed = append(mq.failed, copyJob(j))
+	return true, nil
+}
+
+func (d *memoryDriver) Promote(ctx context.Context, queue string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count := 0
+	now := time.Now()
+	mq := d.queue(queue)
+	for len(mq.delayed) > 0 && !mq.delayed[0].at.After(now) {
+		d.pushReady(mq, mq.delayed[0].job)
+		mq.delayed = mq.delayed[1:]
+		count++
+	}
+
+	return count, nil
+}
+
+func (d *memoryDriver) RetryFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	for i, j := range mq.failed {
+		if j.ID == jobID {
+			mq.failed = append(mq.failed[:i], mq.failed[i+1:]...)
+			j.Attempts = 0
+			d.pushReady(mq, j)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (d *memoryDriver) RetryAllFailed(ctx context.Context, queue string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	count := len(mq.failed)
+	for _, j := range mq.failed {
+		j.Attempts = 0
+		d.pushReady(mq, j)
+	}
+	mq.failed = nil
+
+	return count, nil
+}
+
+func (d *memoryDriver) RemoveFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	var removed bool
+	mq.failed, removed = removeJob(mq.failed, jobID)
+
+	return removed, nil
+}
+
+func (d *memoryDriver) FlushFailed(ctx context.Context, queue string) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	count := int64(len(mq.failed))
+	mq.failed = nil
+
+	return count, nil
+}
+
+func (d *memoryDriver) Remove(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	var removed bool
+	mq.ready, removed = removeJob(mq.ready, jobID)
+
+	return removed, nil
+}
+
+// removeJob removes the job with the matching job ID from a slice of jobs.
+func removeJob(jobs []*job.Job, jobID uuid.UUID) ([]*job.Job, bool) {
+	for i, j := range jobs {
+		if j.ID == jobID {
+			return append(jobs[:i], jobs[i+1:]...), true
+		}
+	}
+	return jobs, false
+}
+
+func (d *memoryDriver) Clear(ctx context.Context, queue string) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	count := int64(len(mq.ready) + len(mq.delayed))
+	mq.ready = nil
+	mq.delayed = nil
+
+	return count, nil
+}
+
+func (d *memoryDriver) Peek(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var jobs []*job.Job
+	for _, j := range d.queue(queue).ready {
+		if int64(len(jobs)) >= count {
+			break
+		}
+		jobs = append(jobs, copyJob(j))
+	}
+
+	return jobs, nil
+}
+
+func (d *memoryDriver) PeekDelayed(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var jobs []*job.Job
+	for _, entry := range d.queue(queue).delayed {
+		if int64(len(jobs)) >= count {
+			break
+		}
+		jobs = append(jobs, copyJob(entry.job))
+	}
+
+	return jobs, nil
+}
+
+func (d *memoryDriver) PeekFailed(ctx context.Context, queue string, offset int64, count int64) ([]*job.Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Failed jobs are appended, so the most recent ones come last
+	failed := d.queue(queue).failed
+	var jobs []*job.Job
+	for i := int64(len(failed)) - 1 - offset; i >= 0 && int64(len(jobs)) < count; i-- {
+		jobs = append(jobs, copyJob(failed[i]))
+	}
+
+	return jobs, nil
+}
+
+func (d *memoryDriver) Stats(ctx context.Context, queue string) (Stats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mq := d.queue(queue)
+	return Stats{
+		Ready:    int64(len(mq.ready)),
+		Reserved: int64(len(mq.reserved)),
+		Delayed:  int64(len(mq.delayed)),
+		Failed:   int64(len(mq.failed)),
+	}, nil
+}
+
+func (d *memoryDriver) Queues(ctx context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var queues []string
+	for name, mq := range d.queues {
+		if len(mq.ready)+len(mq.delayed)+len(mq.reserved)+len(mq.failed) > 0 {
+			queues = append(queues, name)
+		}
+	}
+	sort.Strings(queues)
+
+	return queues, nil
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_memoryDriver(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("jobs are dequeued in order and acknowledged", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j1, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j2, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j1, j2))
+
+		peeked, err := q.Peek(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, peeked, 1)
+		assert.Equal(t, j1.ID, peeked[0].ID)
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, j1.ID, dequeued.ID)
+		assert.Equal(t, 1, dequeued.Attempts)
+
+		require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, nil))
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{Ready: 1}, stats)
+	})
+
+	t.Run("a job with an unknown handler is not enqueued", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		known, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		unknown, _ := job.NewJob("DoesNotExist", nil, 3, 0)
+
+		assert.ErrorIs(t, q.Enqueue(ctx, known, unknown), job.ErrUnknownHandler)
+
+		isEmpty, err := q.IsEmpty(ctx)
+		require.NoError(t, err)
+		assert.True(t, isEmpty)
+	})
+
+	t.Run("a job with a priority jumps the queue", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j1, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j2, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j2.Priority = 1
+		require.NoError(t, q.Enqueue(ctx, j1, j2))
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, j2.ID, dequeued.ID)
+	})
+
+	t.Run("a failed job is retried then buried", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("ProcessExample", nil, 2, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			dequeued, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			require.NotNil(t, dequeued, "attempt %d should dequeue the job", attempt)
+			require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, errors.New("boom")))
+		}
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{Failed: 1}, stats)
+
+		require.NoError(t, q.RetryFailedByJobID(ctx, j.ID))
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dequeued.Attempts, "a retried job starts over")
+		assert.Len(t, dequeued.Errors, 2)
+	})
+
+	t.Run("a released job does not use up an attempt", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("ProcessExample", nil, 1, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		for i := 0; i < 3; i++ {
+			dequeued, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			require.NotNil(t, dequeued)
+			assert.Equal(t, 1, dequeued.Attempts)
+			require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, job.Release(0)))
+		}
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{Ready: 1}, stats)
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, dequeued.Errors)
+	})
+
+	t.Run("failed jobs are listed the most recently failed first", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j1, _ := job.NewJob("ProcessExample", nil, 1, 0)
+		j2, _ := job.NewJob("ProcessExample", nil, 1, 0)
+		j3, _ := job.NewJob("ProcessExample", nil, 1, 0)
+		require.NoError(t, q.Enqueue(ctx, j1, j2, j3))
+
+		for i := 0; i < 3; i++ {
+			dequeued, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, errors.New("boom")))
+		}
+
+		failed, err := q.PeekFailed(ctx, 0, 2)
+		require.NoError(t, err)
+		require.Len(t, failed, 2)
+		assert.Equal(t, j3.ID, failed[0].ID)
+		assert.Equal(t, j2.ID, failed[1].ID)
+
+		failed, err = q.PeekFailed(ctx, 2, 2)
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		assert.Equal(t, j1.ID, failed[0].ID)
+
+		require.NoError(t, q.RemoveFailedByID(ctx, j2.ID))
+		assert.ErrorIs(t, q.RemoveFailedByID(ctx, j2.ID), ErrFailedJobNotFound)
+		assert.ErrorIs(t, q.RetryFailedByJobID(ctx, j2.ID), ErrFailedJobNotFound)
+	})
+
+	t.Run("workers skip a paused queue", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		paused := NewQueueWithDriver("paused", driver)
+		active := NewQueueWithDriver("active", driver)
+		j1, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j2, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		require.NoError(t, paused.Enqueue(ctx, j1))
+		require.NoError(t, active.Enqueue(ctx, j2))
+
+		require.NoError(t, paused.Pause(ctx))
+		isPaused, err := paused.IsPaused(ctx)
+		require.NoError(t, err)
+		assert.True(t, isPaused)
+
+		pausedNames, err := driverPausedQueues(driver).List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"paused"}, pausedNames)
+
+		q, dequeued, err := dequeueNext(ctx, []*Queue{paused, active})
+		require.NoError(t, err)
+		assert.Equal(t, active, q)
+		assert.Equal(t, j2.ID, dequeued.ID)
+
+		_, dequeued, err = dequeueNext(ctx, []*Queue{paused})
+		require.NoError(t, err)
+		assert.Nil(t, dequeued, "a paused queue should not give out jobs")
+
+		require.NoError(t, paused.Resume(ctx))
+		_, dequeued, err = dequeueNext(ctx, []*Queue{paused})
+		require.NoError(t, err)
+		assert.Equal(t, j1.ID, dequeued.ID)
+	})
+
+	t.Run("delayed jobs wait until they are promoted", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, job.RetryAfter(50*time.Millisecond, errors.New("later"))))
+
+		delayed, err := q.DelayedLength(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), delayed)
+
+		promoted, err := q.PromoteDelayed(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, promoted, "the job is not due yet")
+
+		time.Sleep(60 * time.Millisecond)
+		promoted, err = q.PromoteDelayed(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, promoted)
+	})
+
+	t.Run("a job with an expired lease is reaped once", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		q.VisibilityTimeout = 10 * time.Millisecond
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		_, err = q.ExtendLease(ctx, dequeued.ID)
+		require.NoError(t, err)
+
+		time.Sleep(20 * time.Millisecond)
+		reaped, err := q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reaped)
+
+		reaped, err = q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, reaped)
+
+		_, err = q.ExtendLease(ctx, dequeued.ID)
+		assert.ErrorIs(t, err, ErrLeaseLost)
+
+		// The late acknowledgement of the reaped job is a no-op
+		require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, nil))
+
+		redelivered, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, j.ID, redelivered.ID)
+		assert.Equal(t, 2, redelivered.Attempts)
+	})
+
+	t.Run("a blocking dequeue wakes up on push", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_ = q.Enqueue(ctx, j)
+		}()
+
+		dequeued, err := q.Dequeue(ctx, time.Second)
+		require.NoError(t, err)
+		require.NotNil(t, dequeued)
+		assert.Equal(t, j.ID, dequeued.ID)
+	})
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ThroughputWindow is the period the throughput of a queue is measured over.
+const ThroughputWindow = time.Minute
+
+// QueueMetrics holds the figures of a queue watched by queue:monitor.
+type QueueMetrics struct {
+	QueueInfo
+	Throughput   int64   `json:"throughput"`     // jobs that completed or failed during the last ThroughputWindow
+	OldestJobAge float64 `json:"oldest_job_age"` // in seconds, since the next ready job was created, 0 if there is none
+}
+
+// CollectMetrics returns the metrics of the queues listed by ListQueueKeysAndLengths.
+func CollectMetrics(ctx context.Context) ([]QueueMetrics, error) {
+	queueInfos, err := ListQueueKeysAndLengths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]QueueMetrics, 0, len(queueInfos))
+	for _, queueInfo := range queueInfos {
+		q := NewQueue(queueInfo.KeyWithoutPrefix)
+
+		throughput, err := q.Throughput(ctx, ThroughputWindow)
+		if err != nil {
+			return nil, fmt.Errorf("error getting throughput of queue %s: %w", queueInfo.KeyWithoutPrefix, err)
+		}
+
+		oldestJobAge, err := q.OldestJobAge(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error getting oldest job of queue %s: %w", queueInfo.KeyWithoutPrefix, err)
+		}
+
+		metrics = append(metrics, QueueMetrics{
+			QueueInfo:    queueInfo,
+			Throughput:   throughput,
+			OldestJobAge: oldestJobAge.Seconds(),
+		})
+	}
+
+	return metrics, nil
+}
+
+// Throughput counts the jobs of the queue that completed or failed during the last window.
+// It is read from the attempt history, so it is 0 on a driver that records none.
+func (q *Queue) Throughput(ctx context.Context, window time.Duration) (int64, error) {
+	history := q.history()
+	if history == nil {
+		return 0, nil
+	}
+
+	return history.CountFinishedAttempts(ctx, q.KeyWithoutPrefix, time.Now().Add(-window))
+}
+
+// OldestJobAge returns how long ago the next ready job was created, 0 if the queue has no ready job.
+func (q *Queue) OldestJobAge(ctx context.Context) (time.Duration, error) {
+	jobs, err := q.Peek(ctx, 1)
+	if err != nil || len(jobs) == 0 {
+		return 0, err
+	}
+
+	return time.Since(jobs[0].CreatedAt), nil
+}
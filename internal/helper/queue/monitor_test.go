@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_monitor(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("the oldest job age is the age of the next ready job", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+		age, err := q.OldestJobAge(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, age, "an empty queue has no oldest job")
+
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j.CreatedAt = time.Now().Add(-time.Minute)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		age, err = q.OldestJobAge(ctx)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, age, time.Minute)
+	})
+
+	t.Run("the throughput counts the jobs that finished during the window", func(t *testing.T) {
+		history := &recordingJobRepository{results: make(map[uuid.UUID][]byte)}
+		q := NewQueueWithDriver("testing", &historyDriver{Driver: NewMemoryDriver(), history: history})
+
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		q.recordAttempt(ctx, j, time.Now(), nil, nil)
+		q.recordAttempt(ctx, j, time.Now(), errors.New("boom"), nil)
+		q.recordAttempt(ctx, j, time.Now(), job.Release(time.Minute), nil)
+
+		throughput, err := q.Throughput(ctx, ThroughputWindow)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), throughput, "released attempts are not counted")
+
+		throughput, err = NewQueueWithDriver("testing", NewMemoryDriver()).Throughput(ctx, ThroughputWindow)
+		require.NoError(t, err)
+		assert.Zero(t, throughput, "a driver without history has no throughput")
+	})
+}
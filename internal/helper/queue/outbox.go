@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/jackc/pgx/v5"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
+)
+
+// DefaultRelayBatchSize is the number of outbox jobs relayed per transaction when none is given.
+const DefaultRelayBatchSize = 100
+
+/*
+EnqueueTx adds jobs to the outbox within tx instead of pushing them to the queue, so they are queued
+if and only if tx commits along with the data it writes. The relay, see RunRelay and queue:relay,
+pushes them to the queue afterwards with the ID they got here:
+
+	tx, err := pgxPool.Begin(ctx)
+	...
+	err = tx.QueryRow(ctx, "INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id", name, email).Scan(&id)
+	...
+	j, _ := job.NewJob("SendWelcomeEmail", SendWelcomeEmail{UserID: id}, 3, 0)
+	err = queue.NewQueue("emails").EnqueueTx(ctx, tx, j)
+	...
+	err = tx.Commit(ctx)
+
+A job is relayed exactly once only if the driver of the queue keeps a job history, as the redis, stream and
+postgres drivers do. Otherwise a relay that stops between pushing a job and removing it from the outbox pushes it again.
+
+No job is added if one of them has a handler that is not registered, see job.Register.
+The payloads of the handlers that opt in to it are encrypted in place before they are written, see job.EncryptedPayload.
+*/
+func (q *Queue) EnqueueTx(ctx context.Context, tx pgx.Tx, jobs ...*job.Job) error {
+	outboxJobs, err := q.newOutboxJobs(jobs)
+	if err != nil {
+		return err
+	}
+
+	// The jobs are written by the transaction of the caller, the repository needs no pool of its own
+	return repository.NewOutboxRepository(nil).AddOutboxJobs(ctx, tx, outboxJobs...)
+}
+
+// newOutboxJobs returns the outbox rows of the jobs, with their payloads encrypted as Enqueue would.
+func (q *Queue) newOutboxJobs(jobs []*job.Job) ([]model.OutboxJob, error) {
+	for _, j := range jobs {
+		if err := job.CheckRegistered(j); err != nil {
+			return nil, err
+		}
+	}
+	if err := encryptPayloads(jobs); err != nil {
+		return nil, err
+	}
+
+	outboxJobs := make([]model.OutboxJob, 0, len(jobs))
+	for _, j := range jobs {
+		jobBytes, err := sonic.Marshal(j)
+		if err != nil {
+			return nil, err
+		}
+
+		outboxJobs = append(outboxJobs, model.OutboxJob{
+			Queue:     q.KeyWithoutPrefix,
+			Job:       jobBytes,
+			CreatedAt: time.Now(),
+		})
+	}
+
+	return outboxJobs, nil
+}
+
+// RelayOutbox pushes up to batchSize jobs of the outbox to their queue on the default driver, and returns how many it took.
+func RelayOutbox(ctx context.Context, repo repository.OutboxRepository, batchSize int) (int, error) {
+	return relayOutbox(ctx, repo, DefaultDriver(), batchSize)
+}
+
+func relayOutbox(ctx context.Context, repo repository.OutboxRepository, driver Driver, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultRelayBatchSize
+	}
+
+	return repo.RelayOutboxJobs(ctx, batchSize, func(ctx context.Context, outboxJobs []model.OutboxJob) []error {
+		errs := make([]error, len(outboxJobs))
+		for i, outboxJob := range outboxJobs {
+			errs[i] = publishOutboxJob(ctx, driver, outboxJob)
+			if errs[i] != nil {
+				logger.Log.Error("Error relaying outbox job", zap.Int64("outbox_id", outboxJob.ID), zap.String("queue", outboxJob.Queue), zap.Error(errs[i]))
+			}
+		}
+		return errs
+	})
+}
+
+// RunRelay relays the jobs of the outbox until the context is canceled. The outbox is drained
+// batch after batch, then polled every interval.
+func RunRelay(ctx context.Context, repo repository.OutboxRepository, interval time.Duration, batchSize int) error {
+	if interval <= 0 {
+		return fmt.Errorf("relay interval must be positive, got %s", interval)
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultRelayBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		relayed, err := RelayOutbox(ctx, repo, batchSize)
+		if err != nil && ctx.Err() == nil {
+			logger.Log.Error("Error relaying the outbox", zap.Error(err))
+		}
+		if relayed > 0 {
+			logger.Log.Debug(fmt.Sprintf("relayed %d outbox jobs", relayed))
+		}
+
+		// Keep draining while the batches are full
+		if err == nil && relayed >= batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Context canceled, stopping the outbox relay")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishOutboxJob pushes a job of the outbox to its queue. A job already recorded in the job history was
+// pushed by a relay that stopped before it could remove the job from the outbox, so it is not pushed twice.
+// A driver without job history, e.g. DriverMemory, cannot tell, so such a job is pushed again.
+func publishOutboxJob(ctx context.Context, driver Driver, outboxJob model.OutboxJob) error {
+	var j job.Job
+	if err := sonic.Unmarshal(outboxJob.Job, &j); err != nil {
+		return fmt.Errorf("error unmarshaling outbox job: %w", err)
+	}
+
+	q := NewQueueWithDriver(outboxJob.Queue, driver)
+	if history := q.history(); history != nil {
+		_, err := history.GetJobByID(ctx, j.ID)
+		if err == nil {
+			logger.Log.Info("Outbox job was already relayed", zap.String("job_id", j.ID.String()), zap.String("queue", q.KeyWithoutPrefix))
+			return nil
+		}
+		if !errors.Is(err, repository.ErrJobNotFound) {
+			return err
+		}
+	}
+
+	return q.Enqueue(ctx, &j)
+}
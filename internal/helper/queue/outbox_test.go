@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutboxRepository keeps the outbox in memory, failed jobs stay in it with their error.
+type memoryOutboxRepository struct {
+	repository.OutboxRepository
+	jobs []model.OutboxJob
+}
+
+func (r *memoryOutboxRepository) RelayOutboxJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []model.OutboxJob) []error) (int, error) {
+	taken := r.jobs
+	if len(taken) > limit {
+		taken = taken[:limit]
+	}
+	rest := r.jobs[len(taken):]
+
+	errs := publish(ctx, taken)
+	var kept []model.OutboxJob
+	for i, outboxJob := range taken {
+		if errs[i] != nil {
+			outboxJob.Attempts++
+			outboxJob.LastError = errs[i].Error()
+			kept = append(kept, outboxJob)
+		}
+	}
+	r.jobs = append(kept, rest...)
+
+	return len(taken), nil
+}
+
+// knownJobRepository knows the jobs already pushed, as the job history does.
+type knownJobRepository struct {
+	repository.JobRepository
+	known map[uuid.UUID]bool
+}
+
+func (r *knownJobRepository) GetJobByID(ctx context.Context, jobID uuid.UUID) (model.Job, error) {
+	if r.known[jobID] {
+		return model.Job{ID: jobID}, nil
+	}
+	return model.Job{}, repository.ErrJobNotFound
+}
+
+type knownJobDriver struct {
+	Driver
+	history *knownJobRepository
+}
+
+func (d *knownJobDriver) History() repository.JobRepository {
+	return d.history
+}
+
+func Test_relayOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	outboxJob := func(t *testing.T, queue string, handlerName string) (model.OutboxJob, *job.Job) {
+		j, err := job.NewJob(handlerName, nil, 3, 0)
+		require.NoError(t, err)
+		jobBytes, err := sonic.Marshal(j)
+		require.NoError(t, err)
+		return model.OutboxJob{Queue: queue, Job: jobBytes}, j
+	}
+
+	t.Run("jobs are pushed to their queue and removed from the outbox", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		emails, emailJob := outboxJob(t, "emails", "ProcessExample")
+		reports, _ := outboxJob(t, "reports", "ProcessExample")
+		repo := &memoryOutboxRepository{jobs: []model.OutboxJob{emails, reports}}
+
+		relayed, err := relayOutbox(ctx, repo, driver, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 2, relayed)
+		assert.Empty(t, repo.jobs)
+
+		jobs, err := NewQueueWithDriver("emails", driver).Peek(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, emailJob.ID, jobs[0].ID, "the job keeps the ID it got in the outbox")
+
+		length, err := NewQueueWithDriver("reports", driver).Length(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), length)
+	})
+
+	t.Run("a job already pushed is not pushed twice", func(t *testing.T) {
+		outboxed, j := outboxJob(t, "emails", "ProcessExample")
+		driver := &knownJobDriver{Driver: NewMemoryDriver(), history: &knownJobRepository{known: map[uuid.UUID]bool{j.ID: true}}}
+		repo := &memoryOutboxRepository{jobs: []model.OutboxJob{outboxed}}
+
+		_, err := relayOutbox(ctx, repo, driver, 10)
+		require.NoError(t, err)
+		assert.Empty(t, repo.jobs)
+
+		length, err := NewQueueWithDriver("emails", driver).Length(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, length)
+	})
+
+	t.Run("a job that cannot be pushed stays in the outbox", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		unknown, _ := outboxJob(t, "emails", "ProcessExample")
+		unknown.Job, _ = sonic.Marshal(map[string]any{"id": uuid.New(), "handlerName": "NotRegistered"})
+		known, _ := outboxJob(t, "emails", "ProcessExample")
+		repo := &memoryOutboxRepository{jobs: []model.OutboxJob{unknown, known}}
+
+		_, err := relayOutbox(ctx, repo, driver, 10)
+		require.NoError(t, err)
+		require.Len(t, repo.jobs, 1)
+		assert.Equal(t, 1, repo.jobs[0].Attempts)
+		assert.Contains(t, repo.jobs[0].LastError, "NotRegistered")
+
+		length, err := NewQueueWithDriver("emails", driver).Length(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), length, "the other jobs of the batch are pushed")
+	})
+
+	t.Run("the relay needs a positive interval", func(t *testing.T) {
+		err := RunRelay(ctx, &memoryOutboxRepository{}, 0, 10)
+		assert.ErrorContains(t, err, "relay interval must be positive")
+	})
+
+	t.Run("jobs of unregistered handlers are not added to the outbox", func(t *testing.T) {
+		j, _ := job.NewJob("NotRegistered", nil, 3, 0)
+		err := NewQueueWithDriver("emails", NewMemoryDriver()).EnqueueTx(ctx, nil, j)
+		assert.ErrorIs(t, err, job.ErrUnknownHandler)
+	})
+}
@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/redis/go-redis/v9"
+)
+
+// PausedQueues holds which queues are paused. Workers do not take jobs from a paused queue,
+// jobs can still be enqueued and the jobs being processed finish normally.
+type PausedQueues interface {
+	Pause(ctx context.Context, queue string) error
+	Resume(ctx context.Context, queue string) error
+	IsPaused(ctx context.Context, queue string) (bool, error)
+	// List returns the names of the paused queues.
+	List(ctx context.Context) ([]string, error)
+}
+
+// pauseProvider is implemented by the drivers that keep track of paused queues themselves.
+type pauseProvider interface {
+	PausedQueues() PausedQueues
+}
+
+// pausedQueues returns the paused queues of the queue driver.
+func (q *Queue) pausedQueues() PausedQueues {
+	return driverPausedQueues(q.driver)
+}
+
+// driverPausedQueues returns the paused queues of a driver, redis ones if the driver has none.
+func driverPausedQueues(driver Driver) PausedQueues {
+	if provider, ok := driver.(pauseProvider); ok {
+		return provider.PausedQueues()
+	}
+	return NewRedisPausedQueues(rdb.GetRedisClient())
+}
+
+// Pause stops the workers from taking jobs from the queue until it is resumed.
+func (q *Queue) Pause(ctx context.Context) error {
+	return q.pausedQueues().Pause(ctx, q.KeyWithoutPrefix)
+}
+
+// Resume lets the workers take jobs from a paused queue again.
+func (q *Queue) Resume(ctx context.Context) error {
+	return q.pausedQueues().Resume(ctx, q.KeyWithoutPrefix)
+}
+
+// IsPaused reports whether the queue is paused.
+func (q *Queue) IsPaused(ctx context.Context) (bool, error) {
+	return q.pausedQueues().IsPaused(ctx, q.KeyWithoutPrefix)
+}
+
+type redisPausedQueues struct {
+	client redis.Cmdable
+}
+
+// NewRedisPausedQueues returns paused queues kept in a Redis set.
+func NewRedisPausedQueues(client redis.Cmdable) PausedQueues {
+	return &redisPausedQueues{
+		client: client,
+	}
+}
+
+// key returns the key of the set of paused queues, it must not start with the queue prefix to stay out of ListQueueKeys.
+func (p *redisPausedQueues) key() string {
+	return rdb.AddPrefix("paused_queues")
+}
+
+func (p *redisPausedQueues) Pause(ctx context.Context, queue string) error {
+	return p.client.SAdd(ctx, p.key(), queue).Err()
+}
+
+func (p *redisPausedQueues) Resume(ctx context.Context, queue string) error {
+	return p.client.SRem(ctx, p.key(), queue).Err()
+}
+
+func (p *redisPausedQueues) IsPaused(ctx context.Context, queue string) (bool, error) {
+	return p.client.SIsMember(ctx, p.key(), queue).Result()
+}
+
+func (p *redisPausedQueues) List(ctx context.Context) ([]string, error) {
+	queues, err := p.client.SMembers(ctx, p.key()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(queues)
+	return queues, nil
+}
+
+type memoryPausedQueues struct {
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+// NewMemoryPausedQueues returns paused queues kept in process memory.
+func NewMemoryPausedQueues() PausedQueues {
+	return &memoryPausedQueues{
+		paused: make(map[string]bool),
+	}
+}
+
+func (p *memoryPausedQueues) Pause(ctx context.Context, queue string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused[queue] = true
+	return nil
+}
+
+func (p *memoryPausedQueues) Resume(ctx context.Context, queue string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.paused, queue)
+	return nil
+}
+
+func (p *memoryPausedQueues) IsPaused(ctx context.Context, queue string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused[queue], nil
+}
+
+func (p *memoryPausedQueues) List(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queues := make([]string, 0, len(p.paused))
+	for queue := range p.paused {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+
+	return queues, nil
+}
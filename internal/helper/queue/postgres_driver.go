@@ -0,0 +1,461 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
+)
+
+// PostgresPollInterval is how often a blocking Reserve polls the jobs table of an empty queue.
+const PostgresPollInterval = 250 * time.Millisecond
+
+/*
+postgresDriver keeps a queue in the jobs table only.
+
+A ready job is pending with an available_at in the past, a delayed job is pending with an
+available_at in the future. Workers reserve jobs with SELECT ... FOR UPDATE SKIP LOCKED,
+so concurrent workers never block each other nor take the same job, and the lease deadline
+of a processing job is kept in reserved_until.
+
+Only the jobs are kept in Postgres. The unique job locks, the paused queues, the cancellation flags,
+the worker registry and the job progress are kept in Redis, so a deployment on this driver still needs Redis.
+*/
+type postgresDriver struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresDriver(pool *pgxpool.Pool) Driver {
+	return &postgresDriver{
+		pool: pool,
+	}
+}
+
+const postgresJobColumns = `id, handler_name, payload, max_attempts, attempts, delay, timeout, backoff, priority, errors,
+	COALESCE(unique_key, ''), COALESCE(unique_until, ''), COALESCE(unique_for, 0), chain, input, batch_id, created_at`
+
+// scanJob reads a row selected with postgresJobColumns.
+func scanJob(row pgx.Row) (*job.Job, error) {
+	var j job.Job
+	var backoff, jobErrors, chain, input []byte
+
+	err := row.Scan(&j.ID, &j.HandlerName, &j.Payload, &j.MaxAttempts, &j.Attempts, &j.Delay, &j.Timeout, &backoff, &j.Priority, &jobErrors,
+		&j.UniqueKey, &j.UniqueUntil, &j.UniqueFor, &chain, &input, &j.BatchID, &j.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(backoff) > 0 && string(backoff) != "null" {
+		if err := sonic.Unmarshal(backoff, &j.Backoff); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(jobErrors) > 0 {
+		if err := sonic.Unmarshal(jobErrors, &j.Errors); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(chain) > 0 && string(chain) != "null" {
+		if err := sonic.Unmarshal(chain, &j.Chain); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(input) > 0 && string(input) != "null" {
+		j.Input = input
+	}
+
+	return &j, nil
+}
+
+// queryJobs runs a query selecting postgresJobColumns.
+func (d *postgresDriver) queryJobs(ctx context.Context, sql string, args ...any) ([]*job.Job, error) {
+	rows, err := d.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*job.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (d *postgresDriver) Push(ctx context.Context, queue string, jobs ...*job.Job) error {
+	batch := &pgx.Batch{}
+
+	for _, j := range jobs {
+		backoff, chain, err := marshalJobColumns(j)
+		if err != nil {
+			return err
+		}
+
+		batch.Queue(`
+			INSERT INTO jobs (id, queue, handler_name, payload, max_attempts, attempts, delay, timeout, backoff, priority,
+				unique_key, unique_until, unique_for, chain, input, batch_id, status, available_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, NOW())
+		`, j.ID, queue, j.HandlerName, j.Payload, j.MaxAttempts, j.Attempts, j.Delay, j.Timeout, backoff, j.Priority,
+			j.UniqueKey, j.UniqueUntil, j.UniqueFor, chain, []byte(j.Input), j.BatchID, job.StatusPending, time.Now().Add(time.Duration(j.Delay)*time.Second), j.CreatedAt)
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		logger.Log.Error("Error adding job to postgres", zap.Error(err))
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *postgresDriver) History() repository.JobRepository {
+	return repository.NewJobRepository(d.pool)
+}
+
+// Restore has nothing to do, the jobs table is the queue itself.
+func (d *postgresDriver) Restore(ctx context.Context, queue string, failed bool, jobs ...*job.Job) error {
+	return nil
+}
+
+func (d *postgresDriver) Reserve(ctx context.Context, queue string, lease time.Duration, wait time.Duration) (*job.Job, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		j, err := d.reserveOne(ctx, queue, lease)
+		if err != nil || j != nil {
+			return j, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(PostgresPollInterval):
+		}
+	}
+}
+
+// reserveOne reserves the next ready job, jobs locked by other workers are skipped.
+func (d *postgresDriver) reserveOne(ctx context.Context, queue string, lease time.Duration) (*job.Job, error) {
+	leaseExpiresAt := time.Now().Add(lease)
+
+	j, err := scanJob(d.pool.QueryRow(ctx, `
+		UPDATE jobs SET status = $2, attempts = attempts + 1, reserved_until = $3, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE queue = $1 AND status = $4 AND available_at <= NOW()
+			ORDER BY priority DESC, available_at, created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+postgresJobColumns,
+		queue, job.StatusProcessing, leaseExpiresAt, job.StatusPending))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j.LeaseExpiresAt = leaseExpiresAt
+	return j, nil
+}
+
+func (d *postgresDriver) Extend(ctx context.Context, queue string, jobID uuid.UUID, lease time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(lease)
+
+	tag, err := d.pool.Exec(ctx, `
+		UPDATE jobs SET reserved_until = $3
+		WHERE id = $1 AND queue = $2 AND status = $4 AND reserved_until IS NOT NULL
+	`, jobID, queue, deadline, job.StatusProcessing)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return time.Time{}, ErrLeaseLost
+	}
+
+	return deadline, nil
+}
+
+func (d *postgresDriver) Reserved(ctx context.Context, queue string, jobID uuid.UUID) (*job.Job, error) {
+	j, err := scanJob(d.pool.QueryRow(ctx, `
+		SELECT `+postgresJobColumns+` FROM jobs WHERE id = $1 AND queue = $2 AND status = $3
+	`, jobID, queue, job.StatusProcessing))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	return j, err
+}
+
+func (d *postgresDriver) Expired(ctx context.Context, queue string, lease time.Duration) ([]uuid.UUID, error) {
+	// Renewing the lease claims the job, so only one reaper returns it to the queue,
+	// and a job whose reaper crashes before settling it is reaped again once the claim expires
+	rows, err := d.pool.Query(ctx, `
+		UPDATE jobs SET reserved_until = $3
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE queue = $1 AND status = $2 AND reserved_until < NOW()
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`, queue, job.StatusProcessing, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobIDs []uuid.UUID
+	for rows.Next() {
+		var jobID uuid.UUID
+		if err := rows.Scan(&jobID); err != nil {
+			return nil, err
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	return jobIDs, rows.Err()
+}
+
+func (d *postgresDriver) Complete(ctx context.Context, queue string, j *job.Job) (bool, error) {
+	tag, err := d.pool.Exec(ctx, `
+		UPDATE jobs SET status = $3, reserved_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND queue = $2 AND status = $4
+	`, j.ID, queue, job.StatusCompleted, job.StatusProcessing)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (d *postgresDriver) Release(ctx context.Context, queue string, j *job.Job, at time.Time) (bool, error) {
+	jobErrors, err := sonic.Marshal(j.Errors)
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := d.pool.Exec(ctx, `
+		UPDATE jobs SET status = $3, attempts = $4, errors = $5, available_at = $6, reserved_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND queue = $2 AND status = $7
+	`, j.ID, queue, job.StatusPending, j.Attempts, jobErrors, at, job.StatusProcessing)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (d *postgresDriver) Bury(ctx context.Context, queue string, j *job.Job, reason string) (bool, error) {
+	jobErrors, err := sonic.Marshal(j.Errors)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE jobs SET status = $3, errors = $4, reserved_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND queue = $2 AND status = $5
+	`, j.ID, queue, job.StatusFailed, jobErrors, job.StatusProcessing)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO failed_jobs (job_id, queue, payload, error, reason, failed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`, j.ID, queue, j.Payload, strings.Join(j.Errors, ","), reason)
+	if err != nil {
+		logger.Log.Error("Error adding failed job to postgres", zap.Error(err))
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Promote has nothing to do, delayed jobs become ready by themselves once available_at has passed.
+func (d *postgresDriver) Promote(ctx context.Context, queue string) (int, error) {
+	return 0, nil
+}
+
+func (d *postgresDriver) RetryFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	retried, err := d.retryFailed(ctx, `id = $1 AND queue = $2`, jobID, queue)
+	return retried > 0, err
+}
+
+func (d *postgresDriver) RetryAllFailed(ctx context.Context, queue string) (int, error) {
+	return d.retryFailed(ctx, `queue = $1`, queue)
+}
+
+// retryFailed makes the failed jobs matching the condition pending again with their attempts reset.
+func (d *postgresDriver) retryFailed(ctx context.Context, condition string, args ...any) (int, error) {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE jobs SET status = '`+job.StatusPending+`', attempts = 0, available_at = NOW(), updated_at = NOW()
+		WHERE `+condition+` AND status = '`+job.StatusFailed+`'
+		RETURNING id
+	`, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var jobIDs []uuid.UUID
+	for rows.Next() {
+		var jobID uuid.UUID
+		if err := rows.Scan(&jobID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM failed_jobs WHERE job_id = ANY($1)`, jobIDs); err != nil {
+		return 0, err
+	}
+
+	return len(jobIDs), tx.Commit(ctx)
+}
+
+func (d *postgresDriver) RemoveFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	removed, err := d.deleteJobs(ctx, `id = $1 AND queue = $2 AND status = '`+job.StatusFailed+`'`, jobID, queue)
+	return removed > 0, err
+}
+
+func (d *postgresDriver) FlushFailed(ctx context.Context, queue string) (int64, error) {
+	return d.deleteJobs(ctx, `queue = $1 AND status = '`+job.StatusFailed+`'`, queue)
+}
+
+func (d *postgresDriver) Remove(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	removed, err := d.deleteJobs(ctx, `id = $1 AND queue = $2 AND status = '`+job.StatusPending+`' AND available_at <= NOW()`, jobID, queue)
+	return removed > 0, err
+}
+
+func (d *postgresDriver) Clear(ctx context.Context, queue string) (int64, error) {
+	return d.deleteJobs(ctx, `queue = $1 AND status = '`+job.StatusPending+`'`, queue)
+}
+
+// deleteJobs deletes the jobs matching the condition along with their failed_jobs rows and returns how many were deleted.
+func (d *postgresDriver) deleteJobs(ctx context.Context, condition string, args ...any) (int64, error) {
+	var deleted int64
+	err := d.pool.QueryRow(ctx, `
+		WITH deleted AS (
+			DELETE FROM jobs WHERE `+condition+` RETURNING id
+		), deleted_failed AS (
+			DELETE FROM failed_jobs WHERE job_id IN (SELECT id FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted
+	`, args...).Scan(&deleted)
+
+	return deleted, err
+}
+
+func (d *postgresDriver) Peek(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	return d.queryJobs(ctx, `
+		SELECT `+postgresJobColumns+` FROM jobs
+		WHERE queue = $1 AND status = $2 AND available_at <= NOW()
+		ORDER BY priority DESC, available_at, created_at
+		LIMIT $3
+	`, queue, job.StatusPending, count)
+}
+
+func (d *postgresDriver) PeekDelayed(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	return d.queryJobs(ctx, `
+		SELECT `+postgresJobColumns+` FROM jobs
+		WHERE queue = $1 AND status = $2 AND available_at > NOW()
+		ORDER BY available_at
+		LIMIT $3
+	`, queue, job.StatusPending, count)
+}
+
+func (d *postgresDriver) PeekFailed(ctx context.Context, queue string, offset int64, count int64) ([]*job.Job, error) {
+	return d.queryJobs(ctx, `
+		SELECT `+postgresJobColumns+` FROM jobs
+		WHERE queue = $1 AND status = $2
+		ORDER BY updated_at DESC
+		LIMIT $3 OFFSET $4
+	`, queue, job.StatusFailed, count, offset)
+}
+
+func (d *postgresDriver) Stats(ctx context.Context, queue string) (Stats, error) {
+	var stats Stats
+	err := d.pool.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE status = $2 AND available_at <= NOW()),
+			COUNT(*) FILTER (WHERE status = $3),
+			COUNT(*) FILTER (WHERE status = $2 AND available_at > NOW()),
+			COUNT(*) FILTER (WHERE status = $4)
+		FROM jobs WHERE queue = $1
+	`, queue, job.StatusPending, job.StatusProcessing, job.StatusFailed).Scan(&stats.Ready, &stats.Reserved, &stats.Delayed, &stats.Failed)
+
+	return stats, err
+}
+
+func (d *postgresDriver) Queues(ctx context.Context) ([]string, error) {
+	rows, err := d.pool.Query(ctx, `
+		SELECT DISTINCT queue FROM jobs WHERE status IN ($1, $2, $3) ORDER BY queue
+	`, job.StatusPending, job.StatusProcessing, job.StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queues []string
+	for rows.Next() {
+		var queue string
+		if err := rows.Scan(&queue); err != nil {
+			return nil, err
+		}
+		queues = append(queues, queue)
+	}
+
+	return queues, rows.Err()
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"strings"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+)
+
+const (
+	ModeStrict   = "strict"   // ModeStrict always polls the queues in the given order, so a queue is only polled once the previous ones are empty.
+	ModeWeighted = "weighted" // ModeWeighted polls the queues in weighted round robin, so every queue gets a share of the workers proportional to its weight.
+)
+
+// ConfiguredWeight returns the weight of the queue in the queue config, queues that are not configured have a weight of 1.
+func ConfiguredWeight(key string) int {
+	if config.GetConfig() == nil {
+		return 1
+	}
+
+	for _, q := range config.GetConfig().Queue.Queues {
+		if q.Name == key && q.Weight > 0 {
+			return q.Weight
+		}
+	}
+
+	return 1
+}
+
+// queuePicker decides in which order the queues are polled for the next job.
+type queuePicker struct {
+	mode    string
+	queues  []*Queue
+	current []int
+}
+
+func newQueuePicker(mode string, queues []*Queue) *queuePicker {
+	return &queuePicker{
+		mode:    mode,
+		queues:  queues,
+		current: make([]int, len(queues)),
+	}
+}
+
+// next returns the queues in the order they should be polled.
+// In weighted mode the queue picked by smooth weighted round robin comes first,
+// followed by the others in their given order so that idle queues do not waste a turn.
+func (p *queuePicker) next() []*Queue {
+	if p.mode != ModeWeighted || len(p.queues) < 2 {
+		return p.queues
+	}
+
+	total := 0
+	picked := 0
+	for i, q := range p.queues {
+		weight := q.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		p.current[i] += weight
+		total += weight
+		if p.current[i] > p.current[picked] {
+			picked = i
+		}
+	}
+	p.current[picked] -= total
+
+	order := make([]*Queue, 0, len(p.queues))
+	order = append(order, p.queues[picked])
+	order = append(order, p.queues[:picked]...)
+	order = append(order, p.queues[picked+1:]...)
+
+	return order
+}
+
+func queueNames(queues []*Queue) string {
+	names := make([]string, len(queues))
+	for i, q := range queues {
+		names[i] = q.KeyWithoutPrefix
+	}
+	return strings.Join(names, ", ")
+}
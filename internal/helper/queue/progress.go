@@ -0,0 +1,254 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ProgressTTL is how long the progress of a job is kept in the progress store after its last report,
+// the job history keeps it afterwards.
+const ProgressTTL = 24 * time.Hour
+
+var (
+	// progressWriteInterval is how often the progress reported by a handler is written to the progress store at most.
+	progressWriteInterval = time.Second
+	// progressHistoryInterval is how often it is mirrored to the job history at most.
+	progressHistoryInterval = 5 * time.Second
+)
+
+// ProgressStore holds the last progress reported by the handlers of running jobs.
+type ProgressStore interface {
+	SetProgress(ctx context.Context, jobID uuid.UUID, progress job.Progress) error
+	// GetProgress returns nil for a job that reported no progress.
+	GetProgress(ctx context.Context, jobID uuid.UUID) (*job.Progress, error)
+}
+
+// progressProvider is implemented by the drivers that keep the progress of jobs themselves.
+type progressProvider interface {
+	Progress() ProgressStore
+}
+
+// progressStore returns the progress store of the queue driver, a redis one if the driver has none.
+func (q *Queue) progressStore() ProgressStore {
+	if provider, ok := q.driver.(progressProvider); ok {
+		return provider.Progress()
+	}
+	return NewRedisProgressStore(rdb.GetRedisClient())
+}
+
+// Progress returns the last progress reported for a job, from the progress store or else from the job history.
+// It returns nil for a job that reported no progress.
+func (q *Queue) Progress(ctx context.Context, jobID uuid.UUID) (*job.Progress, error) {
+	progress, err := q.progressStore().GetProgress(ctx, jobID)
+	if err != nil || progress != nil {
+		return progress, err
+	}
+
+	history := q.history()
+	if history == nil {
+		return nil, nil
+	}
+
+	recorded, err := history.GetJobProgress(ctx, jobID)
+	if errors.Is(err, repository.ErrJobNotFound) {
+		return nil, nil
+	}
+	if err != nil || recorded.UpdatedAt == nil {
+		return nil, err
+	}
+
+	return &job.Progress{Percent: recorded.Percent, Message: recorded.Message, UpdatedAt: *recorded.UpdatedAt}, nil
+}
+
+// JobProgress returns the last progress reported for a job of the default driver, see Queue.Progress.
+func JobProgress(ctx context.Context, jobID uuid.UUID) (*job.Progress, error) {
+	return NewQueueWithDriver("", DefaultDriver()).Progress(ctx, jobID)
+}
+
+// progressReporter receives the progress reported by a handler and writes it in the background,
+// to the progress store every progressWriteInterval and to the job history every progressHistoryInterval,
+// so a handler reporting in a tight loop costs no round trip.
+type progressReporter struct {
+	q        *Queue
+	jobID    uuid.UUID
+	onReport func(job.Progress) // called on every report, e.g. to show the progress in the worker registry
+	done     chan struct{}
+	stopped  chan struct{}
+
+	mu       sync.Mutex
+	latest   *job.Progress
+	written  bool // whether latest is in the progress store
+	mirrored bool // whether latest is in the job history
+}
+
+// reportProgress starts writing the progress of a job reported with the returned reporter.
+// Its stop method must be called once the handler returned, it writes the last progress reported.
+func (q *Queue) reportProgress(ctx context.Context, jobID uuid.UUID, onReport func(job.Progress)) *progressReporter {
+	r := &progressReporter{
+		q:        q,
+		jobID:    jobID,
+		onReport: onReport,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go r.run(ctx)
+
+	return r
+}
+
+// report records the progress, it does not block on the writes.
+func (r *progressReporter) report(progress job.Progress) {
+	r.mu.Lock()
+	r.latest = &progress
+	r.written = false
+	r.mirrored = false
+	r.mu.Unlock()
+
+	if r.onReport != nil {
+		r.onReport(progress)
+	}
+}
+
+func (r *progressReporter) run(ctx context.Context) {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(progressWriteInterval)
+	defer ticker.Stop()
+	lastMirrored := time.Now()
+
+	for {
+		select {
+		case <-r.done:
+			r.flush(ctx, true)
+			return
+		case <-ticker.C:
+			mirror := time.Since(lastMirrored) >= progressHistoryInterval
+			if r.flush(ctx, mirror) && mirror {
+				lastMirrored = time.Now()
+			}
+		}
+	}
+}
+
+// flush writes the progress not written yet to the progress store, and to the job history if mirror is true.
+// It reports whether it mirrored anything. The progress is informative only, so errors are logged and do not fail the job.
+func (r *progressReporter) flush(ctx context.Context, mirror bool) bool {
+	r.mu.Lock()
+	progress := r.latest
+	write := !r.written
+	mirror = mirror && !r.mirrored
+	if progress == nil {
+		r.mu.Unlock()
+		return false
+	}
+	r.written = true
+	r.mirrored = r.mirrored || mirror
+	r.mu.Unlock()
+
+	if write {
+		if err := r.q.progressStore().SetProgress(ctx, r.jobID, *progress); err != nil {
+			logger.Log.Error("Error writing job progress", zap.String("job_id", r.jobID.String()), zap.Error(err))
+		}
+	}
+
+	if !mirror {
+		return false
+	}
+	if history := r.q.history(); history != nil {
+		if err := history.SetJobProgress(ctx, r.jobID, progress.Percent, progress.Message, progress.UpdatedAt); err != nil {
+			logger.Log.Error("Error recording job progress", zap.String("job_id", r.jobID.String()), zap.Error(err))
+		}
+	}
+	return true
+}
+
+// stop writes the last progress reported and stops the reporter.
+func (r *progressReporter) stop() {
+	close(r.done)
+	<-r.stopped
+}
+
+type redisProgressStore struct {
+	client redis.Cmdable
+}
+
+// NewRedisProgressStore returns a progress store kept in Redis, one key per job expiring after ProgressTTL.
+func NewRedisProgressStore(client redis.Cmdable) ProgressStore {
+	return &redisProgressStore{
+		client: client,
+	}
+}
+
+// key returns the key of the progress of a job, it must not start with the queue prefix to stay out of ListQueueKeys.
+func (s *redisProgressStore) key(jobID uuid.UUID) string {
+	return rdb.AddPrefix("job_progress:" + jobID.String())
+}
+
+func (s *redisProgressStore) SetProgress(ctx context.Context, jobID uuid.UUID, progress job.Progress) error {
+	progressBytes, err := sonic.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	return s.client.Set(ctx, s.key(jobID), progressBytes, ProgressTTL).Err()
+}
+
+func (s *redisProgressStore) GetProgress(ctx context.Context, jobID uuid.UUID) (*job.Progress, error) {
+	progressBytes, err := s.client.Get(ctx, s.key(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var progress job.Progress
+	if err := sonic.Unmarshal(progressBytes, &progress); err != nil {
+		return nil, err
+	}
+
+	return &progress, nil
+}
+
+type memoryProgressStore struct {
+	mu       sync.Mutex
+	progress map[uuid.UUID]job.Progress
+}
+
+// NewMemoryProgressStore returns a progress store kept in process memory.
+func NewMemoryProgressStore() ProgressStore {
+	return &memoryProgressStore{
+		progress: make(map[uuid.UUID]job.Progress),
+	}
+}
+
+func (s *memoryProgressStore) SetProgress(ctx context.Context, jobID uuid.UUID, progress job.Progress) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress[jobID] = progress
+	return nil
+}
+
+func (s *memoryProgressStore) GetProgress(ctx context.Context, jobID uuid.UUID) (*job.Progress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress, ok := s.progress[jobID]
+	if !ok {
+		return nil, nil
+	}
+	return &progress, nil
+}
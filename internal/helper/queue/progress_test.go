@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type progressTestPayload struct {
+	Rows int `json:"rows"`
+}
+
+func init() {
+	job.Register("ProgressTest", func(ctx context.Context, payload progressTestPayload) error {
+		for i := 1; i <= payload.Rows; i++ {
+			if err := job.ReportProgress(ctx, float64(i)*100/float64(payload.Rows), fmt.Sprintf("imported %d of %d rows", i, payload.Rows)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// countingProgressStore counts the writes to a memory progress store.
+type countingProgressStore struct {
+	ProgressStore
+	mu     sync.Mutex
+	writes int
+}
+
+func (s *countingProgressStore) SetProgress(ctx context.Context, jobID uuid.UUID, progress job.Progress) error {
+	s.mu.Lock()
+	s.writes++
+	s.mu.Unlock()
+	return s.ProgressStore.SetProgress(ctx, jobID, progress)
+}
+
+func Test_progress(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("the last progress reported by a handler is kept", func(t *testing.T) {
+		store := &countingProgressStore{ProgressStore: NewMemoryProgressStore()}
+		driver := NewMemoryDriver().(*memoryDriver)
+		driver.progress = store
+		q := NewQueueWithDriver("testing", driver)
+		j, _ := job.NewJob("ProgressTest", progressTestPayload{Rows: 1000}, 3, 0)
+		require.NoError(t, q.Enqueue(ctx, j))
+
+		require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 1}, q))
+
+		progress, err := q.Progress(ctx, j.ID)
+		require.NoError(t, err)
+		require.NotNil(t, progress)
+		assert.Equal(t, 100.0, progress.Percent)
+		assert.Equal(t, "imported 1000 of 1000 rows", progress.Message)
+		assert.Equal(t, 1, store.writes, "the reports are throttled")
+	})
+
+	t.Run("progress is written while the handler runs", func(t *testing.T) {
+		writeInterval := progressWriteInterval
+		progressWriteInterval = 10 * time.Millisecond
+		defer func() { progressWriteInterval = writeInterval }()
+
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		jobID := uuid.New()
+		var shown []job.Progress
+		reporter := q.reportProgress(ctx, jobID, func(progress job.Progress) {
+			shown = append(shown, progress)
+		})
+
+		reporter.report(job.Progress{Percent: 25, Message: "a quarter", UpdatedAt: time.Now()})
+		assert.Eventually(t, func() bool {
+			progress, err := q.Progress(ctx, jobID)
+			return err == nil && progress != nil && progress.Percent == 25
+		}, time.Second, 10*time.Millisecond)
+
+		reporter.stop()
+		assert.Len(t, shown, 1, "every report is shown to the worker")
+	})
+
+	t.Run("a job that reported nothing has no progress", func(t *testing.T) {
+		progress, err := NewQueueWithDriver("testing", NewMemoryDriver()).Progress(ctx, uuid.New())
+		require.NoError(t, err)
+		assert.Nil(t, progress)
+	})
+}
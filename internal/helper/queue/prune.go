@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+)
+
+// DefaultPruneBatchSize is the number of jobs deleted per statement when the options set none.
+const DefaultPruneBatchSize = 1000
+
+// Retention is how long the records of finished jobs are kept, 0 keeps them forever.
+type Retention struct {
+	Completed time.Duration
+	Failed    time.Duration
+	Cancelled time.Duration
+}
+
+// PruneOptions tells Prune which finished jobs to remove.
+type PruneOptions struct {
+	Default   Retention
+	Queues    map[string]Retention // retention of given queues, overriding Default
+	Only      string               // only prune this queue if set
+	BatchSize int                  // jobs deleted per statement, so no statement holds locks for long
+	Archive   bool                 // copy the pruned jobs to archived_jobs before deleting them
+	DryRun    bool                 // only count the jobs that would be pruned
+}
+
+// PruneResult is the number of jobs of a queue with a status that were pruned, or would be on a dry run.
+type PruneResult struct {
+	Queue  string    `json:"queue"`
+	Status string    `json:"status"`
+	Before time.Time `json:"before"` // jobs last updated before this time were pruned
+	Count  int64     `json:"count"`
+}
+
+// PruneOptionsFromConfig returns the prune options set by the queue.prune config.
+func PruneOptionsFromConfig(cfg config.QueuePrune) PruneOptions {
+	days := func(d int) time.Duration {
+		return time.Duration(d) * 24 * time.Hour
+	}
+
+	opts := PruneOptions{
+		Default:   Retention{Completed: days(cfg.Completed), Failed: days(cfg.Failed), Cancelled: days(cfg.Cancelled)},
+		Queues:    make(map[string]Retention),
+		BatchSize: cfg.BatchSize,
+		Archive:   cfg.Archive,
+	}
+	for _, q := range cfg.Queues {
+		retention := opts.Default
+		if q.Completed != nil {
+			retention.Completed = days(*q.Completed)
+		}
+		if q.Failed != nil {
+			retention.Failed = days(*q.Failed)
+		}
+		if q.Cancelled != nil {
+			retention.Cancelled = days(*q.Cancelled)
+		}
+		opts.Queues[q.Name] = retention
+	}
+
+	return opts
+}
+
+// retention returns the retention of the jobs of a queue.
+func (o PruneOptions) retention(queue string) Retention {
+	if retention, ok := o.Queues[queue]; ok {
+		return retention
+	}
+	return o.Default
+}
+
+// Prune deletes the completed, failed and cancelled jobs older than their retention, with their failed_jobs rows and attempts.
+// Every queue and status is pruned in batches of BatchSize jobs, each one in its own statement.
+// Jobs still waiting or running in a queue are never pruned.
+func Prune(ctx context.Context, repo repository.JobRepository, opts PruneOptions) ([]PruneResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultPruneBatchSize
+	}
+
+	queues := []string{opts.Only}
+	if opts.Only == "" {
+		var err error
+		queues, err = repo.GetFinishedJobQueues(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the queues of finished jobs: %w", err)
+		}
+	}
+
+	now := time.Now()
+	var results []PruneResult
+	for _, queue := range queues {
+		retention := opts.retention(queue)
+		for _, status := range []struct {
+			name      string
+			retention time.Duration
+		}{
+			{job.StatusCompleted, retention.Completed},
+			{job.StatusFailed, retention.Failed},
+			{job.StatusCancelled, retention.Cancelled},
+		} {
+			if status.retention <= 0 {
+				continue
+			}
+
+			result := PruneResult{Queue: queue, Status: status.name, Before: now.Add(-status.retention)}
+			count, err := pruneJobs(ctx, repo, result, opts)
+			if err != nil {
+				return results, fmt.Errorf("error pruning %s jobs of queue %s: %w", status.name, queue, err)
+			}
+
+			result.Count = count
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// pruneJobs prunes the jobs of a queue with a status last updated before the time of the result, batch by batch.
+func pruneJobs(ctx context.Context, repo repository.JobRepository, result PruneResult, opts PruneOptions) (int64, error) {
+	if opts.DryRun {
+		return repo.CountPrunableJobs(ctx, result.Queue, result.Status, result.Before)
+	}
+
+	var total int64
+	for {
+		count, err := repo.PruneJobs(ctx, result.Queue, result.Status, result.Before, opts.BatchSize, opts.Archive)
+		total += count
+		if err != nil || count < int64(opts.BatchSize) {
+			return total, err
+		}
+	}
+}
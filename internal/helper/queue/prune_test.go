@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pruneJobRepository holds the number of prunable jobs per queue and status, and records the prune calls.
+type pruneJobRepository struct {
+	repository.JobRepository
+	prunable map[string]int64 // by queue and status, e.g. "emails/completed"
+	calls    []string
+	archived bool
+}
+
+func (r *pruneJobRepository) GetFinishedJobQueues(ctx context.Context) ([]string, error) {
+	return []string{"default", "emails"}, nil
+}
+
+func (r *pruneJobRepository) CountPrunableJobs(ctx context.Context, queue string, status string, before time.Time) (int64, error) {
+	return r.prunable[queue+"/"+status], nil
+}
+
+func (r *pruneJobRepository) PruneJobs(ctx context.Context, queue string, status string, before time.Time, limit int, archive bool) (int64, error) {
+	key := queue + "/" + status
+	r.calls = append(r.calls, key)
+	r.archived = archive
+
+	count := r.prunable[key]
+	if count > int64(limit) {
+		count = int64(limit)
+	}
+	r.prunable[key] -= count
+	return count, nil
+}
+
+func Test_Prune(t *testing.T) {
+	ctx := context.Background()
+	day := 24 * time.Hour
+
+	t.Run("the retention of a queue overrides the default one", func(t *testing.T) {
+		emailsFailed := 90
+		opts := PruneOptionsFromConfig(config.QueuePrune{
+			Completed: 7,
+			Failed:    30,
+			Queues:    []config.QueueRetention{{Name: "emails", Failed: &emailsFailed}},
+		})
+
+		assert.Equal(t, Retention{Completed: 7 * day, Failed: 30 * day}, opts.retention("default"))
+		assert.Equal(t, Retention{Completed: 7 * day, Failed: 90 * day}, opts.retention("emails"))
+	})
+
+	t.Run("jobs are pruned in batches per queue and status", func(t *testing.T) {
+		repo := &pruneJobRepository{prunable: map[string]int64{
+			"default/completed": 5,
+			"emails/completed":  2,
+			"emails/failed":     1,
+		}}
+
+		results, err := Prune(ctx, repo, PruneOptions{
+			Default:   Retention{Completed: day, Failed: day},
+			BatchSize: 2,
+			Archive:   true,
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		assert.Equal(t, PruneResult{Queue: "default", Status: job.StatusCompleted, Before: results[0].Before, Count: 5}, results[0])
+		assert.WithinDuration(t, time.Now().Add(-day), results[0].Before, time.Minute)
+		assert.Equal(t, int64(0), results[1].Count)
+		assert.Equal(t, int64(2), results[2].Count)
+		assert.Equal(t, int64(1), results[3].Count)
+
+		assert.Equal(t, []string{
+			"default/completed", "default/completed", "default/completed",
+			"default/failed",
+			"emails/completed", "emails/completed",
+			"emails/failed",
+		}, repo.calls, "a queue and status is pruned until a batch is not full")
+		assert.True(t, repo.archived)
+	})
+
+	t.Run("cancelled jobs are pruned with their own retention", func(t *testing.T) {
+		emailsCancelled := 1
+		opts := PruneOptionsFromConfig(config.QueuePrune{
+			Cancelled: 7,
+			Queues:    []config.QueueRetention{{Name: "emails", Cancelled: &emailsCancelled}},
+		})
+		assert.Equal(t, Retention{Cancelled: 7 * day}, opts.retention("default"))
+		assert.Equal(t, Retention{Cancelled: day}, opts.retention("emails"))
+
+		repo := &pruneJobRepository{prunable: map[string]int64{"emails/cancelled": 3}}
+		opts.BatchSize = 100
+
+		results, err := Prune(ctx, repo, opts)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, PruneResult{Queue: "emails", Status: job.StatusCancelled, Before: results[1].Before, Count: 3}, results[1])
+		assert.WithinDuration(t, time.Now().Add(-day), results[1].Before, time.Minute)
+	})
+
+	t.Run("a status without retention is kept forever", func(t *testing.T) {
+		repo := &pruneJobRepository{prunable: map[string]int64{"emails/failed": 3}}
+
+		results, err := Prune(ctx, repo, PruneOptions{
+			Default: Retention{Completed: day},
+			Queues:  map[string]Retention{"emails": {}},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "default", results[0].Queue)
+		assert.Equal(t, int64(3), repo.prunable["emails/failed"])
+	})
+
+	t.Run("a dry run only counts the jobs", func(t *testing.T) {
+		repo := &pruneJobRepository{prunable: map[string]int64{"emails/failed": 3}}
+
+		results, err := Prune(ctx, repo, PruneOptions{
+			Default: Retention{Failed: day},
+			Only:    "emails",
+			DryRun:  true,
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, int64(3), results[0].Count)
+		assert.Empty(t, repo.calls)
+	})
+}
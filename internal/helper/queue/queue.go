@@ -2,31 +2,60 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"runtime/debug"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/google/uuid"
-	"github.com/kondohiroki/go-boilerplate/internal/db/model"
 	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
 	"github.com/kondohiroki/go-boilerplate/internal/job"
 	"github.com/kondohiroki/go-boilerplate/internal/logger"
-	"github.com/kondohiroki/go-boilerplate/internal/repository"
-	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 /*
-Queue is a FIFO.
-Implement redis BLMOVE with the RIGHT and LEFT arguments.
+Queue is a FIFO backed by a Driver, see DriverRedis, DriverPostgres and DriverMemory.
+
+Jobs with a delay wait until they are due, and are made ready by PromoteDelayed.
+
+Every dequeued job holds a lease until its deadline. Workers extend the lease while the job runs,
+and ReapExpired returns jobs whose lease ran out (e.g. the worker was killed) to the queue as a failed attempt.
 */
 
+const (
+	// DefaultVisibilityTimeout is how long a dequeued job stays invisible to other workers without a heartbeat.
+	DefaultVisibilityTimeout = 5 * time.Minute
+
+	// ReapInterval is how often Run looks for jobs with an expired lease.
+	ReapInterval = 10 * time.Second
+
+	// PausePollInterval is how often a worker whose queues are all paused checks whether one was resumed.
+	PausePollInterval = time.Second
+)
+
+// ErrLeaseExpired is recorded on a job that was returned to the queue because its lease expired.
+var ErrLeaseExpired = errors.New("job lease expired before the job was acknowledged")
+
+// ErrLeaseLost is returned by ExtendLease when the job no longer holds a lease.
+var ErrLeaseLost = errors.New("job lease lost")
+
+// ErrFailedJobNotFound is returned when a job is not in the failed list of the queue.
+var ErrFailedJobNotFound = errors.New("job not found in failed list")
+
 type Queue struct {
 	Key              string
 	KeyWithoutPrefix string
 
-	repo *repository.Repository
+	// VisibilityTimeout is the lease duration given to a dequeued job.
+	VisibilityTimeout time.Duration
+
+	// Weight is the share of the queue in weighted round robin consumption, see RunQueues.
+	Weight int
+
+	driver Driver
 }
 
 // QueueInfo holds information about a specific queue.
@@ -34,428 +63,364 @@ type QueueInfo struct {
 	Key              string `json:"key"`
 	KeyWithoutPrefix string `json:"key_without_prefix"`
 	NumberOfItems    int64  `json:"number_of_items"`
+	NumberOfDelayed  int64  `json:"number_of_delayed"`
+	NumberOfReserved int64  `json:"number_of_reserved"`
+	NumberOfFailed   int64  `json:"number_of_failed"`
+	Weight           int    `json:"weight"`
+	Paused           bool   `json:"paused"`
 }
 
+// NewQueue returns the queue with the given name on the default driver.
 func NewQueue(key string) *Queue {
+	return NewQueueWithDriver(key, DefaultDriver())
+}
+
+// NewQueueWithDriver returns the queue with the given name on the given driver.
+func NewQueueWithDriver(key string, driver Driver) *Queue {
 	return &Queue{
-		Key:              rdb.AddQueuePrefix(key),
-		KeyWithoutPrefix: key,
-		repo:             repository.NewRepository(),
+		Key:               rdb.AddQueuePrefix(key),
+		KeyWithoutPrefix:  key,
+		VisibilityTimeout: DefaultVisibilityTimeout,
+		Weight:            ConfiguredWeight(key),
+		driver:            driver,
 	}
 }
 
-// Adds an item to the source list (the end of the queue).
+// Adds jobs to the end of the queue.
+// A unique job that duplicates a queued one is skipped, and its ID is set to the ID of the queued job.
+// No job is added if one of them has a handler that is not registered, see job.Register.
+// The payloads of the handlers that opt in to it are encrypted in place, see job.EncryptedPayload.
 func (q *Queue) Enqueue(ctx context.Context, jobs ...*job.Job) error {
-	rdbClient := rdb.GetRedisClient()
-
 	for _, j := range jobs {
-		jobBytes, err := sonic.Marshal(j)
-		if err != nil {
+		if err := job.CheckRegistered(j); err != nil {
 			return err
 		}
+	}
+	if err := encryptPayloads(jobs); err != nil {
+		return err
+	}
 
-		// Add job to postgres for backup
-		_, err = q.repo.Job.AddJob(ctx, model.Job{
-			ID:          j.ID,
-			Queue:       q.KeyWithoutPrefix,
-			HandlerName: j.HandlerName,
-			Payload:     j.Payload,
-			MaxAttempts: j.MaxAttempts,
-			Delay:       j.Delay,
-			Status:      job.StatusPending,
-			CreatedAt:   j.CreatedAt,
-		})
-		if err != nil {
-			logger.Log.Error("Error adding job to postgres", zap.Error(err))
-			return err
+	pushed := make([]*job.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if j.IsUnique() {
+			acquired, err := q.acquireUnique(ctx, j)
+			if err != nil {
+				// None of the jobs are queued, so the locks already taken must not block their duplicates
+				for _, j := range pushed {
+					q.releaseUnique(ctx, j, j.UniqueUntil)
+				}
+				return err
+			}
+			if !acquired {
+				continue
+			}
 		}
+		pushed = append(pushed, j)
+	}
 
-		// Add job to redis
-		err = rdbClient.LPush(ctx, q.Key, jobBytes).Err()
-		if err != nil {
-			return err
-		}
+	if len(pushed) == 0 {
+		return nil
+	}
 
-		if err != nil {
-			logger.Log.Error("Error adding job to redis", zap.Error(err))
-			return err
+	if err := q.driver.Push(ctx, q.KeyWithoutPrefix, pushed...); err != nil {
+		// The jobs were not queued, so they must not block their duplicates
+		for _, j := range pushed {
+			q.releaseUnique(ctx, j, j.UniqueUntil)
 		}
+		return err
+	}
+
+	for _, j := range pushed {
+		q.publish(ctx, job.EventEnqueued, j, nil)
 	}
 
 	return nil
 }
 
-// Restore pending jobs from postgres to redis.
+// Restore pending jobs from postgres to the queue.
 func (q *Queue) EnqueuePendingJobs(ctx context.Context, jobs ...*job.Job) error {
-	rdbClient := rdb.GetRedisClient()
-
-	for _, j := range jobs {
-		jobBytes, err := sonic.Marshal(j)
-		if err != nil {
-			return err
-		}
-		if err != nil {
-			logger.Log.Error("Error adding job to postgres", zap.Error(err))
-			return err
-		}
+	return q.driver.Restore(ctx, q.KeyWithoutPrefix, false, jobs...)
+}
 
-		// Add job to redis
-		err = rdbClient.LPush(ctx, q.Key, jobBytes).Err()
-		if err != nil {
-			return err
-		}
+// Restore failed jobs from postgres to the failed list of the queue.
+func (q *Queue) EnqueueFailedJobs(ctx context.Context, jobs ...*job.Job) error {
+	return q.driver.Restore(ctx, q.KeyWithoutPrefix, true, jobs...)
+}
 
-		if err != nil {
-			logger.Log.Error("Error adding job to redis", zap.Error(err))
-			return err
-		}
+// Dequeue reserves the job at the start of the queue, waiting up to timeout for one. It returns nil if the queue stays empty.
+func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*job.Job, error) {
+	j, err := q.driver.Reserve(ctx, q.KeyWithoutPrefix, q.VisibilityTimeout, timeout)
+	if err != nil || j == nil {
+		return nil, err
 	}
 
-	return nil
+	q.releaseUnique(ctx, j, job.UniqueUntilProcessing)
+
+	return q.checkAttempts(ctx, j)
 }
 
-// Restore failed jobs from postgres to redis.
-func (q *Queue) EnqueueFailedJobs(ctx context.Context, jobs ...*job.Job) error {
-	rdbClient := rdb.GetRedisClient()
+// TryDequeue is Dequeue without blocking, it returns nil right away if the queue is empty.
+func (q *Queue) TryDequeue(ctx context.Context) (*job.Job, error) {
+	return q.Dequeue(ctx, 0)
+}
 
-	for _, j := range jobs {
-		jobBytes, err := sonic.Marshal(j)
-		if err != nil {
-			return err
-		}
+// checkAttempts moves a dequeued job that went over its maximum number of attempts to the failed list.
+func (q *Queue) checkAttempts(ctx context.Context, j *job.Job) (*job.Job, error) {
+	if j.MaxAttempts == 0 || j.Attempts <= j.MaxAttempts {
+		return j, nil
+	}
 
-		if err != nil {
-			logger.Log.Error("Error adding job to postgres", zap.Error(err))
-			return err
-		}
+	err := fmt.Errorf("job %s reached the maximum number of attempts (%d)", j.ID, j.MaxAttempts)
+	j.Errors = append(j.Errors, err.Error())
+	buried, buryErr := q.driver.Bury(ctx, q.KeyWithoutPrefix, j, job.FailureReasonError)
+	if buryErr != nil {
+		return nil, buryErr
+	}
+	// The job was reaped meanwhile, the reaper settles it
+	if !buried {
+		return nil, nil
+	}
+	q.releaseUnique(ctx, j, job.UniqueUntilCompleted)
+	q.settleBatch(ctx, j, true)
+	q.publish(ctx, job.EventDead, j, err)
 
-		// Add job to redis
-		err = rdbClient.LPush(ctx, q.Key+"_failed", jobBytes).Err()
-		if err != nil {
-			return err
-		}
+	return nil, err
+}
 
-		if err != nil {
-			logger.Log.Error("Error adding job to redis", zap.Error(err))
-			return err
+// ExtendLease pushes the lease deadline of a dequeued job VisibilityTimeout into the future.
+func (q *Queue) ExtendLease(ctx context.Context, jobID uuid.UUID) (time.Time, error) {
+	return q.driver.Extend(ctx, q.KeyWithoutPrefix, jobID, q.VisibilityTimeout)
+}
+
+// ReapExpired returns the jobs whose lease has expired back to the queue and returns how many were reaped.
+// A reaped job counts as a failed attempt, so it is retried or moved to the failed list like any other failure.
+func (q *Queue) ReapExpired(ctx context.Context) (int, error) {
+	expired, err := q.driver.Expired(ctx, q.KeyWithoutPrefix, q.VisibilityTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, jobID := range expired {
+		logger.Log.Warn("Job lease expired, returning it to the queue", zap.String("job_id", jobID.String()), zap.String("queue", q.KeyWithoutPrefix))
+		if err := q.RemoveProcessed(ctx, jobID, ErrLeaseExpired); err != nil {
+			return count, err
 		}
+		count++
 	}
 
-	return nil
+	return count, nil
 }
 
-// Removes an item from the source list (the start of the queue) and adds it to the destkey list (temporary storage location).
-func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*job.Job, error) {
-	sourceKey := q.Key
-	destKey := q.Key + "_attempt"
-	rdbClient := rdb.GetRedisClient()
-
-	// Move the job from the source list to the temporary list
-	result, err := rdbClient.BLMove(ctx, sourceKey, destKey, "RIGHT", "LEFT", timeout).Result()
+// RunReaper reaps jobs with an expired lease every interval until the context is canceled.
+func (q *Queue) RunReaper(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	if err == redis.Nil {
-		return nil, nil
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			reaped, err := q.ReapExpired(ctx)
+			if err != nil {
+				logger.Log.Error("Error reaping expired jobs", zap.Error(err))
+				continue
+			}
+			if reaped > 0 {
+				logger.Log.Info(fmt.Sprintf("returned %d jobs with an expired lease to %s", reaped, q.KeyWithoutPrefix))
+			}
+		}
 	}
+}
 
-	if err != nil {
-		return nil, err
-	}
+// Acknowledges the processed job with the given job ID. A failed job is retried or moved to the failed list.
+func (q *Queue) RemoveProcessed(ctx context.Context, jobID uuid.UUID, jobError error) error {
+	return q.acknowledge(ctx, jobID, nil, jobError)
+}
 
-	var j job.Job
-	err = sonic.Unmarshal([]byte(result), &j)
+// acknowledge is RemoveProcessed, the output of a successful job is given to the next job of its chain.
+func (q *Queue) acknowledge(ctx context.Context, jobID uuid.UUID, output json.RawMessage, jobError error) error {
+	j, err := q.driver.Reserved(ctx, q.KeyWithoutPrefix, jobID)
 	if err != nil {
-		return nil, err
-	}
-
-	// Update status of job in postgres
-	if err := q.repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusProcessing); err != nil {
-		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-		return nil, err
+		return err
 	}
 
-	// Check if the job has reached the maximum number of attempts
-	if j.MaxAttempts > 0 && j.Attempts >= j.MaxAttempts {
-		// Remove the job from the temporary list, it has reached the maximum number of attempts
-		_, _ = rdbClient.LRem(ctx, destKey, 1, result).Result()
-		return nil, fmt.Errorf("job %s reached the maximum number of attempts (%d)", j.ID, j.MaxAttempts)
+	// The job was already acknowledged, or reaped meanwhile
+	if j == nil {
+		return nil
 	}
 
-	// Increment the number of attempts and update the job in the temporary list
-	j.Attempts++
-	jobBytes, err := sonic.Marshal(&j)
-	if err != nil {
-		return nil, err
+	if errors.Is(jobError, job.ErrCancelled) {
+		return q.settleCancelled(ctx, j)
 	}
 
-	// Remove the job from the temporary list
-	_, err = rdbClient.LRem(ctx, destKey, 1, result).Result()
-	if err != nil {
-		return nil, err
+	// A released job goes back to the queue as if it was never taken
+	var release *job.ReleaseError
+	if errors.As(jobError, &release) {
+		j.Attempts--
+		_, err := q.driver.Release(ctx, q.KeyWithoutPrefix, j, time.Now().Add(release.Delay))
+		return err
 	}
 
-	// Add the updated job to the temporary list
-	err = rdbClient.LPush(ctx, destKey, jobBytes).Err()
-	if err != nil {
-		return nil, err
+	if jobError == nil {
+		// A job reaped meanwhile is back in the queue, its side effects belong to the attempt that settles it
+		completed, err := q.driver.Complete(ctx, q.KeyWithoutPrefix, j)
+		if err != nil || !completed {
+			return err
+		}
+		q.releaseUnique(ctx, j, job.UniqueUntilCompleted)
+		q.settleBatch(ctx, j, false)
+		q.publish(ctx, job.EventSucceeded, j, nil)
+		return q.dispatchNext(ctx, j, output)
 	}
 
-	return &j, nil
+	j.Errors = append(j.Errors, jobError.Error())
+	return q.handleFailedJob(ctx, j, jobError)
 }
 
-// Removes the processed item with the given job ID from the destkey list (temporary storage location).
-func (q *Queue) RemoveProcessed(ctx context.Context, jobID uuid.UUID, jobError error) error {
-	destKey := q.Key + "_attempt"
-	sourceKey := q.Key
-	failedJobsKey := q.Key + "_failed"
-	rdbClient := rdb.GetRedisClient()
-
-	queues := rdbClient.LRange(ctx, destKey, 0, -1)
-	for _, queueItem := range queues.Val() {
-		var j job.Job
-		if err := sonic.Unmarshal([]byte(queueItem), &j); err != nil {
+// If the job failed, retry it or move it to the failed list
+func (q *Queue) handleFailedJob(ctx context.Context, j *job.Job, jobError error) error {
+	canRetry := j.MaxAttempts == 0 || j.Attempts < j.MaxAttempts
+	if canRetry && !errors.Is(jobError, job.ErrNoRetry) {
+		retryAt := time.Now().Add(retryDelay(*j, jobError))
+		released, err := q.driver.Release(ctx, q.KeyWithoutPrefix, j, retryAt)
+		if err != nil || !released {
 			return err
 		}
 
-		if j.ID == jobID {
-			// Remove the job from the temporary list
-			if err := removeJobFromList(ctx, rdbClient, destKey, queueItem); err != nil {
-				return err
-			}
-
-			// if the job failed, add it to the failed_jobs list
-			if jobError != nil {
-				j.Errors = append(j.Errors, jobError.Error())
-				return handleFailedJob(ctx, rdbClient, q.KeyWithoutPrefix, q.repo, j, sourceKey, failedJobsKey)
-			}
-
-			// if the job was successful, then update the job status to completed in postgres
-			if err := q.repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusCompleted); err != nil {
-				logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-				return err
-			}
-
-			break
-		}
+		q.publish(ctx, job.EventFailed, j, jobError)
+		event := job.NewEvent(job.EventRetried, q.KeyWithoutPrefix, j, jobError)
+		event.RetryAt = &retryAt
+		job.Publish(ctx, event)
+		return nil
 	}
 
-	return nil
-}
-
-// Remove the job from the temporary list if it was processed successfully
-func removeJobFromList(ctx context.Context, rdbClient redis.Cmdable, destKey string, queueItem string) error {
-	// Remove the job from the temporary list
-	if _, err := rdbClient.LRem(ctx, destKey, 1, queueItem).Result(); err != nil {
+	logger.Log.Info("Job has reached the maximum number of attempts. It will be added to the failed_jobs list", zap.String("job_id", j.ID.String()))
+	buried, err := q.driver.Bury(ctx, q.KeyWithoutPrefix, j, failureReason(jobError))
+	if err != nil || !buried {
 		return err
 	}
+	q.publish(ctx, job.EventFailed, j, jobError)
+	q.releaseUnique(ctx, j, job.UniqueUntilCompleted)
+	q.settleBatch(ctx, j, true)
+	q.publish(ctx, job.EventDead, j, jobError)
+	if len(j.Chain) > 0 {
+		logger.Log.Info("Job failed, the rest of its chain is not dispatched", zap.String("job_id", j.ID.String()), zap.Int("chain_length", len(j.Chain)))
+	}
 	return nil
 }
 
-// If the job failed, add it to the failed_jobs list
-func handleFailedJob(ctx context.Context, rdbClient redis.Cmdable, queue string, repo *repository.Repository, j job.Job, sourceKey string, failedJobsKey string) error {
-	if j.MaxAttempts == 0 || j.Attempts < j.MaxAttempts {
-		time.Sleep(time.Duration(j.Delay) * time.Second)
-		jobBytes, err := sonic.Marshal(&j)
-		if err != nil {
-			return err
-		}
-
-		// Update job status in postgres
-		if err := repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusPending); err != nil {
-			logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-			return err
-		}
-
-		// Add the job back to the source list (the beginning of the queue) with the delay
-		err = rdbClient.LPush(ctx, sourceKey, jobBytes).Err()
-		if err != nil {
-			return err
-		}
-	} else {
-		// update job status in postgres
-		if err := repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusFailed); err != nil {
-			logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-			return err
-		}
+// publish publishes an event of a job of the queue to its subscribers, see job.Subscribe.
+func (q *Queue) publish(ctx context.Context, eventType job.EventType, j *job.Job, jobError error) {
+	job.Publish(ctx, job.NewEvent(eventType, q.KeyWithoutPrefix, j, jobError))
+}
 
-		// Add failed job to postgres
-		_, err := repo.Job.AddFailedJob(ctx, model.FaildJob{
-			JobID:    j.ID,
-			Queue:    queue,
-			Payload:  j.Payload,
-			Error:    strings.Join(j.Errors, ","),
-			FailedAt: time.Now(),
-		})
-		if err != nil {
-			logger.Log.Error("Error adding failed job to postgres", zap.Error(err))
-			return err
-		}
+// dispatchNext adds the next job of the chain of a successful job to the queue, with the output of the job as its input.
+func (q *Queue) dispatchNext(ctx context.Context, j *job.Job, output json.RawMessage) error {
+	next := j.Next(output)
+	if next == nil {
+		return nil
+	}
 
-		if err := addJobToFailedList(ctx, rdbClient, j, failedJobsKey); err != nil {
-			return err
-		}
+	if err := q.Enqueue(ctx, next); err != nil {
+		return fmt.Errorf("error dispatching the next job of the chain of job %s: %w", j.ID, err)
 	}
+
 	return nil
 }
 
-func addJobToFailedList(ctx context.Context, rdbClient redis.Cmdable, job job.Job, failedJobsKey string) error {
-	logger.Log.Info("Job has reached the maximum number of attempts. It will be added to the failed_jobs list", zap.String("job_id", job.ID.String()))
-	jobBytes, err := sonic.Marshal(&job)
-	if err != nil {
-		return err
-	}
-	err = rdbClient.LPush(ctx, failedJobsKey, jobBytes).Err()
-	if err != nil {
+// skipCanceled acknowledges a job of a canceled batch without running it, the rest of its chain is dropped.
+func (q *Queue) skipCanceled(ctx context.Context, j *job.Job) error {
+	logger.Log.Info("Skipping job of a canceled batch", zap.String("job_id", j.ID.String()), zap.String("batch_id", j.BatchID.String()))
+	completed, err := q.driver.Complete(ctx, q.KeyWithoutPrefix, j)
+	if err != nil || !completed {
 		return err
 	}
+	q.releaseUnique(ctx, j, job.UniqueUntilCompleted)
+	q.settleBatch(ctx, j, false)
 	return nil
 }
 
-// RetryFailedByJobID moves a failed item with the given job ID from the destkey list back to the source list for retrying.
-func (q *Queue) RetryFailedByJobID(ctx context.Context, jobID uuid.UUID) error {
-	failedJobsKey := q.Key + "_failed"
-	destkey := q.Key
-	rdbClient := rdb.GetRedisClient()
+// retryDelay returns how long a failed job waits before its next attempt.
+// A RetryAfter error from the handler wins over the backoff policy of the job, which wins over the job delay.
+func retryDelay(j job.Job, jobError error) time.Duration {
+	var retryAfter *job.RetryAfterError
+	if errors.As(jobError, &retryAfter) {
+		return retryAfter.Delay
+	}
 
-	queues, err := rdbClient.LRange(ctx, failedJobsKey, 0, -1).Result()
-	if err != nil {
-		return err
+	if j.Backoff != nil {
+		return j.Backoff.Next(j.Attempts)
 	}
 
-	found, err := findAndRetryJob(ctx, rdbClient, queues, jobID, failedJobsKey, destkey)
-	if err != nil {
-		return err
+	return time.Duration(j.Delay) * time.Second
+}
+
+// withHandlerBackoff applies the retry policy of the handler to a handler error,
+// unless the job has its own policy or the error already decides how to retry.
+func withHandlerBackoff(j *job.Job, handler job.ContextJobHandler, handlerError error) error {
+	if handlerError == nil || j.Backoff != nil || errors.Is(handlerError, job.ErrNoRetry) {
+		return handlerError
 	}
 
-	if !found {
-		return fmt.Errorf("job with ID %s not found in temporary list", jobID)
+	var release *job.ReleaseError
+	if errors.As(handlerError, &release) {
+		return handlerError
 	}
 
-	// update job status in postgres
-	if err := q.repo.Job.UpdateJobStatus(ctx, jobID, job.StatusPending); err != nil {
-		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-		return err
+	var retryAfter *job.RetryAfterError
+	if errors.As(handlerError, &retryAfter) {
+		return handlerError
 	}
 
-	// remove job from failed_jobs in postgres
-	if err := q.repo.Job.RemoveFailedJob(ctx, jobID); err != nil {
-		logger.Log.Error("Error removing job from failed_jobs in postgres", zap.Error(err))
-		return err
+	backoff, ok := job.HandlerBackoff(handler)
+	if !ok {
+		return handlerError
 	}
 
-	return nil
+	return job.RetryAfter(backoff.Next(j.Attempts), handlerError)
 }
 
-func findAndRetryJob(ctx context.Context, rdbClient redis.Cmdable, queues []string, jobID uuid.UUID, failedJobsKey, destkey string) (bool, error) {
-	for _, failedItem := range queues {
-		var job job.Job
-		if err := sonic.Unmarshal([]byte(failedItem), &job); err != nil {
-			return false, err
-		}
-
-		if job.ID == jobID {
-			if err := resetAndMoveJob(ctx, rdbClient, job, failedJobsKey, destkey, failedItem); err != nil {
-				return false, err
-			}
-			return true, nil
-		}
+// failureReason classifies the error that made a job fail for the failed_jobs table.
+func failureReason(jobError error) string {
+	switch {
+	case errors.Is(jobError, job.ErrTimeout):
+		return job.FailureReasonTimeout
+	case errors.Is(jobError, ErrLeaseExpired):
+		return job.FailureReasonLeaseExpired
 	}
-	return false, nil
+	return job.FailureReasonError
 }
 
-func resetAndMoveJob(ctx context.Context, rdbClient redis.Cmdable, job job.Job, failedJobsKey, destkey, failedItem string) error {
-	job.Attempts = 0
-	updatedItem, err := sonic.Marshal(&job)
+// RetryFailedByJobID moves the failed job with the given job ID back to the queue for retrying.
+func (q *Queue) RetryFailedByJobID(ctx context.Context, jobID uuid.UUID) error {
+	found, err := q.driver.RetryFailed(ctx, q.KeyWithoutPrefix, jobID)
 	if err != nil {
 		return err
 	}
 
-	if _, err := rdbClient.LRem(ctx, failedJobsKey, 1, failedItem).Result(); err != nil {
-		return err
-	}
-
-	err = rdbClient.RPush(ctx, destkey, updatedItem).Err()
-	if err != nil {
-		_ = rdbClient.LPush(ctx, failedJobsKey, failedItem).Err()
-		return err
+	if !found {
+		return fmt.Errorf("job with ID %s: %w", jobID, ErrFailedJobNotFound)
 	}
 
 	return nil
 }
 
-// Moves all failed items from the destkey list back to the source list for retrying.
+// Moves all failed jobs back to the queue for retrying.
 func (q *Queue) RetryAllFailed(ctx context.Context) (int, error) {
-	failedJobsKey := q.Key + "_failed"
-	destkey := q.Key
-	rdbClient := rdb.GetRedisClient()
-
-	count := 0
-
-	for {
-		// Remove the failed item from the temporary list.
-		failedItem, err := rdbClient.LPop(ctx, failedJobsKey).Result()
-		if err == redis.Nil {
-			// No more items left in the temporary list, break the loop.
-			break
-		}
-		if err != nil {
-			return count, err
-		}
-
-		// Reset the attempts counter
-		var j job.Job
-		if err := sonic.Unmarshal([]byte(failedItem), &j); err != nil {
-			return count, err
-		}
-		j.Attempts = 0
-		updatedItem, err := sonic.Marshal(&j)
-		if err != nil {
-			return count, err
-		}
-
-		// Add the failed item back to the source list with the reset attempts counter.
-		err = rdbClient.RPush(ctx, destkey, updatedItem).Err()
-		if err != nil {
-
-			// Add the failed item back to the temporary list in case of an RPush error.
-			_ = rdbClient.LPush(ctx, failedJobsKey, failedItem).Err()
-			return count, err
-		}
-
-		// update job status in postgres
-		if err := q.repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusPending); err != nil {
-			logger.Log.Error("Error updating job status in postgres", zap.Error(err))
-			return count, err
-		}
-
-		// remove job from failed_jobs in postgres
-		if err := q.repo.Job.RemoveFailedJob(ctx, j.ID); err != nil {
-			logger.Log.Error("Error removing job from failed_jobs in postgres", zap.Error(err))
-			return count, err
-		}
-
-		count++
-	}
-
-	return count, nil
+	return q.driver.RetryAllFailed(ctx, q.KeyWithoutPrefix)
 }
 
-// Returns the current length of the source list (the number of items in the queue).
+// Returns the number of ready jobs in the queue.
 func (q *Queue) Length(ctx context.Context) (int64, error) {
-	rdbClient := rdb.GetRedisClient()
-
-	length, err := rdbClient.LLen(ctx, q.Key).Result()
-	if err != nil {
-		return 0, err
-	}
-
-	return length, nil
+	stats, err := q.Stats(ctx)
+	return stats.Ready, err
 }
 
-// IsEmpty checks if the source list (queue) is empty.
+// IsEmpty checks if the queue has no ready jobs.
 func (q *Queue) IsEmpty(ctx context.Context) (bool, error) {
-	rdbClient := rdb.GetRedisClient()
-
-	length, err := rdbClient.LLen(ctx, q.Key).Result()
+	length, err := q.Length(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -463,201 +428,410 @@ func (q *Queue) IsEmpty(ctx context.Context) (bool, error) {
 	return length == 0, nil
 }
 
-// Clear removes all items from the source list (queue).
+// Stats counts the jobs of the queue in every state.
+func (q *Queue) Stats(ctx context.Context) (Stats, error) {
+	return q.driver.Stats(ctx, q.KeyWithoutPrefix)
+}
+
+// Clear removes all ready and delayed jobs from the queue.
 func (q *Queue) Clear(ctx context.Context) (int64, error) {
-	rdbClient := rdb.GetRedisClient()
+	return q.driver.Clear(ctx, q.KeyWithoutPrefix)
+}
+
+// RemoveJobByID removes the ready job with the matching job ID from the queue.
+func (q *Queue) RemoveJobByID(ctx context.Context, jobID uuid.UUID) (bool, error) {
+	return q.driver.Remove(ctx, q.KeyWithoutPrefix, jobID)
+}
 
-	// Get the length of the queue before deleting the key.
-	length, err := rdbClient.LLen(ctx, q.Key).Result()
+// RemoveFailedByID removes the failed job with the matching job ID from the failed list.
+func (q *Queue) RemoveFailedByID(ctx context.Context, jobID uuid.UUID) error {
+	found, err := q.driver.RemoveFailed(ctx, q.KeyWithoutPrefix, jobID)
 	if err != nil {
-		return 0, fmt.Errorf("error getting length of key %s: %w", q.Key, err)
+		return err
 	}
 
-	// Remove all items from the source list.
-	_, err = rdbClient.Del(ctx, q.Key).Result()
-	if err != nil {
-		return 0, err
+	if !found {
+		return fmt.Errorf("job with ID %s: %w", jobID, ErrFailedJobNotFound)
 	}
 
-	return length, nil
+	return nil
 }
 
-// RemoveJobByID removes the job with the matching job ID from the source list.
-func (q *Queue) RemoveJobByID(ctx context.Context, jobID uuid.UUID) (bool, error) {
-	rdbClient := rdb.GetRedisClient()
+// RemoveAllFailed removes all jobs from the failed list.
+func (q *Queue) RemoveAllFailed(ctx context.Context) (int64, error) {
+	return q.driver.FlushFailed(ctx, q.KeyWithoutPrefix)
+}
 
-	queues, err := rdbClient.LRange(ctx, q.Key, 0, -1).Result()
-	if err != nil {
-		return false, err
-	}
+// Peek returns the next N ready jobs, in the order they will be dequeued, without removing them.
+func (q *Queue) Peek(ctx context.Context, count int64) ([]*job.Job, error) {
+	return q.driver.Peek(ctx, q.KeyWithoutPrefix, count)
+}
 
-	for _, queueItem := range queues {
-		var job job.Job
-		if err := sonic.Unmarshal([]byte(queueItem), &job); err != nil {
-			return false, err
-		}
+// PeekFailed returns count failed jobs of the queue starting at offset, the most recently failed first.
+func (q *Queue) PeekFailed(ctx context.Context, offset int64, count int64) ([]*job.Job, error) {
+	return q.driver.PeekFailed(ctx, q.KeyWithoutPrefix, offset, count)
+}
 
-		if job.ID == jobID {
-			// Remove the job with the matching ID from the source list.
-			if _, err := rdbClient.LRem(ctx, q.Key, 1, queueItem).Result(); err != nil {
-				return false, err
-			}
+// DelayedLength returns the number of delayed jobs.
+func (q *Queue) DelayedLength(ctx context.Context) (int64, error) {
+	stats, err := q.Stats(ctx)
+	return stats.Delayed, err
+}
+
+// PeekDelayed returns the N delayed jobs due soonest without removing them.
+func (q *Queue) PeekDelayed(ctx context.Context, count int64) ([]*job.Job, error) {
+	return q.driver.PeekDelayed(ctx, q.KeyWithoutPrefix, count)
+}
+
+// PromoteDelayed makes the delayed jobs that are due ready and returns how many were promoted.
+func (q *Queue) PromoteDelayed(ctx context.Context) (int, error) {
+	return q.driver.Promote(ctx, q.KeyWithoutPrefix)
+}
+
+// RunPromoter promotes due delayed jobs every interval until the context is canceled.
+func (q *Queue) RunPromoter(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-			return true, nil
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Context canceled, stopping the delayed job promoter")
+			return ctx.Err()
+		case <-ticker.C:
+			promoted, err := q.PromoteDelayed(ctx)
+			if err != nil {
+				logger.Log.Error("Error promoting delayed jobs", zap.Error(err))
+				continue
+			}
+			if promoted > 0 {
+				logger.Log.Debug(fmt.Sprintf("promoted %d delayed jobs to %s", promoted, q.KeyWithoutPrefix))
+			}
 		}
 	}
+}
 
-	return false, nil
+// Run processes jobs from the queue until the context is canceled.
+func (q *Queue) Run(ctx context.Context) error {
+	return RunQueues(ctx, ModeStrict, q)
 }
 
-// RemoveFailedByID removes the failed item with the matching job ID from the failed list.
-func (q *Queue) RemoveFailedByID(ctx context.Context, jobID uuid.UUID) error {
-	failedJobsKey := q.Key + "_failed"
-	rdbClient := rdb.GetRedisClient()
+// RunQueues processes jobs from several queues until the context is canceled.
+// The mode decides which queue is polled first, see ModeStrict and ModeWeighted.
+func RunQueues(ctx context.Context, mode string, queues ...*Queue) error {
+	return RunWorker(ctx, WorkerOptions{Mode: mode}, queues...)
+}
 
-	queues, err := rdbClient.LRange(ctx, failedJobsKey, 0, -1).Result()
-	if err != nil {
-		return err
+// RunWorker processes jobs from several queues until the context is canceled or a limit of the options is reached.
+// Canceling the context stops the dequeuing, the job in flight gets the grace period of the options to finish
+// and is acknowledged on a context of its own, so a shutdown does not lose its result.
+// It returns the error of the context when canceled, and nil once a limit is reached.
+func RunWorker(ctx context.Context, opts WorkerOptions, queues ...*Queue) error {
+	if opts.Mode == "" {
+		opts.Mode = ModeStrict
 	}
 
-	found := false
-	for _, failedItem := range queues {
-		var job job.Job
-		if err := sonic.Unmarshal([]byte(failedItem), &job); err != nil {
-			return err
+	handlerMap := job.NewHandlerMap()
+	middleware := job.NewGlobalMiddleware()
+	waitingMessagePrinted := false
+	picker := newQueuePicker(opts.Mode, queues)
+
+	// The worker stops dequeuing once it has run for MaxTime, as if it was canceled
+	stopCtx := ctx
+	if opts.MaxTime > 0 {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(ctx, opts.MaxTime)
+		defer cancel()
+	}
+
+	// The registry entry and the reapers live until the job in flight is done, not only until ctx is canceled
+	runCtx, stopRun := context.WithCancel(withoutCancel(ctx))
+	w := newWorker(ctx, queues)
+	go w.run(runCtx)
+	defer func() {
+		stopRun()
+		<-w.stopped
+	}()
+
+	// Return jobs abandoned by dead workers to the queue
+	for _, q := range queues {
+		q := q
+		go func() {
+			_ = q.RunReaper(runCtx, ReapInterval)
+		}()
+	}
+
+	processed := 0
+	for {
+		select {
+		case <-stopCtx.Done():
+			if ctx.Err() != nil {
+				logger.Log.Info("Context canceled, stopping the Queue")
+				return ctx.Err()
+			}
+			logger.Log.Info("Worker reached its maximum run time, stopping the Queue", zap.Duration("max_time", opts.MaxTime))
+			return nil
+		default:
 		}
 
-		if job.ID == jobID {
-			found = true
+		next := picker.next()
+		dequeued, err := processJob(stopCtx, w, next, handlerMap, middleware, opts.GracePeriod)
+		if !dequeued && stopCtx.Err() != nil {
+			// The dequeuing was interrupted by the stop of the worker
+			continue
+		}
+		if err != nil {
+			logger.Log.Error("Error processing job", zap.Error(err))
+		}
 
-			// Remove the job with the matching ID from the failed list.
-			if _, err := rdbClient.LRem(ctx, failedJobsKey, 1, failedItem).Result(); err != nil {
-				return err
+		if !dequeued {
+			if err != nil {
+				continue
+			}
+			if opts.StopWhenEmpty {
+				logger.Log.Info(fmt.Sprintf("%s empty, stopping the Queue", queueNames(next)))
+				return nil
 			}
+			if !waitingMessagePrinted {
+				logger.Log.Info(fmt.Sprintf("waiting for %s ...", queueNames(next)))
+				waitingMessagePrinted = true
+			}
+			continue
+		}
+		waitingMessagePrinted = false
 
-			break
+		processed++
+		if opts.MaxJobs > 0 && processed >= opts.MaxJobs {
+			logger.Log.Info("Worker processed its maximum number of jobs, stopping the Queue", zap.Int("max_jobs", opts.MaxJobs))
+			return nil
+		}
+		if opts.memoryExceeded() {
+			logger.Log.Info("Worker exceeded its memory limit, stopping the Queue", zap.Uint64("memory_limit", opts.MemoryLimit))
+			return nil
 		}
 	}
+}
 
-	if !found {
-		return fmt.Errorf("job with ID %s not found in temporary list", jobID)
-	}
+// heartbeat keeps extending the lease of a job until the returned stop function is called.
+func (q *Queue) heartbeat(ctx context.Context, jobID uuid.UUID) (stop func()) {
+	done := make(chan struct{})
+	interval := q.VisibilityTimeout / 3
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := q.ExtendLease(ctx, jobID); err != nil {
+					logger.Log.Error("Error extending job lease", zap.String("job_id", jobID.String()), zap.Error(err))
+				}
+			}
+		}
+	}()
 
-	return nil
+	return func() { close(done) }
 }
 
-// RemoveAllFailed removes all items from the failed list.
-func (q *Queue) RemoveAllFailed(ctx context.Context) (int64, error) {
-	rdbClient := rdb.GetRedisClient()
+// runHandler runs the handler with a context carrying the job info, bounded by the job timeout.
+// A handler that ignores its context is abandoned once the timeout is reached.
+func runHandler(ctx context.Context, q *Queue, j *job.Job, handler job.ContextJobHandler) error {
+	handlerCtx := job.WithInfo(ctx, job.Info{
+		ID:          j.ID,
+		Queue:       q.KeyWithoutPrefix,
+		HandlerName: j.HandlerName,
+		Attempt:     j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		Input:       j.Input,
+	})
+
+	var cancel context.CancelFunc
+	if j.Timeout > 0 {
+		handlerCtx, cancel = context.WithTimeout(handlerCtx, time.Duration(j.Timeout)*time.Second)
+	} else {
+		handlerCtx, cancel = context.WithCancel(handlerCtx)
+	}
+	defer cancel()
 
-	// Get the length of the queue before deleting the key.
-	length, err := rdbClient.LLen(ctx, q.Key).Result()
-	if err != nil {
-		return 0, fmt.Errorf("error getting length of key %s: %w", q.Key, err)
+	done := make(chan error, 1)
+	go func() {
+		// The handler runs in its own goroutine, so its panics are not caught by processJob
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Log.Error("Recovered from panic", zap.Any("panic", r))
+				done <- &job.PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		done <- handler.HandleContext(handlerCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-handlerCtx.Done():
+		err = handlerCtx.Err()
 	}
 
-	// Remove all items from the failed list.
-	_, err = rdbClient.Del(ctx, q.Key+"_failed").Result()
-	if err != nil {
-		return 0, err
+	if err != nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %ds: %v", job.ErrTimeout, j.Timeout, err)
 	}
 
-	return length, nil
+	return err
 }
 
-// Peek returns the first N items in the source list without removing them.
-func (q *Queue) Peek(ctx context.Context, count int64) ([]interface{}, error) {
-	rdbClient := rdb.GetRedisClient()
+// dequeueNext dequeues a job from the first queue in order that has one, paused queues are skipped.
+// When all queues are empty it blocks on the first queue for a little while.
+func dequeueNext(ctx context.Context, queues []*Queue) (*Queue, *job.Job, error) {
+	queues = activeQueues(ctx, queues)
+	if len(queues) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(PausePollInterval):
+		}
+		return nil, nil, nil
+	}
 
-	rawItems, err := rdbClient.LRange(ctx, q.Key, 0, count-1).Result()
-	if err != nil {
-		return nil, err
+	if len(queues) == 1 {
+		j, err := queues[0].Dequeue(ctx, time.Second*5)
+		return queues[0], j, err
 	}
 
-	items := make([]interface{}, len(rawItems))
-	for i, rawItem := range rawItems {
-		var item interface{}
-		err = sonic.Unmarshal([]byte(rawItem), &item)
-		if err != nil {
-			return nil, err
+	for _, q := range queues {
+		j, err := q.TryDequeue(ctx)
+		if err != nil || j != nil {
+			return q, j, err
 		}
-		items[i] = item
 	}
 
-	return items, nil
+	j, err := queues[0].Dequeue(ctx, time.Second)
+	return queues[0], j, err
 }
 
-func (q *Queue) Run(ctx context.Context) error {
-	handlerMap := job.NewHandlerMap()
-	waitingMessagePrinted := false
-
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Log.Info("Context canceled, stopping the Queue")
-			return ctx.Err()
-		default:
-			err, printed := processJob(ctx, q, handlerMap, waitingMessagePrinted)
-			if err != nil {
-				logger.Log.Error("Error processing job", zap.Error(err))
-			}
-			waitingMessagePrinted = printed
+// activeQueues returns the queues that are not paused. A queue whose state cannot be read is kept.
+func activeQueues(ctx context.Context, queues []*Queue) []*Queue {
+	active := make([]*Queue, 0, len(queues))
+	for _, q := range queues {
+		paused, err := q.IsPaused(ctx)
+		if err != nil {
+			logger.Log.Error("Error checking whether the queue is paused", zap.String("queue", q.KeyWithoutPrefix), zap.Error(err))
+		}
+		if !paused {
+			active = append(active, q)
 		}
 	}
+	return active
 }
 
-func processJob(ctx context.Context, q *Queue, handlerMap job.HandlerMap, waitingMessagePrinted bool) (err error, printed bool) {
+// processJob dequeues a job from the queues and processes it, it reports whether a job was dequeued.
+// ctx only bounds the dequeuing, the job is processed on a context that outlives it by gracePeriod.
+func processJob(ctx context.Context, w *worker, queues []*Queue, handlerMap job.HandlerMap, middleware []job.Middleware, gracePeriod time.Duration) (dequeued bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic occurred while processing job: %v", r)
-			printed = waitingMessagePrinted
 			logger.Log.Error("Recovered from panic", zap.Any("panic", r))
 		}
 	}()
 
-	dequeuedJob, err := q.Dequeue(ctx, time.Second*5)
+	w.polled()
+	q, dequeuedJob, err := dequeueNext(ctx, queues)
 	if err != nil {
-		return fmt.Errorf("error dequeueing job: %w", err), waitingMessagePrinted
+		return false, fmt.Errorf("error dequeueing job: %w", err)
 	}
 
 	if dequeuedJob == nil {
-		if !waitingMessagePrinted {
-			logger.Log.Info(fmt.Sprintf("waiting for %s ...", q.KeyWithoutPrefix))
-			waitingMessagePrinted = true
-		}
-		return nil, waitingMessagePrinted
+		return false, nil
 	}
 
-	waitingMessagePrinted = false
+	// From now on the job is finished even if ctx is canceled, only its handler is stopped after the grace period
+	jobCtx := withoutCancel(ctx)
+	graceCtx, cancelGrace := withGracePeriod(ctx, gracePeriod)
+	defer cancelGrace()
 
 	logger.Log.Info("Starting job", zap.String("ID", dequeuedJob.ID.String()))
 
 	handlerFunc, ok := handlerMap[dequeuedJob.HandlerName]
 	if !ok {
 		err := fmt.Errorf("handler not found: %v", dequeuedJob.HandlerName)
-		q.RemoveProcessed(ctx, dequeuedJob.ID, err)
-		return err, waitingMessagePrinted
+		q.RemoveProcessed(jobCtx, dequeuedJob.ID, err)
+		return true, err
+	}
+
+	if q.batchCanceled(jobCtx, dequeuedJob) {
+		if err := q.skipCanceled(jobCtx, dequeuedJob); err != nil {
+			return true, fmt.Errorf("error skipping job of a canceled batch: %w", err)
+		}
+		return true, nil
+	}
+
+	if q.jobCancelled(jobCtx, dequeuedJob.ID) {
+		if err := q.settleCancelled(jobCtx, dequeuedJob); err != nil {
+			return true, fmt.Errorf("error settling cancelled job: %w", err)
+		}
+		return true, nil
+	}
+
+	// A payload that cannot be decrypted or decoded never will be, the job fails without further attempts
+	payload, err := decryptPayload(dequeuedJob)
+	if err != nil {
+		err = job.NoRetry(fmt.Errorf("error decrypting job payload: %w", err))
+		q.RemoveProcessed(jobCtx, dequeuedJob.ID, err)
+		return true, err
 	}
 
 	handler := handlerFunc()
-	err = sonic.Unmarshal(dequeuedJob.Payload, handler)
+	err = sonic.Unmarshal(payload, handler)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling job payload: %w", err), waitingMessagePrinted
+		err = job.NoRetry(fmt.Errorf("error unmarshaling job payload: %w", err))
+		q.RemoveProcessed(jobCtx, dequeuedJob.ID, err)
+		return true, err
 	}
 
 	logger.Log.Info("Processing job", zap.String("ID", dequeuedJob.ID.String()), zap.String("handler", dequeuedJob.HandlerName))
-	handlerError := handler.Handle()
+	w.processing(dequeuedJob.ID)
+	defer w.processing(uuid.Nil)
+	q.publish(jobCtx, job.EventStarted, dequeuedJob, nil)
+	stopHeartbeat := q.heartbeat(graceCtx, dequeuedJob.ID)
+	startedAt := time.Now()
+	outputCtx := job.WithOutput(graceCtx)
+	progress := q.reportProgress(jobCtx, dequeuedJob.ID, w.progressed)
+	handlerCtx, stopWatching := q.watchCancellation(job.WithProgress(outputCtx, progress.report), dequeuedJob.ID)
+	handlerError := runHandler(handlerCtx, q, dequeuedJob, job.Pipeline(handler, middleware...))
+	handlerError = withHandlerBackoff(dequeuedJob, handler, handlerError)
+	cancelled := errors.Is(context.Cause(handlerCtx), job.ErrCancelled)
+	stopWatching()
+	progress.stop()
+	stopHeartbeat()
+
+	// A handler stopped by the cancellation of its job did not fail, its job is settled as cancelled.
+	// A handler stopped by the end of the grace period did not fail either, its job goes back to the queue
+	if handlerError != nil && cancelled {
+		handlerError = job.ErrCancelled
+	} else if handlerError != nil && graceCtx.Err() != nil {
+		logger.Log.Warn("Job stopped by the shutdown of the worker", zap.String("ID", dequeuedJob.ID.String()), zap.Duration("grace_period", gracePeriod))
+		handlerError = job.Release(0)
+	}
 
 	logger.Log.Info("Finished processing job", zap.String("ID", dequeuedJob.ID.String()), zap.String("handler", dequeuedJob.HandlerName), zap.Any("error", handlerError))
 
-	if handlerError != nil {
+	var release *job.ReleaseError
+	if errors.As(handlerError, &release) {
+		logger.Log.Info("Job released back to the queue", zap.String("ID", dequeuedJob.ID.String()), zap.Duration("delay", release.Delay))
+	} else if handlerError != nil && !errors.Is(handlerError, job.ErrCancelled) {
 		logger.Log.Error("Error handling job: %v", zap.String("ID", dequeuedJob.ID.String()), zap.String("handler", dequeuedJob.HandlerName), zap.Any("error", handlerError))
 	}
 
-	err = q.RemoveProcessed(ctx, dequeuedJob.ID, handlerError)
+	output := job.OutputFromContext(outputCtx)
+	q.recordAttempt(jobCtx, dequeuedJob, startedAt, handlerError, output)
+
+	err = q.acknowledge(jobCtx, dequeuedJob.ID, output, handlerError)
 	if err != nil {
-		return fmt.Errorf("error removing processed job: %w", err), waitingMessagePrinted
+		return true, fmt.Errorf("error removing processed job: %w", err)
 	}
 
-	return nil, waitingMessagePrinted
+	return true, nil
 }
@@ -0,0 +1,211 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	configFile := "../../../config/config.testing.yaml"
+	config.SetConfig(configFile)
+	logger.InitLogger("zap")
+}
+
+type contextHandlerFunc func(ctx context.Context) error
+
+func (f contextHandlerFunc) HandleContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+// reapingDriver is the memory driver whose reaper takes the job back right before it is completed.
+type reapingDriver struct {
+	*memoryDriver
+	q *Queue
+}
+
+func (d *reapingDriver) Complete(ctx context.Context, queue string, j *job.Job) (bool, error) {
+	if _, err := d.q.ReapExpired(ctx); err != nil {
+		return false, err
+	}
+	return d.memoryDriver.Complete(ctx, queue, j)
+}
+
+func Test_acknowledgeReaped(t *testing.T) {
+	ctx := context.Background()
+
+	var events []job.EventType
+	unsubscribe := job.SubscribeHandler("ProcessExample", func(ctx context.Context, event job.Event) {
+		events = append(events, event.Type)
+	})
+	defer unsubscribe()
+
+	driver := &reapingDriver{memoryDriver: NewMemoryDriver().(*memoryDriver)}
+	q := NewQueueWithDriver("testing", driver)
+	q.VisibilityTimeout = time.Millisecond
+	driver.q = q
+
+	first, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "a"}, 3, 0)
+	second, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "b"}, 3, 0)
+	chained, err := job.NewChain(first, second)
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(ctx, chained))
+
+	dequeued, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, nil))
+
+	assert.Equal(t, []job.EventType{job.EventEnqueued, job.EventFailed, job.EventRetried}, events, "the reaped job does not succeed")
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Ready: 1}, stats, "the next job of the chain is not dispatched")
+
+	redelivered, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, redelivered.ID)
+}
+
+func Test_runHandler(t *testing.T) {
+	q := &Queue{KeyWithoutPrefix: "testing"}
+
+	t.Run("handler receives the job info", func(t *testing.T) {
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j.Attempts = 2
+
+		err := runHandler(context.Background(), q, j, contextHandlerFunc(func(ctx context.Context) error {
+			info, ok := job.InfoFromContext(ctx)
+			assert.True(t, ok, "the context should carry the job info")
+			assert.Equal(t, j.ID, info.ID)
+			assert.Equal(t, "testing", info.Queue)
+			assert.Equal(t, 2, info.Attempt)
+			return nil
+		}))
+		assert.NoError(t, err)
+	})
+
+	t.Run("handler exceeding its timeout fails with ErrTimeout", func(t *testing.T) {
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j.Timeout = 1
+
+		err := runHandler(context.Background(), q, j, contextHandlerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		assert.ErrorIs(t, err, job.ErrTimeout)
+		assert.Equal(t, job.FailureReasonTimeout, failureReason(err))
+	})
+
+	t.Run("handler ignoring its context is abandoned at the timeout", func(t *testing.T) {
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+		j.Timeout = 1
+
+		start := time.Now()
+		err := runHandler(context.Background(), q, j, contextHandlerFunc(func(ctx context.Context) error {
+			time.Sleep(3 * time.Second)
+			return nil
+		}))
+		assert.ErrorIs(t, err, job.ErrTimeout)
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	t.Run("handler panic is returned as an error", func(t *testing.T) {
+		j, _ := job.NewJob("ProcessExample", nil, 3, 0)
+
+		err := runHandler(context.Background(), q, j, contextHandlerFunc(func(ctx context.Context) error {
+			panic("boom")
+		}))
+		assert.Error(t, err)
+		assert.Equal(t, job.FailureReasonError, failureReason(err))
+	})
+
+	t.Run("legacy handler runs through the adapter", func(t *testing.T) {
+		j, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "Sawadeee Kaab!"}, 3, 0)
+		handler := job.NewHandlerMap()["ProcessExample"]()
+
+		err := runHandler(context.Background(), q, j, handler)
+		assert.NoError(t, err)
+	})
+
+	t.Run("lease expiry is classified", func(t *testing.T) {
+		assert.Equal(t, job.FailureReasonLeaseExpired, failureReason(ErrLeaseExpired))
+		assert.Equal(t, job.FailureReasonError, failureReason(errors.New("some error")))
+	})
+}
+
+type backoffHandler struct {
+	contextHandlerFunc
+}
+
+func (backoffHandler) Backoff() job.Backoff {
+	return *job.NewFixedBackoff(30)
+}
+
+func Test_retryDelay(t *testing.T) {
+	cause := errors.New("ncb is down")
+
+	t.Run("job delay is used without a policy", func(t *testing.T) {
+		j := job.Job{Delay: 5, Attempts: 2}
+		assert.Equal(t, 5*time.Second, retryDelay(j, cause))
+	})
+
+	t.Run("job backoff wins over the job delay", func(t *testing.T) {
+		j := job.Job{Delay: 5, Attempts: 2, Backoff: job.NewLinearBackoff(10)}
+		assert.Equal(t, 20*time.Second, retryDelay(j, cause))
+	})
+
+	t.Run("retry after wins over the job backoff", func(t *testing.T) {
+		j := job.Job{Delay: 5, Attempts: 2, Backoff: job.NewLinearBackoff(10)}
+		assert.Equal(t, time.Minute, retryDelay(j, job.RetryAfter(time.Minute, cause)))
+	})
+
+	t.Run("handler backoff applies when the job has none", func(t *testing.T) {
+		j := &job.Job{Attempts: 1}
+		err := withHandlerBackoff(j, backoffHandler{}, cause)
+		assert.ErrorIs(t, err, cause)
+		assert.Equal(t, 30*time.Second, retryDelay(*j, err))
+	})
+
+	t.Run("handler backoff does not override no retry", func(t *testing.T) {
+		j := &job.Job{Attempts: 1}
+		err := withHandlerBackoff(j, backoffHandler{}, job.NoRetry(cause))
+		assert.ErrorIs(t, err, job.ErrNoRetry)
+	})
+}
+
+func Test_queuePicker(t *testing.T) {
+	critical := &Queue{KeyWithoutPrefix: "critical", Weight: 3}
+	normal := &Queue{KeyWithoutPrefix: "default", Weight: 1}
+
+	t.Run("strict mode keeps the given order", func(t *testing.T) {
+		picker := newQueuePicker(ModeStrict, []*Queue{normal, critical})
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, []*Queue{normal, critical}, picker.next())
+		}
+	})
+
+	t.Run("weighted mode picks queues proportionally to their weight", func(t *testing.T) {
+		picker := newQueuePicker(ModeWeighted, []*Queue{critical, normal})
+		picks := map[string]int{}
+		for i := 0; i < 400; i++ {
+			order := picker.next()
+			assert.Len(t, order, 2, "every queue should still be polled")
+			picks[order[0].KeyWithoutPrefix]++
+		}
+		assert.Equal(t, 300, picks["critical"])
+		assert.Equal(t, 100, picks["default"])
+	})
+
+	t.Run("unconfigured queues have a weight of 1", func(t *testing.T) {
+		assert.Equal(t, 1, ConfiguredWeight("not-configured"))
+		assert.Equal(t, 5, ConfiguredWeight("critical"))
+	})
+}
@@ -0,0 +1,717 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+/*
+redisDriver keeps a queue in Redis lists and mirrors the status of every job to the jobs table.
+Jobs are moved between its keys by the Lua scripts of redis_scripts.go, so a crash never loses nor duplicates a job.
+
+  - the source list holds the ready jobs, they are pushed on the left and reserved from the right
+  - the "_reserved" hash holds the reserved jobs by job ID
+  - the "_failed" list holds the failed jobs
+  - the "_delayed" sorted set holds the delayed jobs, scored by the time they become due
+  - the "_lease" sorted set holds the job IDs of the reserved jobs, scored by their lease deadline
+*/
+type redisDriver struct {
+	client redis.Cmdable
+	repo   repository.JobRepository
+}
+
+func NewRedisDriver(client redis.Cmdable, repo repository.JobRepository) Driver {
+	return &redisDriver{
+		client: client,
+		repo:   repo,
+	}
+}
+
+func (d *redisDriver) History() repository.JobRepository {
+	return d.repo
+}
+
+func (d *redisDriver) Locks() UniqueLocks {
+	return NewRedisLocks(d.client)
+}
+
+func (d *redisDriver) key(queue string) string {
+	return rdb.AddQueuePrefix(queue)
+}
+
+// Push adds the jobs to postgres in one batch, then to redis in one pipeline.
+func (d *redisDriver) Push(ctx context.Context, queue string, jobs ...*job.Job) error {
+	sourceKey := d.key(queue)
+
+	// Add jobs to postgres for backup
+	if err := recordPushed(ctx, d.repo, queue, jobs...); err != nil {
+		return err
+	}
+
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, j := range jobs {
+			jobBytes, err := sonic.Marshal(j)
+			if err != nil {
+				return err
+			}
+
+			// Add job to redis, delayed jobs wait in the delayed set until they are due
+			if j.Delay > 0 {
+				err = addJobToDelayedSet(ctx, pipe, sourceKey+"_delayed", jobBytes, time.Now().Add(time.Duration(j.Delay)*time.Second))
+			} else {
+				err = pushReady(ctx, pipe, sourceKey, j.Priority, jobBytes)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error("Error adding job to redis", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (d *redisDriver) Restore(ctx context.Context, queue string, failed bool, jobs ...*job.Job) error {
+	sourceKey := d.key(queue)
+
+	for _, j := range jobs {
+		jobBytes, err := sonic.Marshal(j)
+		if err != nil {
+			return err
+		}
+
+		if failed {
+			err = d.client.LPush(ctx, sourceKey+"_failed", jobBytes).Err()
+		} else {
+			err = pushReady(ctx, d.client, sourceKey, j.Priority, jobBytes)
+		}
+
+		if err != nil {
+			logger.Log.Error("Error adding job to redis", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *redisDriver) Reserve(ctx context.Context, queue string, lease time.Duration, wait time.Duration) (*job.Job, error) {
+	sourceKey := d.key(queue)
+	deadline := time.Now().Add(wait)
+
+	for {
+		j, err := d.reserveOne(ctx, queue, lease)
+		if err != nil || j != nil {
+			return j, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+
+		// Moving the last item to the end of its own list leaves the queue untouched,
+		// it only blocks until there is a job to reserve.
+		err = d.client.BLMove(ctx, sourceKey, sourceKey, "RIGHT", "RIGHT", remaining).Err()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reserveOne reserves the job at the start of the queue, or returns nil if the queue is empty.
+func (d *redisDriver) reserveOne(ctx context.Context, queue string, lease time.Duration) (*job.Job, error) {
+	sourceKey := d.key(queue)
+	leaseExpiresAt := time.Now().Add(lease)
+
+	result, err := reserveScript.Run(ctx, d.client,
+		[]string{sourceKey, sourceKey + "_reserved", sourceKey + "_lease"},
+		leaseExpiresAt.UnixMilli(),
+	).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var j job.Job
+	if err := sonic.Unmarshal([]byte(result), &j); err != nil {
+		return nil, err
+	}
+
+	// Update status of job in postgres
+	if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusProcessing); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return nil, err
+	}
+
+	// The reserved hash keeps the job as it was before this attempt
+	j.Attempts++
+	j.LeaseExpiresAt = leaseExpiresAt
+
+	return &j, nil
+}
+
+func (d *redisDriver) Extend(ctx context.Context, queue string, jobID uuid.UUID, lease time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(lease)
+
+	// XX only updates the lease if it still exists, CH makes the result count the update
+	changed, err := d.client.ZAddArgs(ctx, d.key(queue)+"_lease", redis.ZAddArgs{
+		XX: true,
+		Ch: true,
+		Members: []redis.Z{{
+			Score:  float64(deadline.UnixMilli()),
+			Member: jobID.String(),
+		}},
+	}).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if changed == 0 {
+		return time.Time{}, ErrLeaseLost
+	}
+
+	return deadline, nil
+}
+
+func (d *redisDriver) Reserved(ctx context.Context, queue string, jobID uuid.UUID) (*job.Job, error) {
+	item, err := d.client.HGet(ctx, d.key(queue)+"_reserved", jobID.String()).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var j job.Job
+	if err := sonic.Unmarshal(item, &j); err != nil {
+		return nil, err
+	}
+
+	// Count the attempt in progress, see reserveOne
+	j.Attempts++
+
+	return &j, nil
+}
+
+func (d *redisDriver) Expired(ctx context.Context, queue string, lease time.Duration) ([]uuid.UUID, error) {
+	// Only the reaper that manages to renew the expired lease may return the job to the queue
+	now := time.Now()
+	expired, err := reapScript.Run(ctx, d.client,
+		[]string{d.key(queue) + "_lease"},
+		now.UnixMilli(), 100, now.Add(lease).UnixMilli(),
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobIDs []uuid.UUID
+	for _, member := range expired {
+		jobID, err := uuid.Parse(member)
+		if err != nil {
+			logger.Log.Error("Invalid job id in lease set", zap.String("member", member), zap.Error(err))
+			continue
+		}
+
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	return jobIDs, nil
+}
+
+// settle takes a reserved job out of the reserved hash and moves it to the destination, see settleScript.
+// It reports false if the job was not reserved anymore.
+func (d *redisDriver) settle(ctx context.Context, queue string, j *job.Job, destination string, at time.Time) (bool, error) {
+	sourceKey := d.key(queue)
+
+	var jobBytes []byte
+	if destination != "none" {
+		var err error
+		if jobBytes, err = sonic.Marshal(j); err != nil {
+			return false, err
+		}
+	}
+
+	settled, err := settleScript.Run(ctx, d.client,
+		[]string{sourceKey, sourceKey + "_delayed", sourceKey + "_failed", sourceKey + "_reserved", sourceKey + "_lease"},
+		j.ID.String(), destination, jobBytes, at.UnixMilli(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return settled == 1, nil
+}
+
+func (d *redisDriver) Complete(ctx context.Context, queue string, j *job.Job) (bool, error) {
+	settled, err := d.settle(ctx, queue, j, "none", time.Time{})
+	if err != nil || !settled {
+		return false, err
+	}
+
+	// The job was successful, update the job status to completed in postgres
+	if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusCompleted); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return true, err
+	}
+
+	return true, nil
+}
+
+func (d *redisDriver) Release(ctx context.Context, queue string, j *job.Job, at time.Time) (bool, error) {
+	// Add the job back to the source list, or to the delayed set if the retry has to wait
+	destination := "ready"
+	if at.After(time.Now()) {
+		destination = "delayed"
+	} else if j.Priority > 0 {
+		destination = "priority"
+	}
+
+	settled, err := d.settle(ctx, queue, j, destination, at)
+	if err != nil || !settled {
+		return false, err
+	}
+
+	// Update job status in postgres
+	if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusPending); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return true, err
+	}
+
+	return true, nil
+}
+
+func (d *redisDriver) Bury(ctx context.Context, queue string, j *job.Job, reason string) (bool, error) {
+	settled, err := d.settle(ctx, queue, j, "failed", time.Time{})
+	if err != nil || !settled {
+		return false, err
+	}
+
+	return true, recordFailed(ctx, d.repo, queue, j, reason)
+}
+
+func (d *redisDriver) Promote(ctx context.Context, queue string) (int, error) {
+	sourceKey := d.key(queue)
+
+	return promoteScript.Run(ctx, d.client,
+		[]string{sourceKey + "_delayed", sourceKey},
+		time.Now().UnixMilli(), 100,
+	).Int()
+}
+
+func (d *redisDriver) RetryFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+
+	items, err := d.client.LRange(ctx, failedJobsKey, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+
+	found, err := findAndRetryJob(ctx, d.client, items, jobID, failedJobsKey, d.key(queue))
+	if err != nil || !found {
+		return false, err
+	}
+
+	return true, markRetried(ctx, d.repo, jobID)
+}
+
+func findAndRetryJob(ctx context.Context, rdbClient redis.Cmdable, queues []string, jobID uuid.UUID, failedJobsKey, destkey string) (bool, error) {
+	for _, failedItem := range queues {
+		var job job.Job
+		if err := sonic.Unmarshal([]byte(failedItem), &job); err != nil {
+			return false, err
+		}
+
+		if job.ID == jobID {
+			if err := resetAndMoveJob(ctx, rdbClient, job, failedJobsKey, destkey, failedItem); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func resetAndMoveJob(ctx context.Context, rdbClient redis.Cmdable, job job.Job, failedJobsKey, destkey, failedItem string) error {
+	job.Attempts = 0
+	updatedItem, err := sonic.Marshal(&job)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rdbClient.LRem(ctx, failedJobsKey, 1, failedItem).Result(); err != nil {
+		return err
+	}
+
+	err = rdbClient.RPush(ctx, destkey, updatedItem).Err()
+	if err != nil {
+		_ = rdbClient.LPush(ctx, failedJobsKey, failedItem).Err()
+		return err
+	}
+
+	return nil
+}
+
+func (d *redisDriver) RetryAllFailed(ctx context.Context, queue string) (int, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+	count := 0
+
+	for {
+		// Remove the failed item from the failed list.
+		failedItem, err := d.client.LPop(ctx, failedJobsKey).Result()
+		if err == redis.Nil {
+			// No more items left in the failed list, break the loop.
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		// Reset the attempts counter
+		var j job.Job
+		if err := sonic.Unmarshal([]byte(failedItem), &j); err != nil {
+			return count, err
+		}
+		j.Attempts = 0
+		updatedItem, err := sonic.Marshal(&j)
+		if err != nil {
+			return count, err
+		}
+
+		// Add the failed item back to the source list with the reset attempts counter.
+		err = d.client.RPush(ctx, d.key(queue), updatedItem).Err()
+		if err != nil {
+			// Add the failed item back to the failed list in case of an RPush error.
+			_ = d.client.LPush(ctx, failedJobsKey, failedItem).Err()
+			return count, err
+		}
+
+		if err := markRetried(ctx, d.repo, j.ID); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func (d *redisDriver) RemoveFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	return d.removeFromList(ctx, d.key(queue)+"_failed", jobID)
+}
+
+func (d *redisDriver) Remove(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	return d.removeFromList(ctx, d.key(queue), jobID)
+}
+
+// removeFromList removes the job with the matching job ID from a list.
+func (d *redisDriver) removeFromList(ctx context.Context, key string, jobID uuid.UUID) (bool, error) {
+	items, err := d.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		var j job.Job
+		if err := sonic.Unmarshal([]byte(item), &j); err != nil {
+			return false, err
+		}
+
+		if j.ID == jobID {
+			removed, err := d.client.LRem(ctx, key, 1, item).Result()
+			return removed > 0, err
+		}
+	}
+
+	return false, nil
+}
+
+func (d *redisDriver) FlushFailed(ctx context.Context, queue string) (int64, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+
+	// Get the length of the failed list before deleting the key.
+	length, err := d.client.LLen(ctx, failedJobsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, failedJobsKey, err)
+	}
+
+	if err := d.client.Del(ctx, failedJobsKey).Err(); err != nil {
+		return 0, fmt.Errorf(ERROR_DELETING_KEY, failedJobsKey, err)
+	}
+
+	return length, nil
+}
+
+func (d *redisDriver) Clear(ctx context.Context, queue string) (int64, error) {
+	sourceKey := d.key(queue)
+
+	// Get the length of the queue before deleting the key.
+	length, err := d.client.LLen(ctx, sourceKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, sourceKey, err)
+	}
+
+	delayed, err := d.client.ZCard(ctx, sourceKey+"_delayed").Result()
+	if err != nil {
+		return 0, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, sourceKey+"_delayed", err)
+	}
+
+	// Remove all items from the source list and the delayed set.
+	if err := d.client.Del(ctx, sourceKey, sourceKey+"_delayed").Err(); err != nil {
+		return 0, err
+	}
+
+	return length + delayed, nil
+}
+
+func (d *redisDriver) Peek(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	// Jobs are dequeued from the right, so the next ones sit at the end of the list
+	rawItems, err := d.client.LRange(ctx, d.key(queue), -count, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := decodeJobs(rawItems)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	}
+
+	return jobs, nil
+}
+
+func (d *redisDriver) PeekDelayed(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	rawItems, err := d.client.ZRange(ctx, d.key(queue)+"_delayed", 0, count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJobs(rawItems)
+}
+
+func (d *redisDriver) PeekFailed(ctx context.Context, queue string, offset int64, count int64) ([]*job.Job, error) {
+	// Failed jobs are pushed to the left, so the most recent ones come first
+	rawItems, err := d.client.LRange(ctx, d.key(queue)+"_failed", offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJobs(rawItems)
+}
+
+func (d *redisDriver) Stats(ctx context.Context, queue string) (Stats, error) {
+	sourceKey := d.key(queue)
+
+	pipe := d.client.Pipeline()
+	ready := pipe.LLen(ctx, sourceKey)
+	reserved := pipe.HLen(ctx, sourceKey+"_reserved")
+	delayed := pipe.ZCard(ctx, sourceKey+"_delayed")
+	failed := pipe.LLen(ctx, sourceKey+"_failed")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Stats{}, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, sourceKey, err)
+	}
+
+	return Stats{
+		Ready:    ready.Val(),
+		Reserved: reserved.Val(),
+		Delayed:  delayed.Val(),
+		Failed:   failed.Val(),
+	}, nil
+}
+
+func (d *redisDriver) Queues(ctx context.Context) ([]string, error) {
+	return scanQueues(ctx, d.client)
+}
+
+// scanQueues lists the queues that have at least one key in Redis.
+func scanQueues(ctx context.Context, client redis.Cmdable) ([]string, error) {
+	prefix := rdb.AddQueuePrefix("")
+	seen := make(map[string]bool)
+	var queues []string
+	var cursor uint64
+
+	for {
+		batch, next, err := client.Scan(ctx, cursor, prefix+"*", 50).Result()
+		if err != nil {
+			return nil, fmt.Errorf(ERROR_SCANNING_REDIS_KEY, err)
+		}
+
+		for _, key := range batch {
+			name := strings.TrimPrefix(sourceKey(key), prefix)
+			if !seen[name] {
+				seen[name] = true
+				queues = append(queues, name)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return queues, nil
+}
+
+// sourceKey maps any key of a queue ("_reserved", "_failed", "_delayed", "_lease" and the keys of the
+// stream driver) to the key of its source list.
+func sourceKey(key string) string {
+	for _, suffix := range []string{"_attempt", "_reserved", "_failed", "_delayed", "_lease", "_stream", "_priority", "_jobs", "_entries"} {
+		if strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix)
+		}
+	}
+	return key
+}
+
+// Add the job to the source list. Jobs with a priority are added to the start of the queue,
+// so they are dequeued before the jobs already waiting.
+func pushReady(ctx context.Context, rdbClient redis.Cmdable, sourceKey string, priority int, jobBytes []byte) error {
+	if priority > 0 {
+		return rdbClient.RPush(ctx, sourceKey, jobBytes).Err()
+	}
+	return rdbClient.LPush(ctx, sourceKey, jobBytes).Err()
+}
+
+// Add the job to the delayed set, scored by the time (in milliseconds) it becomes due
+func addJobToDelayedSet(ctx context.Context, rdbClient redis.Cmdable, delayedKey string, jobBytes []byte, runAt time.Time) error {
+	return rdbClient.ZAdd(ctx, delayedKey, redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: jobBytes,
+	}).Err()
+}
+
+// decodeJobs unmarshals raw queue items.
+func decodeJobs(rawItems []string) ([]*job.Job, error) {
+	jobs := make([]*job.Job, 0, len(rawItems))
+	for _, rawItem := range rawItems {
+		var j job.Job
+		if err := sonic.Unmarshal([]byte(rawItem), &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &j)
+	}
+
+	return jobs, nil
+}
+
+// recordPushed adds the jobs pushed to a redis based driver to postgres for backup, in one batch.
+func recordPushed(ctx context.Context, repo repository.JobRepository, queue string, jobs ...*job.Job) error {
+	records := make([]model.Job, 0, len(jobs))
+	for _, j := range jobs {
+		backoff, chain, err := marshalJobColumns(j)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, model.Job{
+			ID:          j.ID,
+			Queue:       queue,
+			HandlerName: j.HandlerName,
+			Payload:     j.Payload,
+			MaxAttempts: j.MaxAttempts,
+			Delay:       j.Delay,
+			Timeout:     j.Timeout,
+			Backoff:     backoff,
+			Priority:    j.Priority,
+			UniqueKey:   j.UniqueKey,
+			UniqueUntil: j.UniqueUntil,
+			UniqueFor:   j.UniqueFor,
+			Chain:       chain,
+			Input:       j.Input,
+			BatchID:     j.BatchID,
+			Status:      job.StatusPending,
+			CreatedAt:   j.CreatedAt,
+		})
+	}
+
+	if err := repo.AddJobs(ctx, records...); err != nil {
+		logger.Log.Error("Error adding job to postgres", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// marshalJobColumns encodes the backoff policy and the chain of a job for their JSONB columns, nil if they are not set.
+func marshalJobColumns(j *job.Job) (backoff []byte, chain []byte, err error) {
+	if j.Backoff != nil {
+		if backoff, err = sonic.Marshal(j.Backoff); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(j.Chain) > 0 {
+		if chain, err = sonic.Marshal(j.Chain); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return backoff, chain, nil
+}
+
+// recordFailed records in postgres that a job failed for good.
+func recordFailed(ctx context.Context, repo repository.JobRepository, queue string, j *job.Job, reason string) error {
+	// update job status in postgres
+	if err := repo.UpdateJobStatus(ctx, j.ID, job.StatusFailed); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return err
+	}
+
+	// Add failed job to postgres
+	_, err := repo.AddFailedJob(ctx, model.FaildJob{
+		JobID:    j.ID,
+		Queue:    queue,
+		Payload:  j.Payload,
+		Error:    strings.Join(j.Errors, ","),
+		Reason:   reason,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		logger.Log.Error("Error adding failed job to postgres", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// markRetried records in postgres that a failed job is pending again.
+func markRetried(ctx context.Context, repo repository.JobRepository, jobID uuid.UUID) error {
+	// update job status in postgres
+	if err := repo.UpdateJobStatus(ctx, jobID, job.StatusPending); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return err
+	}
+
+	// remove job from failed_jobs in postgres
+	if err := repo.RemoveFailedJob(ctx, jobID); err != nil {
+		logger.Log.Error("Error removing job from failed_jobs in postgres", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nopJobRepository stands in for postgres, the redis driver only mirrors job statuses to it.
+type nopJobRepository struct {
+	repository.JobRepository
+}
+
+func (nopJobRepository) AddJob(ctx context.Context, j model.Job) (uuid.UUID, error) {
+	return j.ID, nil
+}
+
+func (nopJobRepository) AddJobs(ctx context.Context, jobs ...model.Job) error {
+	return nil
+}
+
+func (nopJobRepository) UpdateJobStatus(ctx context.Context, jobID uuid.UUID, status string) error {
+	return nil
+}
+
+func (nopJobRepository) AddFailedJob(ctx context.Context, j model.FaildJob) (int, error) {
+	return 0, nil
+}
+
+func (nopJobRepository) RemoveFailedJob(ctx context.Context, jobID uuid.UUID) error {
+	return nil
+}
+
+func newTestRedisQueue(t *testing.T, name string) *Queue {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), PoolSize: 32})
+	t.Cleanup(func() { client.Close() })
+
+	return NewQueueWithDriver(name, NewRedisDriver(client, nopJobRepository{}))
+}
+
+func enqueueTestJobs(t *testing.T, q *Queue, count int, maxAttempts int) map[uuid.UUID]bool {
+	jobIDs := make(map[uuid.UUID]bool, count)
+	jobs := make([]*job.Job, 0, count)
+	for i := 0; i < count; i++ {
+		j, err := job.NewJob("ProcessExample", &job.ProcessExample{Data: "Sawadeee Kaab!"}, maxAttempts, 0)
+		require.NoError(t, err)
+		jobs = append(jobs, j)
+		jobIDs[j.ID] = true
+	}
+	require.NoError(t, q.Enqueue(context.Background(), jobs...))
+
+	return jobIDs
+}
+
+func Test_redisDriver_concurrentWorkers(t *testing.T) {
+	ctx := context.Background()
+	q := newTestRedisQueue(t, "testing_concurrency")
+	jobIDs := enqueueTestJobs(t, q, 200, 0)
+
+	var processed sync.Map
+	var duplicates, failures atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, err := q.Dequeue(ctx, 100*time.Millisecond)
+				if !assert.NoError(t, err) || j == nil {
+					return
+				}
+
+				// Every job fails its first attempt, so it is released and reserved again
+				if j.Attempts == 1 {
+					failures.Add(1)
+					assert.NoError(t, q.RemoveProcessed(ctx, j.ID, errors.New("first attempt fails")))
+					continue
+				}
+
+				if _, loaded := processed.LoadOrStore(j.ID, true); loaded {
+					duplicates.Add(1)
+				}
+				assert.NoError(t, q.RemoveProcessed(ctx, j.ID, nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Zero(t, duplicates.Load(), "no job should be completed twice")
+	assert.Equal(t, int64(len(jobIDs)), failures.Load(), "every job should fail its first attempt exactly once")
+	for jobID := range jobIDs {
+		_, ok := processed.Load(jobID)
+		assert.True(t, ok, "job %s was lost", jobID)
+	}
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats, "the queue should be drained")
+}
+
+func Test_redisDriver_concurrentReapers(t *testing.T) {
+	ctx := context.Background()
+	q := newTestRedisQueue(t, "testing_reapers")
+	q.VisibilityTimeout = 50 * time.Millisecond
+	jobIDs := enqueueTestJobs(t, q, 100, 0)
+
+	// Reserve every job and let the leases expire, as if the workers died
+	var reserved []*job.Job
+	for range jobIDs {
+		j, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, j)
+		reserved = append(reserved, j)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	// Late acknowledgements race with several reapers
+	var reaped, redelivered atomic.Int64
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				count, err := q.ReapExpired(ctx)
+				if !assert.NoError(t, err) || count == 0 {
+					return
+				}
+				reaped.Add(int64(count))
+			}
+		}()
+	}
+	for i := 0; i < len(reserved); i += 2 {
+		wg.Add(1)
+		go func(j *job.Job) {
+			defer wg.Done()
+			assert.NoError(t, q.RemoveProcessed(ctx, j.ID, nil))
+		}(reserved[i])
+	}
+	wg.Wait()
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Reserved, "no job should stay reserved")
+
+	// A job is either completed by its late ack or back in the queue, never both nor none
+	for {
+		j, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		if j == nil {
+			break
+		}
+		redelivered.Add(1)
+		assert.Equal(t, 2, j.Attempts, "the lost attempt should be counted")
+		require.NoError(t, q.RemoveProcessed(ctx, j.ID, nil))
+	}
+	assert.LessOrEqual(t, reaped.Load(), int64(len(jobIDs)))
+	assert.GreaterOrEqual(t, redelivered.Load(), int64(len(jobIDs)/2), "the jobs that were not acknowledged should be redelivered")
+	assert.LessOrEqual(t, redelivered.Load(), reaped.Load(), "only reaped jobs should be redelivered")
+}
+
+func Test_redisDriver_abandonedClaim(t *testing.T) {
+	ctx := context.Background()
+	q := newTestRedisQueue(t, "testing_abandoned_claim")
+	q.VisibilityTimeout = 50 * time.Millisecond
+	enqueueTestJobs(t, q, 1, 0)
+
+	reserved, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reserved)
+	time.Sleep(60 * time.Millisecond)
+
+	// A reaper claims the job and crashes before settling it
+	claimed, err := q.driver.Expired(ctx, q.KeyWithoutPrefix, q.VisibilityTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{reserved.ID}, claimed)
+
+	claimed, err = q.driver.Expired(ctx, q.KeyWithoutPrefix, q.VisibilityTimeout)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "a claimed job is not reaped twice at once")
+
+	time.Sleep(60 * time.Millisecond)
+	reaped, err := q.ReapExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reaped, "the abandoned claim expires and the job is reaped again")
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Ready: 1}, stats)
+}
+
+func Test_redisDriver_promote(t *testing.T) {
+	ctx := context.Background()
+	q := newTestRedisQueue(t, "testing_promote")
+
+	j, _ := job.NewJob("ProcessExample", map[string]any{"big": int64(1) << 60, "list": []int{}}, 3, 0)
+	j.Priority = 1
+	require.NoError(t, q.Enqueue(ctx, j))
+
+	reserved, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.RemoveProcessed(ctx, reserved.ID, job.RetryAfter(10*time.Millisecond, errors.New("later"))))
+
+	time.Sleep(20 * time.Millisecond)
+	promoted, err := q.PromoteDelayed(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+
+	redelivered, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, redelivered)
+	assert.JSONEq(t, string(j.Payload), string(redelivered.Payload), "the scripts should not alter the payload")
+	assert.Equal(t, 2, redelivered.Attempts)
+}
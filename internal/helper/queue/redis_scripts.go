@@ -0,0 +1,107 @@
+package queue
+
+import "github.com/redis/go-redis/v9"
+
+// The hot paths of the redis driver run as Lua scripts, so that moving a job between its source list,
+// the "_reserved" hash and the "_lease" set is atomic. The scripts only decode jobs to read their id
+// and priority, they never re-encode them: a Lua round trip would alter the payload (big numbers,
+// empty arrays). A reserved job is stored as it was before the attempt and Reserved counts the attempt.
+
+// reserveScript pops the job at the start of the queue, stores it in the reserved hash and leases it.
+//
+//	KEYS: source list, reserved hash, lease set
+//	ARGV: lease deadline in milliseconds
+var reserveScript = redis.NewScript(`
+local item = redis.call('RPOP', KEYS[1])
+if not item then
+	return false
+end
+
+local id = cjson.decode(item).id
+redis.call('HSET', KEYS[2], id, item)
+redis.call('ZADD', KEYS[3], ARGV[1], id)
+return item
+`)
+
+// settleScript takes a job out of the reserved hash and releases its lease, then moves the given item
+// to the destination: "ready", "priority" (the start of the queue), "delayed", "failed" or "none".
+// It returns 0 if the job was not reserved anymore, e.g. because it was acknowledged or reaped meanwhile.
+//
+//	KEYS: source list, delayed set, failed list, reserved hash, lease set
+//	ARGV: job id, destination, item, due time in milliseconds for "delayed"
+var settleScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[4], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[5], ARGV[1])
+
+local destination = ARGV[2]
+if destination == 'ready' then
+	redis.call('LPUSH', KEYS[1], ARGV[3])
+elseif destination == 'priority' then
+	redis.call('RPUSH', KEYS[1], ARGV[3])
+elseif destination == 'delayed' then
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+elseif destination == 'failed' then
+	redis.call('LPUSH', KEYS[3], ARGV[3])
+end
+return 1
+`)
+
+// reapScript claims up to ARGV[2] jobs whose lease expired before ARGV[1] and returns their ids.
+// A claim is a new lease until ARGV[3], so a job whose reaper crashes before settling it is reaped again.
+// The jobs stay in the reserved hash until they are settled.
+//
+//	KEYS: lease set
+//	ARGV: now in milliseconds, limit, claim deadline in milliseconds
+var reapScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, id in ipairs(expired) do
+	redis.call('ZADD', KEYS[1], ARGV[3], id)
+end
+return expired
+`)
+
+// promoteScript moves up to ARGV[2] delayed jobs due before ARGV[1] to the source list and returns how many were moved.
+//
+//	KEYS: delayed set, source list
+//	ARGV: now in milliseconds, limit
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, item in ipairs(due) do
+	redis.call('ZREM', KEYS[1], item)
+	local priority = cjson.decode(item).priority
+	if priority and priority > 0 then
+		redis.call('RPUSH', KEYS[2], item)
+	else
+		redis.call('LPUSH', KEYS[2], item)
+	end
+end
+return #due
+`)
+
+// acquireLockScript locks a unique job unless another job holds the lock, and returns the ID of the job holding it.
+//
+//	KEYS: lock key
+//	ARGV: job id, ttl in milliseconds
+var acquireLockScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return ARGV[1]
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// pruneWorkersScript removes the given workers from the worker index unless their key exists,
+// so that a worker whose heartbeat came back after it was found expired stays listed.
+//
+//	KEYS: worker index set, worker keys in the order of their ids
+//	ARGV: worker ids
+var pruneWorkersScript = redis.NewScript(`
+local removed = 0
+for i, id in ipairs(ARGV) do
+	if redis.call('EXISTS', KEYS[i + 1]) == 0 then
+		removed = removed + redis.call('SREM', KEYS[1], id)
+	end
+end
+return removed
+`)
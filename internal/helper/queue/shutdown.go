@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// WorkerOptions tells RunWorker how to consume the queues and when to stop,
+// the zero value runs a strict worker until its context is canceled.
+type WorkerOptions struct {
+	Mode string // ModeStrict or ModeWeighted, ModeStrict if empty
+
+	// GracePeriod is how long the job in flight may keep running once the worker is asked to stop,
+	// its context is canceled afterwards and it is released back to the queue.
+	GracePeriod time.Duration
+
+	MaxJobs       int           // stop after processing this many jobs, 0 for no limit
+	MaxTime       time.Duration // stop after running this long, 0 for no limit
+	MemoryLimit   uint64        // stop once the heap of the process exceeds this many bytes, 0 for no limit
+	StopWhenEmpty bool          // stop as soon as the queues have no job ready
+}
+
+// memoryExceeded reports whether the heap of the process exceeds the memory limit.
+func (o WorkerOptions) memoryExceeded() bool {
+	if o.MemoryLimit == 0 {
+		return false
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc > o.MemoryLimit
+}
+
+// detachedContext carries the values of its parent but is never canceled with it.
+type detachedContext struct {
+	parent context.Context
+}
+
+// withoutCancel returns a context that keeps the values of ctx and outlives it, so a job taken
+// before a shutdown can still be acknowledged after the worker context is canceled.
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}       { return nil }
+func (c detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any           { return c.parent.Value(key) }
+
+// withGracePeriod returns a context that outlives ctx by gracePeriod, it is canceled once
+// gracePeriod has passed since ctx was done, or when the returned cancel function is called.
+func withGracePeriod(ctx context.Context, gracePeriod time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(withoutCancel(ctx))
+
+	go func() {
+		select {
+		case <-graceCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(gracePeriod)
+		defer timer.Stop()
+
+		select {
+		case <-graceCtx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+
+	return graceCtx, cancel
+}
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shutdownTestPayload struct {
+	Duration time.Duration `json:"duration"` // how long the handler runs, until its context is canceled if 0
+}
+
+// shutdownTestStarted receives a value whenever a ShutdownTest job starts
+var shutdownTestStarted = make(chan struct{}, 10)
+
+func init() {
+	job.Register("ShutdownTest", func(ctx context.Context, payload shutdownTestPayload) error {
+		shutdownTestStarted <- struct{}{}
+		if payload.Duration == 0 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		time.Sleep(payload.Duration)
+		return nil
+	})
+}
+
+func Test_shutdown(t *testing.T) {
+	ctx := context.Background()
+
+	enqueue := func(t *testing.T, q *Queue, duration time.Duration) *job.Job {
+		j, err := job.NewJob("ShutdownTest", shutdownTestPayload{Duration: duration}, 3, 0)
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(ctx, j))
+		return j
+	}
+
+	// runUntilStarted runs a worker until its first job starts, then cancels it and returns its error.
+	runUntilStarted := func(t *testing.T, q *Queue, opts WorkerOptions) error {
+		workerCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- RunWorker(workerCtx, opts, q)
+		}()
+
+		<-shutdownTestStarted
+		cancel()
+
+		select {
+		case err := <-stopped:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("worker did not stop")
+			return nil
+		}
+	}
+
+	t.Run("the job in flight is finished within the grace period", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		enqueue(t, q, 200*time.Millisecond)
+
+		err := runUntilStarted(t, q, WorkerOptions{GracePeriod: time.Second})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, stats.Ready, "the job should be completed")
+		assert.Zero(t, stats.Reserved, "the job should be acknowledged despite the canceled context")
+		assert.Zero(t, stats.Failed)
+	})
+
+	t.Run("the job in flight is released once the grace period is over", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		j := enqueue(t, q, 0)
+
+		err := runUntilStarted(t, q, WorkerOptions{GracePeriod: 50 * time.Millisecond})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		jobs, err := q.Peek(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1, "the job should be back in the queue")
+		assert.Equal(t, j.ID, jobs[0].ID)
+		assert.Zero(t, jobs[0].Attempts, "a stopped job does not use up an attempt")
+	})
+
+	t.Run("the worker stops after max jobs", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		for i := 0; i < 3; i++ {
+			enqueue(t, q, time.Millisecond)
+		}
+
+		require.NoError(t, RunWorker(ctx, WorkerOptions{MaxJobs: 2}, q))
+
+		length, err := q.Length(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), length)
+	})
+
+	t.Run("the worker stops after max time", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+
+		startedAt := time.Now()
+		require.NoError(t, RunWorker(ctx, WorkerOptions{MaxTime: 100 * time.Millisecond}, q))
+		assert.Less(t, time.Since(startedAt), time.Second)
+	})
+
+	t.Run("the worker stops when the queues are empty", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		first := NewQueueWithDriver("first", driver)
+		second := NewQueueWithDriver("second", driver)
+		enqueue(t, first, time.Millisecond)
+		enqueue(t, second, time.Millisecond)
+
+		require.NoError(t, RunWorker(ctx, WorkerOptions{StopWhenEmpty: true}, first, second))
+
+		for _, q := range []*Queue{first, second} {
+			length, err := q.Length(ctx)
+			require.NoError(t, err)
+			assert.Zero(t, length)
+		}
+	})
+
+	t.Run("the grace context outlives its parent by the grace period", func(t *testing.T) {
+		parent, cancel := context.WithCancel(ctx)
+		graceCtx, cancelGrace := withGracePeriod(parent, 50*time.Millisecond)
+		defer cancelGrace()
+
+		cancel()
+		assert.NoError(t, graceCtx.Err())
+
+		select {
+		case <-graceCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("the grace context should be canceled after the grace period")
+		}
+	})
+}
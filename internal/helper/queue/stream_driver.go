@@ -0,0 +1,817 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// StreamGroup is the consumer group every worker of a stream queue joins.
+const StreamGroup = "workers"
+
+// StreamReaper is the consumer that claims the entries of workers whose lease has expired.
+const StreamReaper = "reaper"
+
+/*
+streamDriver keeps a queue in Redis Streams read by a consumer group, every worker being a named consumer
+(see WithConsumer). It mirrors the status of every job to the jobs table like the redis driver.
+
+  - the "_stream" stream holds one entry per delivery of a job, the "_priority" stream the ones of jobs with a priority
+  - the "_jobs" hash holds the current state of every ready, delayed and reserved job by job ID
+  - the "_entries" hash holds the stream entry of every reserved job by job ID, so acks are O(1)
+  - the "_delayed" sorted set holds the IDs of the delayed jobs, scored by the time they become due
+  - the "_failed" list holds the failed jobs
+
+The lease of a reserved job is the idle time of its pending entry: heartbeats reset it with XCLAIM,
+and the reaper takes over entries idle for longer than the lease with XAUTOCLAIM.
+Removing a ready job only deletes it from the "_jobs" hash, its entry is skipped when it is read.
+*/
+type streamDriver struct {
+	client redis.Cmdable
+	repo   repository.JobRepository
+
+	// groups remembers the streams whose consumer group was created
+	groups sync.Map
+}
+
+func NewStreamDriver(client redis.Cmdable, repo repository.JobRepository) Driver {
+	return &streamDriver{
+		client: client,
+		repo:   repo,
+	}
+}
+
+func (d *streamDriver) History() repository.JobRepository {
+	return d.repo
+}
+
+func (d *streamDriver) Locks() UniqueLocks {
+	return NewRedisLocks(d.client)
+}
+
+func (d *streamDriver) key(queue string) string {
+	return rdb.AddQueuePrefix(queue)
+}
+
+// streams returns the streams of a queue in the order they are read.
+func (d *streamDriver) streams(queue string) []string {
+	return []string{d.key(queue) + "_priority", d.key(queue) + "_stream"}
+}
+
+func (d *streamDriver) stream(queue string, priority int) string {
+	if priority > 0 {
+		return d.key(queue) + "_priority"
+	}
+	return d.key(queue) + "_stream"
+}
+
+// ensureGroup creates the consumer group of a stream, along with the stream itself.
+func (d *streamDriver) ensureGroup(ctx context.Context, stream string) error {
+	if _, ok := d.groups.Load(stream); ok {
+		return nil
+	}
+
+	err := d.client.XGroupCreateMkStream(ctx, stream, StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	d.groups.Store(stream, true)
+	return nil
+}
+
+// add stores a job and makes it ready, or delayed until the given time.
+func (d *streamDriver) add(ctx context.Context, queue string, j *job.Job, at time.Time) error {
+	return d.addTo(ctx, d.client, queue, j, at)
+}
+
+// addTo is add on the given client, which may be a pipeline.
+func (d *streamDriver) addTo(ctx context.Context, client redis.Cmdable, queue string, j *job.Job, at time.Time) error {
+	jobBytes, err := sonic.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	if err := client.HSet(ctx, d.key(queue)+"_jobs", j.ID.String(), jobBytes).Err(); err != nil {
+		return err
+	}
+
+	if at.After(time.Now()) {
+		return client.ZAdd(ctx, d.key(queue)+"_delayed", redis.Z{
+			Score:  float64(at.UnixMilli()),
+			Member: j.ID.String(),
+		}).Err()
+	}
+
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.stream(queue, j.Priority),
+		Values: map[string]interface{}{"job_id": j.ID.String()},
+	}).Err()
+}
+
+// load returns the stored state of a job, or nil if it is not stored anymore.
+func (d *streamDriver) load(ctx context.Context, queue string, jobID string) (*job.Job, error) {
+	jobBytes, err := d.client.HGet(ctx, d.key(queue)+"_jobs", jobID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var j job.Job
+	if err := sonic.Unmarshal(jobBytes, &j); err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// discard acknowledges and deletes a stream entry.
+func (d *streamDriver) discard(ctx context.Context, stream string, entryID string) error {
+	if err := d.client.XAck(ctx, stream, StreamGroup, entryID).Err(); err != nil {
+		return err
+	}
+	return d.client.XDel(ctx, stream, entryID).Err()
+}
+
+// Push adds the jobs to postgres in one batch, then to redis in one pipeline.
+func (d *streamDriver) Push(ctx context.Context, queue string, jobs ...*job.Job) error {
+	// Add jobs to postgres for backup
+	if err := recordPushed(ctx, d.repo, queue, jobs...); err != nil {
+		return err
+	}
+
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, j := range jobs {
+			if err := d.addTo(ctx, pipe, queue, j, time.Now().Add(time.Duration(j.Delay)*time.Second)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error("Error adding job to redis", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (d *streamDriver) Restore(ctx context.Context, queue string, failed bool, jobs ...*job.Job) error {
+	for _, j := range jobs {
+		var err error
+		if failed {
+			var jobBytes []byte
+			if jobBytes, err = sonic.Marshal(j); err == nil {
+				err = d.client.LPush(ctx, d.key(queue)+"_failed", jobBytes).Err()
+			}
+		} else {
+			err = d.add(ctx, queue, j, time.Now())
+		}
+
+		if err != nil {
+			logger.Log.Error("Error adding job to redis", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *streamDriver) Reserve(ctx context.Context, queue string, lease time.Duration, wait time.Duration) (*job.Job, error) {
+	consumer := ConsumerFromContext(ctx)
+	streams := d.streams(queue)
+	for _, stream := range streams {
+		if err := d.ensureGroup(ctx, stream); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		// Jobs with a priority are read first, only the normal stream is waited on
+		var stream string
+		var message *redis.XMessage
+		for i, s := range streams {
+			block := time.Duration(-1)
+			if i == len(streams)-1 && wait > 0 {
+				block = wait
+			}
+
+			result, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    StreamGroup,
+				Consumer: consumer,
+				Streams:  []string{s, ">"},
+				Count:    1,
+				Block:    block,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if len(result) > 0 && len(result[0].Messages) > 0 {
+				stream, message = s, &result[0].Messages[0]
+				break
+			}
+		}
+
+		if message == nil {
+			return nil, nil
+		}
+
+		jobID, _ := message.Values["job_id"].(string)
+		j, err := d.load(ctx, queue, jobID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The job was removed while it was waiting, drop its entry and read the next one
+		if j == nil {
+			if err := d.discard(ctx, stream, message.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Update status of job in postgres
+		if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusProcessing); err != nil {
+			logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+			return nil, err
+		}
+
+		j.Attempts++
+		jobBytes, err := sonic.Marshal(j)
+		if err != nil {
+			return nil, err
+		}
+
+		pipe := d.client.TxPipeline()
+		pipe.HSet(ctx, d.key(queue)+"_jobs", jobID, jobBytes)
+		pipe.HSet(ctx, d.key(queue)+"_entries", jobID, stream+" "+message.ID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+
+		j.LeaseExpiresAt = time.Now().Add(lease)
+		return j, nil
+	}
+}
+
+// entry returns the stream and the entry ID of a reserved job, ok is false if the job is not reserved.
+func (d *streamDriver) entry(ctx context.Context, queue string, jobID uuid.UUID) (stream string, entryID string, ok bool, err error) {
+	ref, err := d.client.HGet(ctx, d.key(queue)+"_entries", jobID.String()).Result()
+	if err == redis.Nil {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	i := strings.LastIndex(ref, " ")
+	if i < 0 {
+		return "", "", false, errors.New("invalid stream entry reference: " + ref)
+	}
+
+	return ref[:i], ref[i+1:], true, nil
+}
+
+func (d *streamDriver) Extend(ctx context.Context, queue string, jobID uuid.UUID, lease time.Duration) (time.Time, error) {
+	consumer := ConsumerFromContext(ctx)
+
+	stream, entryID, ok, err := d.entry(ctx, queue, jobID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, ErrLeaseLost
+	}
+
+	// The entry must still be pending for this worker, it is not once the reaper claimed it
+	pending, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   stream,
+		Group:    StreamGroup,
+		Start:    entryID,
+		End:      entryID,
+		Count:    1,
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(pending) == 0 {
+		return time.Time{}, ErrLeaseLost
+	}
+
+	// Claiming the entry again resets its idle time
+	err = d.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    StreamGroup,
+		Consumer: consumer,
+		Messages: []string{entryID},
+	}).Err()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Now().Add(lease), nil
+}
+
+func (d *streamDriver) Reserved(ctx context.Context, queue string, jobID uuid.UUID) (*job.Job, error) {
+	_, _, ok, err := d.entry(ctx, queue, jobID)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return d.load(ctx, queue, jobID.String())
+}
+
+func (d *streamDriver) Expired(ctx context.Context, queue string, lease time.Duration) ([]uuid.UUID, error) {
+	var jobIDs []uuid.UUID
+
+	for _, stream := range d.streams(queue) {
+		if err := d.ensureGroup(ctx, stream); err != nil {
+			return jobIDs, err
+		}
+
+		// Claiming resets the idle time of the entries, so only one reaper gets them.
+		// The whole pending list is walked, as every call claims at most Count entries
+		for start := "0-0"; ; {
+			messages, next, err := d.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   stream,
+				Group:    StreamGroup,
+				MinIdle:  lease,
+				Start:    start,
+				Count:    100,
+				Consumer: StreamReaper,
+			}).Result()
+			if err != nil {
+				return jobIDs, err
+			}
+
+			for _, message := range messages {
+				member, _ := message.Values["job_id"].(string)
+				jobID, err := uuid.Parse(member)
+				if err != nil {
+					logger.Log.Error("Invalid job id in stream entry", zap.String("entry", message.ID), zap.Error(err))
+					if err := d.discard(ctx, stream, message.ID); err != nil {
+						return jobIDs, err
+					}
+					continue
+				}
+
+				// A worker that died before recording the entry of its job leaves an orphan entry behind
+				adopted, err := d.client.HSetNX(ctx, d.key(queue)+"_entries", member, stream+" "+message.ID).Result()
+				if err != nil {
+					return jobIDs, err
+				}
+				if adopted {
+					exists, err := d.client.HExists(ctx, d.key(queue)+"_jobs", member).Result()
+					if err != nil {
+						return jobIDs, err
+					}
+					if !exists {
+						d.client.HDel(ctx, d.key(queue)+"_entries", member)
+						if err := d.discard(ctx, stream, message.ID); err != nil {
+							return jobIDs, err
+						}
+						continue
+					}
+				}
+
+				jobIDs = append(jobIDs, jobID)
+			}
+
+			if next == "0-0" {
+				break
+			}
+			start = next
+		}
+	}
+
+	return jobIDs, nil
+}
+
+// claim takes the reference to the stream entry of a reserved job, so that only one caller moves the job on.
+// ok is false if the job was not reserved anymore, e.g. because it was reaped meanwhile.
+// The entry stays pending until it is acknowledged, so a job whose claimer crashes is reaped again.
+func (d *streamDriver) claim(ctx context.Context, queue string, jobID uuid.UUID) (stream string, entryID string, ok bool, err error) {
+	stream, entryID, ok, err = d.entry(ctx, queue, jobID)
+	if err != nil || !ok {
+		return "", "", false, err
+	}
+
+	removed, err := d.client.HDel(ctx, d.key(queue)+"_entries", jobID.String()).Result()
+	if err != nil || removed == 0 {
+		return "", "", false, err
+	}
+
+	return stream, entryID, true, nil
+}
+
+// unreserve acknowledges the stream entry of a reserved job.
+// It reports false if the job was not reserved anymore, e.g. because it was reaped meanwhile.
+func (d *streamDriver) unreserve(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	stream, entryID, ok, err := d.claim(ctx, queue, jobID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return true, d.discard(ctx, stream, entryID)
+}
+
+func (d *streamDriver) Complete(ctx context.Context, queue string, j *job.Job) (bool, error) {
+	removed, err := d.unreserve(ctx, queue, j.ID)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	if err := d.client.HDel(ctx, d.key(queue)+"_jobs", j.ID.String()).Err(); err != nil {
+		return true, err
+	}
+
+	// The job was successful, update the job status to completed in postgres
+	if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusCompleted); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return true, err
+	}
+
+	return true, nil
+}
+
+func (d *streamDriver) Release(ctx context.Context, queue string, j *job.Job, at time.Time) (bool, error) {
+	stream, entryID, ok, err := d.claim(ctx, queue, j.ID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Update job status in postgres
+	if err := d.repo.UpdateJobStatus(ctx, j.ID, job.StatusPending); err != nil {
+		logger.Log.Error("Error updating job status in postgres", zap.Error(err))
+		return true, err
+	}
+
+	// The job is added back and its old entry acknowledged at once, so a crash neither loses nor duplicates it
+	pipe := d.client.TxPipeline()
+	if err := d.addTo(ctx, pipe, queue, j, at); err != nil {
+		return true, err
+	}
+	pipe.XAck(ctx, stream, StreamGroup, entryID)
+	pipe.XDel(ctx, stream, entryID)
+	_, err = pipe.Exec(ctx)
+
+	return true, err
+}
+
+func (d *streamDriver) Bury(ctx context.Context, queue string, j *job.Job, reason string) (bool, error) {
+	removed, err := d.unreserve(ctx, queue, j.ID)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	if err := recordFailed(ctx, d.repo, queue, j, reason); err != nil {
+		return true, err
+	}
+
+	jobBytes, err := sonic.Marshal(j)
+	if err != nil {
+		return true, err
+	}
+
+	pipe := d.client.TxPipeline()
+	pipe.HDel(ctx, d.key(queue)+"_jobs", j.ID.String())
+	pipe.LPush(ctx, d.key(queue)+"_failed", jobBytes)
+	_, err = pipe.Exec(ctx)
+
+	return true, err
+}
+
+func (d *streamDriver) Promote(ctx context.Context, queue string) (int, error) {
+	delayedKey := d.key(queue) + "_delayed"
+
+	dueIDs, err := d.client.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, jobID := range dueIDs {
+		// Only the promoter that manages to remove the job from the delayed set may push it
+		removed, err := d.client.ZRem(ctx, delayedKey, jobID).Result()
+		if err != nil {
+			return count, err
+		}
+		if removed == 0 {
+			continue
+		}
+
+		j, err := d.load(ctx, queue, jobID)
+		if err != nil {
+			// Put the job back so it is not lost, it will be promoted on the next tick.
+			_ = d.client.ZAdd(ctx, delayedKey, redis.Z{Score: 0, Member: jobID}).Err()
+			return count, err
+		}
+		if j == nil {
+			continue
+		}
+
+		err = d.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: d.stream(queue, j.Priority),
+			Values: map[string]interface{}{"job_id": jobID},
+		}).Err()
+		if err != nil {
+			_ = d.client.ZAdd(ctx, delayedKey, redis.Z{Score: 0, Member: jobID}).Err()
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// retry moves a failed item back to the queue with its attempts reset.
+func (d *streamDriver) retry(ctx context.Context, queue string, failedItem string) error {
+	var j job.Job
+	if err := sonic.Unmarshal([]byte(failedItem), &j); err != nil {
+		return err
+	}
+
+	j.Attempts = 0
+	if err := d.add(ctx, queue, &j, time.Now()); err != nil {
+		// Add the failed item back to the failed list in case of an error.
+		_ = d.client.LPush(ctx, d.key(queue)+"_failed", failedItem).Err()
+		return err
+	}
+
+	return markRetried(ctx, d.repo, j.ID)
+}
+
+func (d *streamDriver) RetryFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+
+	items, err := d.client.LRange(ctx, failedJobsKey, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		var j job.Job
+		if err := sonic.Unmarshal([]byte(item), &j); err != nil {
+			return false, err
+		}
+
+		if j.ID != jobID {
+			continue
+		}
+
+		removed, err := d.client.LRem(ctx, failedJobsKey, 1, item).Result()
+		if err != nil || removed == 0 {
+			return false, err
+		}
+
+		return true, d.retry(ctx, queue, item)
+	}
+
+	return false, nil
+}
+
+func (d *streamDriver) RetryAllFailed(ctx context.Context, queue string) (int, error) {
+	count := 0
+
+	for {
+		failedItem, err := d.client.RPop(ctx, d.key(queue)+"_failed").Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if err := d.retry(ctx, queue, failedItem); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func (d *streamDriver) RemoveFailed(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+
+	items, err := d.client.LRange(ctx, failedJobsKey, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		var j job.Job
+		if err := sonic.Unmarshal([]byte(item), &j); err != nil {
+			return false, err
+		}
+
+		if j.ID == jobID {
+			removed, err := d.client.LRem(ctx, failedJobsKey, 1, item).Result()
+			return removed > 0, err
+		}
+	}
+
+	return false, nil
+}
+
+func (d *streamDriver) FlushFailed(ctx context.Context, queue string) (int64, error) {
+	failedJobsKey := d.key(queue) + "_failed"
+
+	length, err := d.client.LLen(ctx, failedJobsKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return length, d.client.Del(ctx, failedJobsKey).Err()
+}
+
+// readyIDs returns the IDs of the jobs that are neither reserved nor delayed.
+func (d *streamDriver) readyIDs(ctx context.Context, queue string) ([]string, error) {
+	jobIDs, err := d.client.HKeys(ctx, d.key(queue)+"_jobs").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := d.client.HKeys(ctx, d.key(queue)+"_entries").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	delayed, err := d.client.ZRange(ctx, d.key(queue)+"_delayed", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]bool, len(reserved)+len(delayed))
+	for _, jobID := range append(reserved, delayed...) {
+		excluded[jobID] = true
+	}
+
+	var ready []string
+	for _, jobID := range jobIDs {
+		if !excluded[jobID] {
+			ready = append(ready, jobID)
+		}
+	}
+
+	return ready, nil
+}
+
+func (d *streamDriver) Remove(ctx context.Context, queue string, jobID uuid.UUID) (bool, error) {
+	ready, err := d.readyIDs(ctx, queue)
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range ready {
+		if id == jobID.String() {
+			removed, err := d.client.HDel(ctx, d.key(queue)+"_jobs", id).Result()
+			return removed > 0, err
+		}
+	}
+
+	return false, nil
+}
+
+func (d *streamDriver) Clear(ctx context.Context, queue string) (int64, error) {
+	ready, err := d.readyIDs(ctx, queue)
+	if err != nil {
+		return 0, err
+	}
+
+	delayed, err := d.client.ZRange(ctx, d.key(queue)+"_delayed", 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	jobIDs := append(ready, delayed...)
+	if len(jobIDs) == 0 {
+		return 0, nil
+	}
+
+	pipe := d.client.TxPipeline()
+	pipe.HDel(ctx, d.key(queue)+"_jobs", jobIDs...)
+	pipe.Del(ctx, d.key(queue)+"_delayed")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return int64(len(jobIDs)), nil
+}
+
+func (d *streamDriver) Peek(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	var jobs []*job.Job
+
+	for _, stream := range d.streams(queue) {
+		// The entries after the last delivered one have not been read by any worker yet
+		start := "-"
+		groups, err := d.client.XInfoGroups(ctx, stream).Result()
+		if err != nil && !strings.HasPrefix(err.Error(), "ERR no such key") {
+			return nil, err
+		}
+		for _, group := range groups {
+			if group.Name == StreamGroup {
+				start = "(" + group.LastDeliveredID
+			}
+		}
+
+		messages, err := d.client.XRangeN(ctx, stream, start, "+", count).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			if int64(len(jobs)) >= count {
+				return jobs, nil
+			}
+
+			jobID, _ := message.Values["job_id"].(string)
+			j, err := d.load(ctx, queue, jobID)
+			if err != nil {
+				return nil, err
+			}
+			if j != nil {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+
+	return jobs, nil
+}
+
+func (d *streamDriver) PeekDelayed(ctx context.Context, queue string, count int64) ([]*job.Job, error) {
+	jobIDs, err := d.client.ZRange(ctx, d.key(queue)+"_delayed", 0, count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*job.Job
+	for _, jobID := range jobIDs {
+		j, err := d.load(ctx, queue, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if j != nil {
+			jobs = append(jobs, j)
+		}
+	}
+
+	return jobs, nil
+}
+
+func (d *streamDriver) PeekFailed(ctx context.Context, queue string, offset int64, count int64) ([]*job.Job, error) {
+	// Failed jobs are pushed to the left, so the most recent ones come first
+	rawItems, err := d.client.LRange(ctx, d.key(queue)+"_failed", offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJobs(rawItems)
+}
+
+func (d *streamDriver) Stats(ctx context.Context, queue string) (Stats, error) {
+	sourceKey := d.key(queue)
+
+	pipe := d.client.Pipeline()
+	stored := pipe.HLen(ctx, sourceKey+"_jobs")
+	reserved := pipe.HLen(ctx, sourceKey+"_entries")
+	delayed := pipe.ZCard(ctx, sourceKey+"_delayed")
+	failed := pipe.LLen(ctx, sourceKey+"_failed")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		Ready:    stored.Val() - reserved.Val() - delayed.Val(),
+		Reserved: reserved.Val(),
+		Delayed:  delayed.Val(),
+		Failed:   failed.Val(),
+	}, nil
+}
+
+func (d *streamDriver) Queues(ctx context.Context) ([]string, error) {
+	return scanQueues(ctx, d.client)
+}
@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStreamQueue(t *testing.T, name string) (*Queue, redis.Cmdable) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewQueueWithDriver(name, NewStreamDriver(client, nopJobRepository{})), client
+}
+
+// pendingCount returns the number of entries of the normal stream of the queue that are not acknowledged.
+func pendingCount(t *testing.T, q *Queue, client redis.Cmdable) int64 {
+	pending, err := client.XPending(context.Background(), q.driver.(*streamDriver).stream(q.KeyWithoutPrefix, 0), StreamGroup).Result()
+	require.NoError(t, err)
+	return pending.Count
+}
+
+func Test_streamDriver_release(t *testing.T) {
+	ctx := context.Background()
+	q, client := newTestStreamQueue(t, "testing_stream_release")
+
+	enqueueTestJobs(t, q, 1, 3)
+	reserved, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reserved)
+
+	require.NoError(t, q.RemoveProcessed(ctx, reserved.ID, job.Release(0)))
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Ready: 1}, stats)
+
+	assert.Zero(t, pendingCount(t, q, client), "the entry of the released attempt is acknowledged")
+
+	redelivered, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, redelivered)
+	assert.Equal(t, reserved.ID, redelivered.ID)
+}
+
+func Test_streamDriver_expired(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("every expired job is reaped once and redelivered", func(t *testing.T) {
+		q, client := newTestStreamQueue(t, "testing_stream_expired")
+		q.VisibilityTimeout = 50 * time.Millisecond
+
+		// More expired jobs than a single XAUTOCLAIM call claims
+		jobIDs := enqueueTestJobs(t, q, 150, 3)
+		for range jobIDs {
+			reserved, err := q.TryDequeue(ctx)
+			require.NoError(t, err)
+			require.NotNil(t, reserved)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		reaped, err := q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Greater(t, reaped, 100, "the reaper follows the cursor past the first claim")
+
+		// miniredis resumes after the cursor rather than at it, the entry at the cursor is left to the next reap
+		rest, err := q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 150, reaped+rest)
+
+		reaped, err = q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, reaped, "a reaped job is not reaped twice")
+
+		stats, err := q.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Stats{Ready: 150}, stats)
+		assert.Zero(t, pendingCount(t, q, client))
+
+		redelivered, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, redelivered)
+		assert.True(t, jobIDs[redelivered.ID])
+		assert.Equal(t, 2, redelivered.Attempts)
+	})
+
+	t.Run("a heartbeat keeps the job from being reaped", func(t *testing.T) {
+		q, _ := newTestStreamQueue(t, "testing_stream_extend")
+		q.VisibilityTimeout = 100 * time.Millisecond
+		enqueueTestJobs(t, q, 1, 3)
+
+		reserved, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, reserved)
+
+		time.Sleep(60 * time.Millisecond)
+		_, err = q.ExtendLease(ctx, reserved.ID)
+		require.NoError(t, err)
+		time.Sleep(60 * time.Millisecond)
+
+		reaped, err := q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, reaped, "the lease was renewed by the heartbeat")
+
+		time.Sleep(150 * time.Millisecond)
+		reaped, err = q.ReapExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reaped)
+
+		_, err = q.ExtendLease(ctx, reserved.ID)
+		assert.ErrorIs(t, err, ErrLeaseLost, "the lease of a reaped job cannot be renewed")
+	})
+}
+
+func Test_streamDriver_bury(t *testing.T) {
+	ctx := context.Background()
+	q, client := newTestStreamQueue(t, "testing_stream_bury")
+	enqueueTestJobs(t, q, 1, 1)
+
+	reserved, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reserved)
+
+	require.NoError(t, q.RemoveProcessed(ctx, reserved.ID, errors.New("boom")))
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Failed: 1}, stats)
+	assert.Zero(t, pendingCount(t, q, client), "the entry of the buried job is acknowledged")
+
+	failed, err := q.driver.PeekFailed(ctx, q.KeyWithoutPrefix, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, reserved.ID, failed[0].ID)
+	assert.Equal(t, []string{"boom"}, failed[0].Errors)
+
+	require.NoError(t, q.RetryFailedByJobID(ctx, reserved.ID))
+	retried, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, retried)
+	assert.Equal(t, reserved.ID, retried.ID)
+	assert.Equal(t, 1, retried.Attempts, "a retried job starts over")
+}
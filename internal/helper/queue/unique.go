@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// UniqueLocks holds the locks of unique jobs, see job.Job.Unique.
+type UniqueLocks interface {
+	// Acquire locks the key for the job unless it is already locked, and returns the ID of the job holding the lock.
+	Acquire(ctx context.Context, key string, jobID uuid.UUID, ttl time.Duration) (uuid.UUID, error)
+	// Release unlocks the key if it is held by the job.
+	Release(ctx context.Context, key string, jobID uuid.UUID) error
+}
+
+// lockProvider is implemented by the drivers that hold the locks of unique jobs themselves.
+type lockProvider interface {
+	Locks() UniqueLocks
+}
+
+// locks returns the unique locks of the queue driver, redis ones if the driver has none.
+func (q *Queue) locks() UniqueLocks {
+	if provider, ok := q.driver.(lockProvider); ok {
+		return provider.Locks()
+	}
+	return NewRedisLocks(rdb.GetRedisClient())
+}
+
+// uniqueLockKey returns the key of the lock of a unique job, locks are scoped to their queue.
+// A job without unique key locks on its payload in clear, as an encrypted payload differs on every enqueue.
+func (q *Queue) uniqueLockKey(j *job.Job) string {
+	if j.UniqueKey == "" {
+		payload, err := decryptPayload(j)
+		if err != nil {
+			logger.Log.Error("Error decrypting unique job payload", zap.String("job_id", j.ID.String()), zap.Error(err))
+		} else {
+			decrypted := *j
+			decrypted.Payload = payload
+			j = &decrypted
+		}
+	}
+
+	return "unique_job:" + q.KeyWithoutPrefix + ":" + j.UniqueLockKey()
+}
+
+// acquireUnique locks a unique job, it reports false if another job holds the lock
+// and sets the ID of the job to the ID of that job.
+func (q *Queue) acquireUnique(ctx context.Context, j *job.Job) (bool, error) {
+	holder, err := q.locks().Acquire(ctx, q.uniqueLockKey(j), j.ID, j.UniqueLockTTL())
+	if err != nil {
+		return false, err
+	}
+
+	if holder != j.ID {
+		logger.Log.Info("Unique job is already queued", zap.String("job_id", holder.String()), zap.String("queue", q.KeyWithoutPrefix))
+		j.ID = holder
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// releaseUnique unlocks a unique job if it is released at the given point, UniqueUntilProcessing or UniqueUntilCompleted.
+// The lock of a UniqueUntilExpired job is only released here when the job could not be queued.
+func (q *Queue) releaseUnique(ctx context.Context, j *job.Job, until string) {
+	if j.UniqueUntil != until {
+		return
+	}
+
+	// The lock expires by itself, failing to release it early only delays the next duplicate
+	if err := q.locks().Release(ctx, q.uniqueLockKey(j), j.ID); err != nil {
+		logger.Log.Error("Error releasing unique job lock", zap.String("job_id", j.ID.String()), zap.Error(err))
+	}
+}
+
+type redisLocks struct {
+	client redis.Cmdable
+}
+
+// NewRedisLocks returns unique locks held in Redis with a TTL.
+func NewRedisLocks(client redis.Cmdable) UniqueLocks {
+	return &redisLocks{
+		client: client,
+	}
+}
+
+func (l *redisLocks) Acquire(ctx context.Context, key string, jobID uuid.UUID, ttl time.Duration) (uuid.UUID, error) {
+	holder, err := acquireLockScript.Run(ctx, l.client, []string{rdb.AddPrefix(key)}, jobID.String(), ttl.Milliseconds()).Text()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(holder)
+}
+
+func (l *redisLocks) Release(ctx context.Context, key string, jobID uuid.UUID) error {
+	return rdb.ReleaseLock(ctx, l.client, rdb.AddPrefix(key), jobID.String())
+}
+
+type memoryLock struct {
+	jobID     uuid.UUID
+	expiresAt time.Time
+}
+
+type memoryLocks struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+}
+
+// NewMemoryLocks returns unique locks held in process memory.
+func NewMemoryLocks() UniqueLocks {
+	return &memoryLocks{
+		locks: make(map[string]memoryLock),
+	}
+}
+
+func (l *memoryLocks) Acquire(ctx context.Context, key string, jobID uuid.UUID, ttl time.Duration) (uuid.UUID, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lock, ok := l.locks[key]; ok && time.Now().Before(lock.expiresAt) {
+		return lock.jobID, nil
+	}
+
+	l.locks[key] = memoryLock{jobID: jobID, expiresAt: time.Now().Add(ttl)}
+	return jobID, nil
+}
+
+func (l *memoryLocks) Release(ctx context.Context, key string, jobID uuid.UUID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lock, ok := l.locks[key]; ok && lock.jobID == jobID {
+		delete(l.locks, key)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUniqueJob(t *testing.T, until string) *job.Job {
+	j, err := job.NewJob("ProcessExample", &job.ProcessExample{Data: "resync user 42"}, 1, 0)
+	require.NoError(t, err)
+	return j.Unique("", until, time.Minute)
+}
+
+// failingLocks fails to acquire the lock of the jobs with the given ID.
+type failingLocks struct {
+	UniqueLocks
+	failing uuid.UUID
+}
+
+func (l *failingLocks) Acquire(ctx context.Context, key string, jobID uuid.UUID, ttl time.Duration) (uuid.UUID, error) {
+	if jobID == l.failing {
+		return uuid.Nil, errors.New("lock store is down")
+	}
+	return l.UniqueLocks.Acquire(ctx, key, jobID, ttl)
+}
+
+func Test_uniqueJobs(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a duplicate returns the ID of the queued job", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first := newUniqueJob(t, job.UniqueUntilProcessing)
+		duplicate := newUniqueJob(t, job.UniqueUntilProcessing)
+		firstID := first.ID
+
+		require.NoError(t, q.Enqueue(ctx, first, duplicate))
+		assert.Equal(t, firstID, duplicate.ID)
+
+		length, err := q.Length(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), length)
+	})
+
+	t.Run("until processing releases the lock once a worker takes the job", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first := newUniqueJob(t, job.UniqueUntilProcessing)
+		require.NoError(t, q.Enqueue(ctx, first))
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, dequeued)
+
+		next := newUniqueJob(t, job.UniqueUntilProcessing)
+		nextID := next.ID
+		require.NoError(t, q.Enqueue(ctx, next))
+		assert.Equal(t, nextID, next.ID, "the job should be queued while the first one runs")
+	})
+
+	t.Run("until completed holds the lock until the job succeeds or fails for good", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first := newUniqueJob(t, job.UniqueUntilCompleted)
+		firstID := first.ID
+		require.NoError(t, q.Enqueue(ctx, first))
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+
+		running := newUniqueJob(t, job.UniqueUntilCompleted)
+		require.NoError(t, q.Enqueue(ctx, running))
+		assert.Equal(t, firstID, running.ID, "the lock should be held while the job runs")
+
+		require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, errors.New("boom")))
+
+		next := newUniqueJob(t, job.UniqueUntilCompleted)
+		nextID := next.ID
+		require.NoError(t, q.Enqueue(ctx, next))
+		assert.Equal(t, nextID, next.ID, "the lock should be released once the job failed for good")
+	})
+
+	t.Run("until expired holds the lock after the job completed", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first := newUniqueJob(t, job.UniqueUntilExpired)
+		firstID := first.ID
+		require.NoError(t, q.Enqueue(ctx, first))
+
+		dequeued, err := q.TryDequeue(ctx)
+		require.NoError(t, err)
+		require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, nil))
+
+		retried := newUniqueJob(t, job.UniqueUntilExpired)
+		require.NoError(t, q.Enqueue(ctx, retried))
+		assert.Equal(t, firstID, retried.ID, "the lock should be held until it expires")
+	})
+
+	t.Run("an explicit key locks jobs with different payloads", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "a"}, 1, 0)
+		second, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "b"}, 1, 0)
+		first.Unique("user:42", job.UniqueUntilCompleted, time.Minute)
+		second.Unique("user:42", job.UniqueUntilCompleted, time.Minute)
+
+		require.NoError(t, q.Enqueue(ctx, first, second))
+		assert.Equal(t, first.ID, second.ID)
+	})
+
+	t.Run("no lock is held when a later job of the same enqueue fails to lock", func(t *testing.T) {
+		driver := NewMemoryDriver().(*memoryDriver)
+		q := NewQueueWithDriver("testing", driver)
+		first := newUniqueJob(t, job.UniqueUntilCompleted)
+		other, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "resync user 43"}, 1, 0)
+		other.Unique("", job.UniqueUntilCompleted, time.Minute)
+		driver.locks = &failingLocks{UniqueLocks: driver.locks, failing: other.ID}
+
+		assert.Error(t, q.Enqueue(ctx, first, other))
+
+		next := newUniqueJob(t, job.UniqueUntilCompleted)
+		nextID := next.ID
+		require.NoError(t, q.Enqueue(ctx, next))
+		assert.Equal(t, nextID, next.ID, "the lock of the job that was not queued should be released")
+	})
+
+	t.Run("the lock expires after its TTL", func(t *testing.T) {
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		first := newUniqueJob(t, job.UniqueUntilCompleted)
+		first.UniqueFor = 1
+		require.NoError(t, q.Enqueue(ctx, first))
+
+		time.Sleep(1100 * time.Millisecond)
+
+		next := newUniqueJob(t, job.UniqueUntilCompleted)
+		nextID := next.ID
+		require.NoError(t, q.Enqueue(ctx, next))
+		assert.Equal(t, nextID, next.ID)
+	})
+}
+
+func Test_redisLocks(t *testing.T) {
+	ctx := context.Background()
+	q := newTestRedisQueue(t, "testing_unique")
+
+	// Many replicas enqueue the same job at once, only one of them gets it queued
+	var wg sync.WaitGroup
+	jobIDs := make(chan uuid.UUID, 20)
+	for i := 0; i < cap(jobIDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j := newUniqueJob(t, job.UniqueUntilCompleted)
+			assert.NoError(t, q.Enqueue(ctx, j))
+			jobIDs <- j.ID
+		}()
+	}
+	wg.Wait()
+	close(jobIDs)
+
+	seen := make(map[uuid.UUID]bool)
+	for jobID := range jobIDs {
+		seen[jobID] = true
+	}
+	assert.Len(t, seen, 1, "every enqueue should return the same job ID")
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Ready)
+
+	dequeued, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.RemoveProcessed(ctx, dequeued.ID, nil))
+
+	next := newUniqueJob(t, job.UniqueUntilCompleted)
+	nextID := next.ID
+	require.NoError(t, q.Enqueue(ctx, next))
+	assert.Equal(t, nextID, next.ID, "the lock should be released once the job completed")
+}
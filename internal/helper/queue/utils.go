@@ -3,10 +3,8 @@ package queue
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/google/uuid"
-	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
 )
 
 const (
@@ -16,75 +14,62 @@ const (
 	ERROR_DELETING_KEY          = "error deleting key %s: %w"
 )
 
-// ListQueueKeys retrieves all queue keys matching the queue key prefix.
+// ListQueueKeys lists the names of the queues that hold jobs.
 func ListQueueKeys(ctx context.Context) ([]string, error) {
-	prefix := rdb.GetQueuePrefix()
-	rdbClient := rdb.GetRedisClient()
-	var keys []string
-	var cursor uint64
-	var err error
-
-	for {
-		var batch []string
-		batch, cursor, err = rdbClient.Scan(ctx, cursor, prefix+"*", 10).Result()
-		if err != nil {
-			return nil, fmt.Errorf(ERROR_SCANNING_REDIS_KEY, err)
-		}
-
-		for _, key := range batch {
-			if !strings.HasSuffix(key, "_attempt") && !strings.HasSuffix(key, "_failed") {
-				key = strings.TrimPrefix(key, prefix+"_")
-				keys = append(keys, key)
-			}
-		}
-
-		if cursor == 0 {
-			break
-		}
+	keys, err := DefaultDriver().Queues(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_LISTING_QUEUE_KEY, err)
 	}
 
 	return keys, nil
 }
 
-// ListQueueKeysAndLengths retrieves all queue keys matching the queue key prefix
-// and the number of items in each queue.
+// ListQueueKeysAndLengths lists the queues that hold jobs and the number of items in each queue.
+// Paused queues are listed even when they hold no job.
 func ListQueueKeysAndLengths(ctx context.Context) ([]QueueInfo, error) {
-	prefix := rdb.GetQueuePrefix()
-	rdbClient := rdb.GetRedisClient()
-	var keys []string
-	var cursor uint64
-	var err error
-
-	for {
-		var batch []string
-		batch, cursor, err = rdbClient.Scan(ctx, cursor, prefix+"*", 50).Result()
-		if err != nil {
-			return nil, fmt.Errorf(ERROR_SCANNING_REDIS_KEY, err)
-		}
+	keys, err := ListQueueKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, key := range batch {
-			if !strings.HasSuffix(key, "_attempt") && !strings.HasSuffix(key, "_failed") {
-				keys = append(keys, key)
-			}
-		}
+	pausedKeys, err := driverPausedQueues(DefaultDriver()).List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_LISTING_QUEUE_KEY, err)
+	}
 
-		if cursor == 0 {
-			break
+	paused := make(map[string]bool, len(pausedKeys))
+	for _, key := range pausedKeys {
+		paused[key] = true
+	}
+
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+	for _, key := range pausedKeys {
+		if !listed[key] {
+			keys = append(keys, key)
 		}
 	}
 
 	// Retrieve the length of each queue.
 	queueInfos := make([]QueueInfo, 0, len(keys))
 	for _, key := range keys {
-		length, err := rdbClient.LLen(ctx, key).Result()
+		q := NewQueue(key)
+		stats, err := q.Stats(ctx)
 		if err != nil {
 			return nil, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, key, err)
 		}
 
 		queueInfo := QueueInfo{
-			Key:              key,
-			KeyWithoutPrefix: strings.TrimPrefix(key, prefix+"_"),
-			NumberOfItems:    length,
+			Key:              q.Key,
+			KeyWithoutPrefix: key,
+			NumberOfItems:    stats.Ready,
+			NumberOfDelayed:  stats.Delayed,
+			NumberOfReserved: stats.Reserved,
+			NumberOfFailed:   stats.Failed,
+			Weight:           q.Weight,
+			Paused:           paused[key],
 		}
 		queueInfos = append(queueInfos, queueInfo)
 	}
@@ -92,55 +77,39 @@ func ListQueueKeysAndLengths(ctx context.Context) ([]QueueInfo, error) {
 	return queueInfos, nil
 }
 
-// List all failed queue keys matching the queue key prefix.
+// ListFailedQueueKeys lists the names of the queues that hold failed jobs.
 func ListFailedQueueKeys(ctx context.Context) ([]string, error) {
-	prefix := rdb.GetQueuePrefix()
-	rdbClient := rdb.GetRedisClient()
-	var keys []string
-	var cursor uint64
-	var err error
-
-	for {
-		var batch []string
-		batch, cursor, err = rdbClient.Scan(ctx, cursor, prefix+"*", 10).Result()
-		if err != nil {
-			return nil, fmt.Errorf(ERROR_SCANNING_REDIS_KEY, err)
-		}
+	keys, err := ListQueueKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, key := range batch {
-			if strings.HasSuffix(key, "_failed") {
-				keys = append(keys, key)
-			}
+	var failedKeys []string
+	for _, key := range keys {
+		stats, err := NewQueue(key).Stats(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, key, err)
 		}
 
-		if cursor == 0 {
-			break
+		if stats.Failed > 0 {
+			failedKeys = append(failedKeys, key)
 		}
 	}
 
-	return keys, nil
+	return failedKeys, nil
 }
 
-// ClearAll deletes all queue keys matching the queue key prefix and returns the total number of items cleared.
+// ClearAll deletes the ready and delayed jobs of all queues and returns the total number of items cleared.
 func ClearAll(ctx context.Context) (int64, error) {
 	queueKeys, err := ListQueueKeys(ctx)
 	if err != nil {
 		return 0, fmt.Errorf(ERROR_LISTING_QUEUE_KEY, err)
 	}
 
-	rdbClient := rdb.GetRedisClient()
 	totalCleared := int64(0)
 	for _, key := range queueKeys {
-		key := rdb.AddQueuePrefix(key)
-
-		// Get the length of the queue before deleting the key.
-		length, err := rdbClient.LLen(ctx, key).Result()
-		if err != nil {
-			return 0, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, key, err)
-		}
-
-		// Delete the key.
-		err = rdbClient.Del(ctx, key).Err()
+		// Delete the ready and delayed jobs of the queue.
+		length, err := NewQueue(key).Clear(ctx)
 		if err != nil {
 			return 0, fmt.Errorf(ERROR_DELETING_KEY, key, err)
 		}
@@ -152,29 +121,21 @@ func ClearAll(ctx context.Context) (int64, error) {
 	return totalCleared, nil
 }
 
-// FlushAllFailed deletes all failed queue keys matching the queue key prefix and returns the total number of items cleared.
+// FlushAllFailed deletes the failed jobs of all queues and returns the total number of items cleared.
 func FlushAllFailed(ctx context.Context) (int64, error) {
 	queueKeys, err := ListFailedQueueKeys(ctx)
 	if err != nil {
 		return 0, fmt.Errorf(ERROR_LISTING_QUEUE_KEY, err)
 	}
 
-	rdbClient := rdb.GetRedisClient()
 	totalCleared := int64(0)
 	for _, key := range queueKeys {
-		// Get the length of the queue before deleting the key.
-		length, err := rdbClient.LLen(ctx, key).Result()
-		if err != nil {
-			return 0, fmt.Errorf(ERROR_GETTING_LENGTH_OF_KEY, key, err)
-		}
-
-		// Delete the key.
-		err = rdbClient.Del(ctx, key).Err()
+		length, err := NewQueue(key).RemoveAllFailed(ctx)
 		if err != nil {
 			return 0, fmt.Errorf(ERROR_DELETING_KEY, key, err)
 		}
 
-		// Add the number of items cleared from this key to the total count.
+		// Add the number of items cleared from this queue to the total count.
 		totalCleared += length
 	}
 
@@ -192,7 +153,6 @@ func RemoveJobOnAnyQueueByID(ctx context.Context, jobID uuid.UUID) (string, erro
 	// Iterate through all queue keys
 	for _, key := range queueKeys {
 		q := NewQueue(key)
-		println(q.Key)
 
 		// Check if the job is in the main queue
 		isDeleted, err := q.RemoveJobByID(ctx, jobID)
@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/pkg/transport"
+	"go.uber.org/zap"
+)
+
+const (
+	// WebhookHandlerName is the handler of the jobs delivering the webhooks, their own events are never delivered.
+	WebhookHandlerName = "DeliverWebhook"
+
+	// DefaultWebhookMaxAttempts is the number of attempts of a delivery when the webhook sets none.
+	DefaultWebhookMaxAttempts = 5
+
+	webhookTimeout = 10 * time.Second
+)
+
+// Headers of the webhook requests. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>" with the secret
+// of the webhook, see VerifyWebhookSignature.
+const (
+	WebhookIDHeader        = "X-Webhook-Id" // the ID of the delivery job, the same on every attempt
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookDelivery is the payload of a webhook delivery job.
+type webhookDelivery struct {
+	URL   string    `json:"url"`
+	Event job.Event `json:"event"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func init() {
+	job.Register(WebhookHandlerName, deliverWebhook)
+}
+
+// SubscribeWebhooks subscribes the webhooks to the job events. Every event is delivered by a job of its own,
+// so a failed delivery is retried with an exponential backoff without holding up the job it is about.
+func SubscribeWebhooks(webhooks []config.QueueWebhook) (unsubscribe func()) {
+	if len(webhooks) == 0 {
+		return func() {}
+	}
+	return job.Subscribe(webhookSubscriber(webhooks, DefaultDriver))
+}
+
+// webhookSubscriber enqueues a delivery job on the driver for each webhook an event is sent to.
+func webhookSubscriber(webhooks []config.QueueWebhook, driver func() Driver) job.Subscriber {
+	return func(ctx context.Context, event job.Event) {
+		if event.HandlerName == WebhookHandlerName {
+			return
+		}
+
+		for _, webhook := range webhooks {
+			if !webhookWants(webhook, event) {
+				continue
+			}
+
+			maxAttempts := webhook.MaxAttempts
+			if maxAttempts <= 0 {
+				maxAttempts = DefaultWebhookMaxAttempts
+			}
+			j, err := job.NewJob(WebhookHandlerName, webhookDelivery{URL: webhook.URL, Event: event}, maxAttempts, 0)
+			if err != nil {
+				logger.Log.Error("Error creating webhook delivery", zap.String("url", webhook.URL), zap.Error(err))
+				continue
+			}
+			j.Backoff = job.NewExponentialBackoff(10, 3600)
+
+			queue := webhook.Queue
+			if queue == "" {
+				queue = event.Queue
+			}
+			if err := NewQueueWithDriver(queue, driver()).Enqueue(ctx, j); err != nil {
+				logger.Log.Error("Error enqueueing webhook delivery", zap.String("url", webhook.URL), zap.String("event", string(event.Type)), zap.String("job_id", event.JobID.String()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// webhookWants reports whether the event is sent to the webhook.
+func webhookWants(webhook config.QueueWebhook, event job.Event) bool {
+	return matchesAny(webhook.Events, string(event.Type)) && matchesAny(webhook.Queues, event.Queue)
+}
+
+// matchesAny reports whether the value is one of the values, any value matches an empty list.
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWebhook POSTs the event of a delivery to its webhook, any response but a 2xx fails the attempt.
+func deliverWebhook(ctx context.Context, delivery webhookDelivery) error {
+	webhook, ok := configuredWebhook(delivery.URL)
+	if !ok {
+		return job.NoRetry(fmt.Errorf("webhook %s is no longer configured", delivery.URL))
+	}
+
+	body, err := sonic.Marshal(delivery.Event)
+	if err != nil {
+		return job.NoRetry(err)
+	}
+
+	var deliveryID string
+	if info, ok := job.InfoFromContext(ctx); ok {
+		deliveryID = info.ID.String()
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	res, err := transport.MakeHTTPRequest(ctx, transport.HttpRequest{
+		HttpClient: webhookClient,
+		Url:        webhook.URL,
+		Method:     http.MethodPost,
+		Body:       body,
+		Headers: map[string]string{
+			"Content-Type":         "application/json",
+			WebhookIDHeader:        deliveryID,
+			WebhookEventHeader:     string(delivery.Event.Type),
+			WebhookTimestampHeader: timestamp,
+			WebhookSignatureHeader: SignWebhook(webhook.Secret, timestamp, body),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error delivering webhook to %s: %w", webhook.URL, err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %s", webhook.URL, res.Status)
+	}
+
+	return nil
+}
+
+// configuredWebhook returns the webhook of the config with the URL, the secret is not kept in the delivery jobs.
+func configuredWebhook(url string) (config.QueueWebhook, bool) {
+	for _, webhook := range config.GetConfig().Queue.Webhooks {
+		if webhook.URL == url {
+			return webhook, true
+		}
+	}
+	return config.QueueWebhook{}, false
+}
+
+// SignWebhook returns the signature of a webhook request body sent at the unix timestamp.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether the signature of a webhook request is valid, and was sent
+// less than tolerance ago so a captured request cannot be replayed later.
+func VerifyWebhookSignature(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(sentAt, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body)))
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventTestPayload struct {
+	Fail bool `json:"fail"`
+}
+
+func init() {
+	job.Register("EventTest", func(ctx context.Context, payload eventTestPayload) error {
+		if payload.Fail {
+			return errors.New("failed on purpose")
+		}
+		return nil
+	})
+}
+
+func Test_events(t *testing.T) {
+	ctx := context.Background()
+
+	// record runs a worker on the jobs and returns the types of the events they published
+	record := func(t *testing.T, jobs ...*job.Job) []job.EventType {
+		var events []job.EventType
+		unsubscribe := job.SubscribeHandler("EventTest", func(ctx context.Context, event job.Event) {
+			events = append(events, event.Type)
+		})
+		defer unsubscribe()
+
+		q := NewQueueWithDriver("testing", NewMemoryDriver())
+		require.NoError(t, q.Enqueue(ctx, jobs...))
+		require.NoError(t, RunWorker(ctx, WorkerOptions{StopWhenEmpty: true}, q))
+		return events
+	}
+
+	t.Run("a successful job", func(t *testing.T) {
+		j, _ := job.NewJob("EventTest", eventTestPayload{}, 3, 0)
+		assert.Equal(t, []job.EventType{job.EventEnqueued, job.EventStarted, job.EventSucceeded}, record(t, j))
+	})
+
+	t.Run("a job failing until it has no attempts left", func(t *testing.T) {
+		j, _ := job.NewJob("EventTest", eventTestPayload{Fail: true}, 2, 0)
+		assert.Equal(t, []job.EventType{
+			job.EventEnqueued,
+			job.EventStarted, job.EventFailed, job.EventRetried,
+			job.EventStarted, job.EventFailed, job.EventDead,
+		}, record(t, j))
+	})
+}
+
+func Test_webhookSubscriber(t *testing.T) {
+	ctx := context.Background()
+	driver := NewMemoryDriver()
+	subscriber := webhookSubscriber([]config.QueueWebhook{
+		{URL: "https://example.com/all"},
+		{URL: "https://example.com/dead", Events: []string{"job.dead"}, Queue: "webhooks"},
+		{URL: "https://example.com/emails", Queues: []string{"emails"}},
+	}, func() Driver { return driver })
+
+	j, _ := job.NewJob("EventTest", eventTestPayload{}, 3, 0)
+	subscriber(ctx, job.NewEvent(job.EventDead, "default", j, nil))
+
+	deliveries, err := NewQueueWithDriver("default", driver).Peek(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, WebhookHandlerName, deliveries[0].HandlerName)
+	assert.Contains(t, string(deliveries[0].Payload), "https://example.com/all")
+
+	deliveries, err = NewQueueWithDriver("webhooks", driver).Peek(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1, "a webhook can have its own delivery queue")
+	assert.Contains(t, string(deliveries[0].Payload), "https://example.com/dead")
+
+	// The events of the deliveries are not delivered, or every delivery would deliver another one
+	subscriber(ctx, job.NewEvent(job.EventSucceeded, "webhooks", deliveries[0], nil))
+	length, err := NewQueueWithDriver("webhooks", driver).Length(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+}
+
+func Test_deliverWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	status := http.StatusOK
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhooks := config.GetConfig().Queue.Webhooks
+	config.GetConfig().Queue.Webhooks = []config.QueueWebhook{{URL: server.URL, Secret: "webhook-secret"}}
+	defer func() { config.GetConfig().Queue.Webhooks = webhooks }()
+
+	j, _ := job.NewJob("EventTest", eventTestPayload{}, 3, 0)
+	delivery := webhookDelivery{URL: server.URL, Event: job.NewEvent(job.EventSucceeded, "default", j, nil)}
+
+	t.Run("the event is posted with a signature", func(t *testing.T) {
+		require.NoError(t, deliverWebhook(ctx, delivery))
+		require.NotNil(t, received)
+		assert.Equal(t, http.MethodPost, received.Method)
+		assert.Equal(t, "job.succeeded", received.Header.Get(WebhookEventHeader))
+		assert.Contains(t, string(body), j.ID.String())
+
+		timestamp := received.Header.Get(WebhookTimestampHeader)
+		signature := received.Header.Get(WebhookSignatureHeader)
+		assert.True(t, VerifyWebhookSignature("webhook-secret", timestamp, body, signature, time.Minute))
+		assert.False(t, VerifyWebhookSignature("other-secret", timestamp, body, signature, time.Minute))
+		assert.False(t, VerifyWebhookSignature("webhook-secret", timestamp, []byte(`{}`), signature, time.Minute))
+	})
+
+	t.Run("a response other than 2xx fails the delivery", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		defer func() { status = http.StatusOK }()
+
+		err := deliverWebhook(ctx, delivery)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, job.ErrNoRetry, "the delivery is retried")
+	})
+
+	t.Run("a webhook removed from the config is not retried", func(t *testing.T) {
+		err := deliverWebhook(ctx, webhookDelivery{URL: "https://example.com/removed", Event: delivery.Event})
+		assert.ErrorIs(t, err, job.ErrNoRetry)
+	})
+}
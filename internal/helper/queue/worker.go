@@ -0,0 +1,327 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// WorkerHeartbeatInterval is how often a worker refreshes its entry in the worker registry.
+	WorkerHeartbeatInterval = 5 * time.Second
+
+	// WorkerTTL is how long the entry of a worker outlives its last heartbeat, e.g. when its process was killed.
+	WorkerTTL = 3 * WorkerHeartbeatInterval
+)
+
+// WorkerInfo describes a worker processing jobs with RunQueues.
+type WorkerInfo struct {
+	ID            string        `json:"id"` // the consumer name of the worker, see NewConsumerName
+	Hostname      string        `json:"hostname"`
+	PID           int           `json:"pid"`
+	Queues        []string      `json:"queues"`
+	CurrentJobID  *uuid.UUID    `json:"current_job_id"` // nil while the worker waits for a job
+	JobStartedAt  *time.Time    `json:"job_started_at"`
+	JobProgress   *job.Progress `json:"job_progress"` // the last progress reported by the current job, see job.ReportProgress
+	StartedAt     time.Time     `json:"started_at"`
+	LastPolledAt  time.Time     `json:"last_polled_at"` // when the worker last asked its queues for a job
+	LastHeartbeat time.Time     `json:"last_heartbeat"`
+}
+
+// WorkerRegistry holds the entries of the running workers.
+type WorkerRegistry interface {
+	// Register adds or refreshes the entry of a worker, it expires after ttl without a refresh.
+	Register(ctx context.Context, info WorkerInfo, ttl time.Duration) error
+	// Unregister removes the entry of a worker.
+	Unregister(ctx context.Context, id string) error
+	// List returns the entries that did not expire, sorted by ID.
+	List(ctx context.Context) ([]WorkerInfo, error)
+}
+
+// workerProvider is implemented by the drivers that keep the worker registry themselves.
+type workerProvider interface {
+	Workers() WorkerRegistry
+}
+
+// driverWorkers returns the worker registry of a driver, a redis one if the driver has none.
+func driverWorkers(driver Driver) WorkerRegistry {
+	if provider, ok := driver.(workerProvider); ok {
+		return provider.Workers()
+	}
+	return NewRedisWorkerRegistry(rdb.GetRedisClient())
+}
+
+// ListWorkers lists the running workers of the default driver.
+func ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	return driverWorkers(DefaultDriver()).List(ctx)
+}
+
+// worker keeps the registry entry of a worker running RunQueues up to date.
+type worker struct {
+	registry WorkerRegistry
+	stopped  chan struct{} // closed once run removed the entry
+
+	mu   sync.Mutex
+	info WorkerInfo
+}
+
+func newWorker(ctx context.Context, queues []*Queue) *worker {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	names := make([]string, 0, len(queues))
+	for _, q := range queues {
+		names = append(names, q.KeyWithoutPrefix)
+	}
+
+	now := time.Now()
+	return &worker{
+		registry: driverWorkers(queues[0].driver),
+		stopped:  make(chan struct{}),
+		info: WorkerInfo{
+			ID:           ConsumerFromContext(ctx),
+			Hostname:     hostname,
+			PID:          os.Getpid(),
+			Queues:       names,
+			StartedAt:    now,
+			LastPolledAt: now,
+		},
+	}
+}
+
+// polled records that the worker asked its queues for a job.
+func (w *worker) polled() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.info.LastPolledAt = time.Now()
+}
+
+// processing records the job the worker runs, uuid.Nil once it is done.
+func (w *worker) processing(jobID uuid.UUID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.info.JobProgress = nil
+	if jobID == uuid.Nil {
+		w.info.CurrentJobID = nil
+		w.info.JobStartedAt = nil
+		return
+	}
+
+	startedAt := time.Now()
+	w.info.CurrentJobID = &jobID
+	w.info.JobStartedAt = &startedAt
+}
+
+// progressed records the progress reported by the job the worker runs, it is shown from the next heartbeat.
+func (w *worker) progressed(progress job.Progress) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.info.JobProgress = &progress
+}
+
+// run refreshes the entry of the worker every WorkerHeartbeatInterval until ctx is canceled, then removes it.
+func (w *worker) run(ctx context.Context) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(WorkerHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		w.heartbeat(ctx)
+
+		select {
+		case <-ctx.Done():
+			w.unregister()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unregister removes the entry of the worker, on a fresh context since the one of the worker is canceled.
+func (w *worker) unregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), WorkerHeartbeatInterval)
+	defer cancel()
+
+	if err := w.registry.Unregister(ctx, w.info.ID); err != nil {
+		logger.Log.Error("Error unregistering worker", zap.String("worker", w.info.ID), zap.Error(err))
+	}
+}
+
+func (w *worker) heartbeat(ctx context.Context) {
+	w.mu.Lock()
+	w.info.LastHeartbeat = time.Now()
+	info := w.info
+	w.mu.Unlock()
+
+	if err := w.registry.Register(ctx, info, WorkerTTL); err != nil && ctx.Err() == nil {
+		logger.Log.Error("Error registering worker", zap.String("worker", info.ID), zap.Error(err))
+	}
+}
+
+type redisWorkerRegistry struct {
+	client redis.Cmdable
+}
+
+// NewRedisWorkerRegistry returns a worker registry kept in Redis, one key with a TTL per worker
+// and a set indexing them.
+func NewRedisWorkerRegistry(client redis.Cmdable) WorkerRegistry {
+	return &redisWorkerRegistry{
+		client: client,
+	}
+}
+
+// indexKey returns the key of the set of worker IDs, it must not start with the queue prefix to stay out of ListQueueKeys.
+func (r *redisWorkerRegistry) indexKey() string {
+	return rdb.AddPrefix("workers")
+}
+
+func (r *redisWorkerRegistry) key(id string) string {
+	return rdb.AddPrefix("worker:" + id)
+}
+
+func (r *redisWorkerRegistry) Register(ctx context.Context, info WorkerInfo, ttl time.Duration) error {
+	infoBytes, err := sonic.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.key(info.ID), infoBytes, ttl)
+		pipe.SAdd(ctx, r.indexKey(), info.ID)
+		return nil
+	})
+	return err
+}
+
+func (r *redisWorkerRegistry) Unregister(ctx context.Context, id string) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.key(id))
+		pipe.SRem(ctx, r.indexKey(), id)
+		return nil
+	})
+	return err
+}
+
+func (r *redisWorkerRegistry) List(ctx context.Context) ([]WorkerInfo, error) {
+	ids, err := r.client.SMembers(ctx, r.indexKey()).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.key(id))
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]WorkerInfo, 0, len(values))
+	var expired []string
+	for i, value := range values {
+		infoText, ok := value.(string)
+		if !ok {
+			// The worker stopped heartbeating, its key expired
+			expired = append(expired, ids[i])
+			continue
+		}
+
+		var info WorkerInfo
+		if err := sonic.UnmarshalString(infoText, &info); err != nil {
+			return nil, err
+		}
+		workers = append(workers, info)
+	}
+
+	if len(expired) > 0 {
+		if err := r.prune(ctx, expired); err != nil {
+			return nil, err
+		}
+	}
+
+	return workers, nil
+}
+
+// prune removes the workers whose key expired from the index, see pruneWorkersScript.
+func (r *redisWorkerRegistry) prune(ctx context.Context, ids []string) error {
+	keys := make([]string, 0, len(ids)+1)
+	args := make([]any, 0, len(ids))
+	keys = append(keys, r.indexKey())
+	for _, id := range ids {
+		keys = append(keys, r.key(id))
+		args = append(args, id)
+	}
+
+	return pruneWorkersScript.Run(ctx, r.client, keys, args...).Err()
+}
+
+type memoryWorker struct {
+	info      WorkerInfo
+	expiresAt time.Time
+}
+
+type memoryWorkerRegistry struct {
+	mu      sync.Mutex
+	workers map[string]memoryWorker
+}
+
+// NewMemoryWorkerRegistry returns a worker registry kept in process memory.
+func NewMemoryWorkerRegistry() WorkerRegistry {
+	return &memoryWorkerRegistry{
+		workers: make(map[string]memoryWorker),
+	}
+}
+
+func (r *memoryWorkerRegistry) Register(ctx context.Context, info WorkerInfo, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.workers[info.ID] = memoryWorker{info: info, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (r *memoryWorkerRegistry) Unregister(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.workers, id)
+	return nil
+}
+
+func (r *memoryWorkerRegistry) List(ctx context.Context) ([]WorkerInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	workers := make([]WorkerInfo, 0, len(r.workers))
+	for id, w := range r.workers {
+		if time.Now().After(w.expiresAt) {
+			delete(r.workers, id)
+			continue
+		}
+		workers = append(workers, w.info)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+
+	return workers, nil
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_workers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a running worker is listed until it stops", func(t *testing.T) {
+		driver := NewMemoryDriver()
+		q := NewQueueWithDriver("testing", driver)
+
+		workerCtx, cancel := context.WithCancel(WithConsumer(ctx, "worker-1"))
+		stopped := make(chan error)
+		go func() {
+			stopped <- RunQueues(workerCtx, ModeStrict, q)
+		}()
+
+		var workers []WorkerInfo
+		require.Eventually(t, func() bool {
+			workers, _ = driverWorkers(driver).List(ctx)
+			return len(workers) == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "worker-1", workers[0].ID)
+		assert.Equal(t, []string{"testing"}, workers[0].Queues)
+		assert.Nil(t, workers[0].CurrentJobID)
+		assert.False(t, workers[0].LastHeartbeat.IsZero())
+
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("worker did not stop")
+		}
+
+		workers, err := driverWorkers(driver).List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, workers, "a stopped worker should be unregistered")
+	})
+
+	t.Run("the current job is cleared once processed", func(t *testing.T) {
+		w := newWorker(WithConsumer(ctx, "worker-1"), []*Queue{NewQueueWithDriver("testing", NewMemoryDriver())})
+
+		jobID := uuid.New()
+		w.processing(jobID)
+		w.heartbeat(ctx)
+		workers, err := w.registry.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 1)
+		assert.Equal(t, &jobID, workers[0].CurrentJobID)
+		assert.NotNil(t, workers[0].JobStartedAt)
+
+		w.processing(uuid.Nil)
+		w.heartbeat(ctx)
+		workers, err = w.registry.List(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, workers[0].CurrentJobID)
+		assert.Nil(t, workers[0].JobStartedAt)
+	})
+
+	t.Run("an entry without heartbeats expires", func(t *testing.T) {
+		registry := NewMemoryWorkerRegistry()
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-1"}, time.Millisecond))
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-2"}, time.Minute))
+
+		time.Sleep(5 * time.Millisecond)
+		workers, err := registry.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 1)
+		assert.Equal(t, "worker-2", workers[0].ID)
+	})
+
+	t.Run("expired redis entries are pruned from the index", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		registry := NewRedisWorkerRegistry(client)
+
+		jobID := uuid.New()
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-2", CurrentJobID: &jobID}, time.Minute))
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-1"}, time.Second))
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-3"}, time.Minute))
+
+		workers, err := registry.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 3)
+		assert.Equal(t, "worker-1", workers[0].ID)
+		assert.Equal(t, &jobID, workers[1].CurrentJobID)
+
+		server.FastForward(2 * time.Second)
+		require.NoError(t, registry.Unregister(ctx, "worker-3"))
+
+		workers, err = registry.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 1)
+		assert.Equal(t, "worker-2", workers[0].ID)
+
+		ids, err := client.SMembers(ctx, registry.(*redisWorkerRegistry).indexKey()).Result()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"worker-2"}, ids)
+	})
+
+	t.Run("a worker that heartbeats again after it was found expired stays in the index", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		registry := NewRedisWorkerRegistry(client).(*redisWorkerRegistry)
+
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-1"}, time.Second))
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-2"}, time.Second))
+		server.FastForward(2 * time.Second)
+
+		// worker-1 heartbeats between the read of the keys and the pruning of the index
+		require.NoError(t, registry.Register(ctx, WorkerInfo{ID: "worker-1"}, time.Minute))
+		require.NoError(t, registry.prune(ctx, []string{"worker-1", "worker-2"}))
+
+		ids, err := client.SMembers(ctx, registry.indexKey()).Result()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"worker-1"}, ids)
+
+		workers, err := registry.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 1)
+		assert.Equal(t, "worker-1", workers[0].ID)
+	})
+}
@@ -0,0 +1,116 @@
+package job
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kondohiroki/go-boilerplate/internal/app/job"
+	"github.com/kondohiroki/go-boilerplate/internal/interface/response"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"go.uber.org/zap"
+)
+
+// progressPollInterval is how often the progress stream checks the job for a change.
+var progressPollInterval = time.Second
+
+type JobHTTPHandler struct {
+	app job.JobApp
+}
+
+func NewJobHTTPHandler(app job.JobApp) *JobHTTPHandler {
+	return &JobHTTPHandler{app: app}
+}
+
+func (h *JobHTTPHandler) GetJobByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	dto, err := h.app.GetJobByID(c.Context(), job.GetJobDTI{ID: id})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+// StreamJobProgress streams the progress of a job as Server-Sent Events, a "progress" event whenever it changes
+// and a "done" event once the job is finished, after which the stream ends. The stream ends as well
+// as soon as the client goes away, a "ping" comment is sent before every poll to notice it.
+func (h *JobHTTPHandler) StreamJobProgress(c *fiber.Ctx) error {
+	input := job.GetJobDTI{ID: c.Params("id")}
+
+	// An unknown job is reported as a plain error response, before the stream starts
+	dto, err := h.app.GetJobProgress(c.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The request context is released once the handler returned, the stream polls on its own
+		ctx := context.Background()
+		var last []byte
+
+		for {
+			data, err := sonic.Marshal(dto)
+			if err != nil {
+				logger.Log.Error("Error encoding job progress", zap.String("job_id", input.ID), zap.Error(err))
+				return
+			}
+
+			if dto.Finished {
+				_ = writeEvent(w, "done", data)
+				return
+			}
+			if string(data) != string(last) {
+				if err := writeEvent(w, "progress", data); err != nil {
+					return
+				}
+				last = data
+			}
+
+			time.Sleep(progressPollInterval)
+
+			// A closed connection is only noticed when writing to it, so the job is not polled for a client that went away
+			if err := writeComment(w, "ping"); err != nil {
+				return
+			}
+
+			dto, err = h.app.GetJobProgress(ctx, input)
+			if err != nil {
+				logger.Log.Error("Error polling job progress", zap.String("job_id", input.ID), zap.Error(err))
+				_ = writeEvent(w, "error", []byte(fmt.Sprintf("%q", err.Error())))
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// writeEvent writes a Server-Sent Event and flushes it to the client.
+func writeEvent(w *bufio.Writer, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeComment writes a Server-Sent Events comment, which clients ignore, and flushes it to the client.
+func writeComment(w *bufio.Writer, comment string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -1,9 +1,14 @@
 package queue
 
 import (
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/kondohiroki/go-boilerplate/internal/app/queue"
 	"github.com/kondohiroki/go-boilerplate/internal/interface/response"
+	"github.com/kondohiroki/go-boilerplate/internal/interface/validation"
+	"github.com/kondohiroki/go-boilerplate/pkg/exception"
 )
 
 type QueueHTTPHandler struct {
@@ -26,3 +31,222 @@ func (h *QueueHTTPHandler) GetQueues(c *fiber.Ctx) error {
 		Data:            dtos,
 	})
 }
+
+func (h *QueueHTTPHandler) GetQueueByKey(c *fiber.Ctx) error {
+	dto, err := h.app.GetQueueByKey(c.Context(), queue.GetQueueDetailDTI{
+		Key:  c.Params("key"),
+		Peek: c.Query("peek"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) GetFailedJobs(c *fiber.Ctx) error {
+	dto, err := h.app.GetFailedJobs(c.Context(), queue.GetFailedJobsDTI{
+		Key:     c.Params("key"),
+		Page:    c.Query("page"),
+		PerPage: c.Query("perPage"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) RetryFailedJob(c *fiber.Ctx) error {
+	err := h.app.RetryFailedJob(c.Context(), queue.FailedJobDTI{
+		Key: c.Params("key"),
+		ID:  c.Params("id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+	})
+}
+
+func (h *QueueHTTPHandler) ForgetFailedJob(c *fiber.Ctx) error {
+	err := h.app.ForgetFailedJob(c.Context(), queue.FailedJobDTI{
+		Key: c.Params("key"),
+		ID:  c.Params("id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+	})
+}
+
+func (h *QueueHTTPHandler) CancelJob(c *fiber.Ctx) error {
+	err := h.app.CancelJob(c.Context(), queue.CancelJobDTI{
+		Key: c.Params("key"),
+		ID:  c.Params("id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+	})
+}
+
+func (h *QueueHTTPHandler) FlushFailedJobs(c *fiber.Ctx) error {
+	dto, err := h.app.FlushFailedJobs(c.Context(), queue.GetQueueDTI{Key: c.Params("key")})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) ClearQueue(c *fiber.Ctx) error {
+	dto, err := h.app.ClearQueue(c.Context(), queue.GetQueueDTI{Key: c.Params("key")})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) PauseQueue(c *fiber.Ctx) error {
+	if err := h.app.PauseQueue(c.Context(), queue.GetQueueDTI{Key: c.Params("key")}); err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+	})
+}
+
+func (h *QueueHTTPHandler) ResumeQueue(c *fiber.Ctx) error {
+	if err := h.app.ResumeQueue(c.Context(), queue.GetQueueDTI{Key: c.Params("key")}); err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+	})
+}
+
+func (h *QueueHTTPHandler) EnqueueJob(c *fiber.Ctx) error {
+	var req queue.EnqueueJobDTI
+
+	// Parse the request body
+	if err := c.BodyParser(&req); err != nil {
+		return exception.InvalidRequestBodyError
+	}
+
+	// Validate the request body
+	v, _ := validation.GetValidator()
+	if err := v.Struct(req); err != nil {
+		if validationErrs, ok := err.(validator.ValidationErrors); ok {
+			return exception.NewValidationFailedErrors(validationErrs)
+		}
+	}
+
+	// Process the business logic
+	req.Key = c.Params("key")
+	dto, err := h.app.EnqueueJob(c.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusCreated
+	if dto.Duplicate {
+		status = http.StatusOK
+	}
+
+	return c.Status(status).JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) EnqueueJobs(c *fiber.Ctx) error {
+	var req queue.EnqueueJobsDTI
+
+	// Parse the request body
+	if err := c.BodyParser(&req); err != nil {
+		return exception.InvalidRequestBodyError
+	}
+
+	// Validate the request body
+	v, _ := validation.GetValidator()
+	if err := v.Struct(req); err != nil {
+		if validationErrs, ok := err.(validator.ValidationErrors); ok {
+			return exception.NewValidationFailedErrors(validationErrs)
+		}
+	}
+
+	// Process the business logic
+	req.Key = c.Params("key")
+	dto, err := h.app.EnqueueJobs(c.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusCreated).JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) GetBatchByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	dto, err := h.app.GetBatchByID(c.Context(), queue.GetBatchDTI{ID: id})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dto,
+	})
+}
+
+func (h *QueueHTTPHandler) GetWorkers(c *fiber.Ctx) error {
+	dtos, err := h.app.GetWorkers(c.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response.CommonResponse{
+		ResponseCode:    0,
+		ResponseMessage: "OK",
+		Data:            dtos,
+	})
+}
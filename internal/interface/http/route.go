@@ -2,11 +2,14 @@ package httpapi
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/kondohiroki/go-boilerplate/internal/app/job"
 	"github.com/kondohiroki/go-boilerplate/internal/app/queue"
 	"github.com/kondohiroki/go-boilerplate/internal/app/user"
 	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"github.com/kondohiroki/go-boilerplate/internal/router/middleware"
 
 	httpHealthz "github.com/kondohiroki/go-boilerplate/internal/interface/http/healthz"
+	httpJob "github.com/kondohiroki/go-boilerplate/internal/interface/http/job"
 	httpMiscellaneous "github.com/kondohiroki/go-boilerplate/internal/interface/http/miscellaneous"
 	httpQueue "github.com/kondohiroki/go-boilerplate/internal/interface/http/queue"
 	httpUser "github.com/kondohiroki/go-boilerplate/internal/interface/http/user"
@@ -40,7 +43,29 @@ func RegisterRoute(r *fiber.App) {
 	queueApp := queue.NewQueueApp(repo)
 	queueHandler := httpQueue.NewQueueHTTPHandler(queueApp)
 	queueAPI.Get("/", queueHandler.GetQueues)
-	// queueAPI.Get("/:key", queueHandler.GetQueueByKey)
+	queueAPI.Get("/batches/:id", middleware.AdminAuth(), queueHandler.GetBatchByID)
+	queueAPI.Get("/workers", middleware.AdminAuth(), queueHandler.GetWorkers)
+
+	// Queue administration and dispatch API, registered after the routes above so "/batches" and "/workers" are not taken for queue keys
+	queueAdminAPI := queueAPI.Group("/:key", middleware.AdminAuth())
+	queueAdminAPI.Get("/", queueHandler.GetQueueByKey)
+	queueAdminAPI.Delete("/", queueHandler.ClearQueue)
+	queueAdminAPI.Post("/pause", queueHandler.PauseQueue)
+	queueAdminAPI.Post("/resume", queueHandler.ResumeQueue)
+	queueAdminAPI.Post("/jobs", queueHandler.EnqueueJob)
+	queueAdminAPI.Post("/jobs/bulk", queueHandler.EnqueueJobs)
+	queueAdminAPI.Post("/jobs/:id/cancel", queueHandler.CancelJob)
+	queueAdminAPI.Get("/failed", queueHandler.GetFailedJobs)
+	queueAdminAPI.Delete("/failed", queueHandler.FlushFailedJobs)
+	queueAdminAPI.Post("/failed/:id/retry", queueHandler.RetryFailedJob)
+	queueAdminAPI.Delete("/failed/:id", queueHandler.ForgetFailedJob)
+
+	// Job API
+	jobAPI := v1.Group("/jobs")
+	jobApp := job.NewJobApp(repo)
+	jobHandler := httpJob.NewJobHTTPHandler(jobApp)
+	jobAPI.Get("/:id", middleware.AdminAuth(), jobHandler.GetJobByID)
+	jobAPI.Get("/:id/progress", middleware.AdminAuth(), jobHandler.StreamJobProgress)
 
 	// Error Case Handler
 	miscellaneousHandler := httpMiscellaneous.NewMiscellaneousHTTPHandler()
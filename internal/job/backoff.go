@@ -0,0 +1,95 @@
+package job
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	BackoffFixed       = "fixed"       // BackoffFixed waits Delay seconds before every retry.
+	BackoffLinear      = "linear"      // BackoffLinear waits Delay seconds times the number of attempts.
+	BackoffExponential = "exponential" // BackoffExponential doubles the wait after every attempt, starting at Delay seconds.
+	BackoffList        = "list"        // BackoffList waits Delays[attempt-1] seconds, the last delay is reused once exhausted.
+)
+
+// Backoff is a retry policy for failed jobs. Delays are in seconds like Job.Delay.
+type Backoff struct {
+	Strategy string `json:"strategy"`
+	Delay    int    `json:"delay,omitempty"`
+	Delays   []int  `json:"delays,omitempty"`
+	MaxDelay int    `json:"max_delay,omitempty"` // 0 means no cap
+	Jitter   bool   `json:"jitter,omitempty"`    // randomize each wait between half and the full delay
+}
+
+// BackoffProvider is implemented by handlers that define their own retry policy.
+// The policy of the job, if any, takes precedence.
+type BackoffProvider interface {
+	Backoff() Backoff
+}
+
+func NewFixedBackoff(delay int) *Backoff {
+	return &Backoff{Strategy: BackoffFixed, Delay: delay}
+}
+
+func NewLinearBackoff(delay int) *Backoff {
+	return &Backoff{Strategy: BackoffLinear, Delay: delay}
+}
+
+func NewExponentialBackoff(delay int, maxDelay int) *Backoff {
+	return &Backoff{Strategy: BackoffExponential, Delay: delay, MaxDelay: maxDelay, Jitter: true}
+}
+
+func NewListBackoff(delays ...int) *Backoff {
+	return &Backoff{Strategy: BackoffList, Delays: delays}
+}
+
+// Next returns how long to wait before retrying a job that has been attempted attempts times.
+func (b Backoff) Next(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var seconds float64
+	switch b.Strategy {
+	case BackoffLinear:
+		seconds = float64(b.Delay) * float64(attempts)
+	case BackoffExponential:
+		seconds = float64(b.Delay) * math.Pow(2, float64(attempts-1))
+	case BackoffList:
+		if len(b.Delays) == 0 {
+			return 0
+		}
+		index := attempts - 1
+		if index >= len(b.Delays) {
+			index = len(b.Delays) - 1
+		}
+		seconds = float64(b.Delays[index])
+	default:
+		seconds = float64(b.Delay)
+	}
+
+	if b.MaxDelay > 0 && seconds > float64(b.MaxDelay) {
+		seconds = float64(b.MaxDelay)
+	}
+
+	// An uncapped exponential backoff outgrows a Duration after enough attempts, it then waits as long as a Duration can
+	delay := time.Duration(math.MaxInt64)
+	if seconds < float64(math.MaxInt64)/float64(time.Second) {
+		delay = time.Duration(seconds * float64(time.Second))
+	}
+	if b.Jitter && delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	return delay
+}
+
+// HandlerBackoff returns the retry policy defined by a handler, looking through the Adapt and middleware wrappers.
+func HandlerBackoff(handler ContextJobHandler) (Backoff, bool) {
+	provider, ok := Unwrap(handler).(BackoffProvider)
+	if !ok {
+		return Backoff{}, false
+	}
+	return provider.Backoff(), true
+}
@@ -0,0 +1,106 @@
+package job
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		attempts int
+		expected time.Duration
+	}{
+		{
+			name:     "fixed",
+			backoff:  *NewFixedBackoff(5),
+			attempts: 3,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "linear",
+			backoff:  *NewLinearBackoff(5),
+			attempts: 3,
+			expected: 15 * time.Second,
+		},
+		{
+			name:     "exponential",
+			backoff:  Backoff{Strategy: BackoffExponential, Delay: 2},
+			attempts: 4,
+			expected: 16 * time.Second,
+		},
+		{
+			name:     "exponential capped at max delay",
+			backoff:  Backoff{Strategy: BackoffExponential, Delay: 2, MaxDelay: 10},
+			attempts: 4,
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "exponential without max delay does not overflow",
+			backoff:  Backoff{Strategy: BackoffExponential, Delay: 10},
+			attempts: 100,
+			expected: time.Duration(math.MaxInt64),
+		},
+		{
+			name:     "list",
+			backoff:  *NewListBackoff(1, 10, 60),
+			attempts: 2,
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "list reuses the last delay",
+			backoff:  *NewListBackoff(1, 10, 60),
+			attempts: 7,
+			expected: 60 * time.Second,
+		},
+		{
+			name:     "empty list",
+			backoff:  *NewListBackoff(),
+			attempts: 1,
+			expected: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.backoff.Next(tt.attempts))
+		})
+	}
+
+	t.Run("jitter of a huge delay stays positive", func(t *testing.T) {
+		backoff := NewExponentialBackoff(10, 0)
+		for _, attempts := range []int{35, 64, 1000} {
+			assert.Positive(t, backoff.Next(attempts))
+		}
+	})
+
+	t.Run("jitter stays between half and the full delay", func(t *testing.T) {
+		backoff := NewExponentialBackoff(8, 0)
+		for i := 0; i < 100; i++ {
+			delay := backoff.Next(1)
+			assert.GreaterOrEqual(t, delay, 4*time.Second)
+			assert.LessOrEqual(t, delay, 8*time.Second)
+		}
+	})
+}
+
+func TestRetryErrors(t *testing.T) {
+	cause := errors.New("ncb is down")
+
+	noRetry := NoRetry(cause)
+	assert.ErrorIs(t, noRetry, ErrNoRetry)
+	assert.ErrorIs(t, noRetry, cause)
+
+	retryAfter := RetryAfter(time.Minute, cause)
+	assert.ErrorIs(t, retryAfter, cause)
+	assert.Equal(t, cause.Error(), retryAfter.Error())
+
+	var target *RetryAfterError
+	assert.ErrorAs(t, retryAfter, &target)
+	assert.Equal(t, time.Minute, target.Delay)
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// ErrEmptyChain is returned by NewChain when it is given no jobs.
+var ErrEmptyChain = errors.New("chain has no jobs")
+
+// ErrNoOutput is returned by SetOutput when the context was not given to a handler by a worker.
+var ErrNoOutput = errors.New("context does not belong to a job")
+
+// NewChain links the jobs so that each one is dispatched once the previous one succeeded, and returns the first job.
+// Enqueue the returned job to start the chain, the next jobs go to the same queue and receive the output
+// of the previous job as their input. The chain stops at the first job that fails for good.
+func NewChain(jobs ...*Job) (*Job, error) {
+	if len(jobs) == 0 {
+		return nil, ErrEmptyChain
+	}
+
+	first := jobs[0]
+	first.Chain = append(first.Chain, jobs[1:]...)
+	return first, nil
+}
+
+// Next returns the next job of the chain, carrying the rest of the chain and the given input.
+// It returns nil at the end of the chain.
+func (j *Job) Next(input json.RawMessage) *Job {
+	if len(j.Chain) == 0 {
+		return nil
+	}
+
+	next := j.Chain[0]
+	next.Chain = append(next.Chain, j.Chain[1:]...)
+	next.Input = input
+	return next
+}
+
+type outputKey struct{}
+
+type output struct {
+	mu    sync.Mutex
+	value json.RawMessage
+}
+
+// WithOutput returns a copy of ctx the handler can record its output in, see SetOutput.
+func WithOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outputKey{}, &output{})
+}
+
+// SetOutput records the output of the job handled with ctx. It is stored as the result of the job once it succeeded,
+// and the next job of the chain receives it as its input.
+func SetOutput(ctx context.Context, v any) error {
+	o, ok := ctx.Value(outputKey{}).(*output)
+	if !ok {
+		return ErrNoOutput
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.value = value
+	return nil
+}
+
+// OutputFromContext returns the output recorded with SetOutput, if any.
+func OutputFromContext(ctx context.Context) json.RawMessage {
+	o, ok := ctx.Value(outputKey{}).(*output)
+	if !ok {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.value
+}
@@ -0,0 +1,40 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChain(t *testing.T) {
+	_, err := NewChain()
+	assert.ErrorIs(t, err, ErrEmptyChain)
+
+	a, _ := NewJob("ProcessExample", &ProcessExample{Data: "a"}, 1, 0)
+	b, _ := NewJob("ProcessExample", &ProcessExample{Data: "b"}, 1, 0)
+	c, _ := NewJob("ProcessExample", &ProcessExample{Data: "c"}, 1, 0)
+
+	first, err := NewChain(a, b, c)
+	require.NoError(t, err)
+	assert.Equal(t, a, first)
+
+	next := first.Next([]byte(`1`))
+	assert.Equal(t, b.ID, next.ID)
+	assert.Equal(t, `1`, string(next.Input))
+
+	last := next.Next([]byte(`2`))
+	assert.Equal(t, c.ID, last.ID)
+	assert.Nil(t, last.Next(nil))
+}
+
+func TestSetOutput(t *testing.T) {
+	assert.ErrorIs(t, SetOutput(context.Background(), 1), ErrNoOutput)
+
+	ctx := WithOutput(context.Background())
+	assert.Nil(t, OutputFromContext(ctx))
+
+	require.NoError(t, SetOutput(ctx, map[string]int{"user_id": 42}))
+	assert.JSONEq(t, `{"user_id":42}`, string(OutputFromContext(ctx)))
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type infoKey struct{}
+
+// Info describes the job being processed. It is carried by the context given to a ContextJobHandler.
+type Info struct {
+	ID          uuid.UUID
+	Queue       string
+	HandlerName string
+	Attempt     int
+	MaxAttempts int
+	Input       json.RawMessage // Input is the output of the previous job of the chain, if any.
+}
+
+// WithInfo returns a copy of ctx carrying the job info.
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// InfoFromContext returns the job info carried by ctx, if any.
+func InfoFromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(infoKey{}).(Info)
+	return info, ok
+}
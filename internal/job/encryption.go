@@ -0,0 +1,167 @@
+package job
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoEncryptionKey is returned when a payload must be encrypted but no app key is set.
+	ErrNoEncryptionKey = errors.New("no key to encrypt the payload, set app.key")
+	// ErrUnknownEncryptionKey is returned for a payload encrypted with a key that is no longer configured.
+	ErrUnknownEncryptionKey = errors.New("payload is encrypted with an unknown key")
+)
+
+/*
+EncryptedPayload is implemented by the payloads, or handlers, whose jobs keep their payload encrypted at rest,
+in the queue as in the job history. The payload is encrypted when the job is enqueued and decrypted by the worker
+right before it is decoded, so the handler does not see the difference:
+
+	type ResetPassword struct {
+		Email string `json:"email"`
+		Token string `json:"token"`
+	}
+
+	func (ResetPassword) EncryptPayload() bool { return true }
+*/
+type EncryptedPayload interface {
+	EncryptPayload() bool
+}
+
+// IsEncrypted reports whether the handler registered under the name encrypts the payload of its jobs.
+func IsEncrypted(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return registry[name].Encrypted
+}
+
+// handlerEncrypts reports whether a handler opts in to payload encryption, looking through the Adapt and middleware wrappers.
+func handlerEncrypts(handler ContextJobHandler) bool {
+	encrypted, ok := Unwrap(handler).(EncryptedPayload)
+	return ok && encrypted.EncryptPayload()
+}
+
+// encryptedPayload is the payload of a job once encrypted, it holds the ID of the key it is encrypted with.
+type encryptedPayload struct {
+	Encrypted *ciphertext `json:"$encrypted"`
+}
+
+type ciphertext struct {
+	KeyID string `json:"kid"`
+	Data  []byte `json:"data"` // nonce followed by the sealed payload
+}
+
+// Keyring encrypts payloads with its current key and decrypts them with any of its keys,
+// so the key can be rotated while jobs encrypted with the previous ones are still queued.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD // by key ID
+}
+
+// NewKeyring returns a keyring encrypting with key and decrypting with key and previous.
+// The keys are secrets of any length, an AES-256 key is derived from each one.
+// A keyring without key decrypts nothing and fails to encrypt with ErrNoEncryptionKey.
+func NewKeyring(key string, previous ...string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, secret := range append([]string{key}, previous...) {
+		if secret == "" {
+			continue
+		}
+
+		derived := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(derived[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keyID := KeyID(secret)
+		k.keys[keyID] = aead
+		if i == 0 {
+			k.current = keyID
+		}
+	}
+
+	return k, nil
+}
+
+// KeyID returns the ID stored with the payloads encrypted with the key, it does not reveal the key.
+func KeyID(key string) string {
+	derived := sha256.Sum256([]byte(key))
+	id := sha256.Sum256(derived[:])
+	return hex.EncodeToString(id[:4])
+}
+
+// Encrypt encrypts the payload of the job, and of the jobs of its chain, if their handler opts in to it, see EncryptedPayload.
+// A payload already encrypted is left as is, so a job can be enqueued again.
+func (k *Keyring) Encrypt(j *Job) error {
+	if IsEncrypted(j.HandlerName) && !isEncrypted(j.Payload) {
+		aead, ok := k.keys[k.current]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNoEncryptionKey, j.HandlerName)
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(encryptedPayload{Encrypted: &ciphertext{
+			KeyID: k.current,
+			// The handler name is authenticated, so a payload cannot be moved to a job of another handler
+			Data: aead.Seal(nonce, nonce, j.Payload, []byte(j.HandlerName)),
+		}})
+		if err != nil {
+			return err
+		}
+		j.Payload = payload
+	}
+
+	for _, next := range j.Chain {
+		if err := k.Encrypt(next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decrypt returns the payload of the job in clear, as it was before Encrypt. A payload that is not encrypted is returned as is.
+func (k *Keyring) Decrypt(j *Job) (json.RawMessage, error) {
+	var envelope encryptedPayload
+	if json.Unmarshal(j.Payload, &envelope) != nil || envelope.Encrypted == nil {
+		return j.Payload, nil
+	}
+
+	aead, ok := k.keys[envelope.Encrypted.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEncryptionKey, envelope.Encrypted.KeyID)
+	}
+
+	data := envelope.Encrypted.Data
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted payload is too short")
+	}
+	payload, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(j.HandlerName))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+// isEncrypted reports whether a payload is encrypted.
+func isEncrypted(payload json.RawMessage) bool {
+	var envelope encryptedPayload
+	return json.Unmarshal(payload, &envelope) == nil && envelope.Encrypted != nil
+}
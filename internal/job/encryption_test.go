@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resetPassword struct {
+	Token string `json:"token"`
+}
+
+func (resetPassword) EncryptPayload() bool { return true }
+
+func TestKeyring(t *testing.T) {
+	Register("TestKeyring.ResetPassword", func(ctx context.Context, payload resetPassword) error { return nil })
+	Register("TestKeyring.OtherResetPassword", func(ctx context.Context, payload resetPassword) error { return nil })
+	assert.True(t, IsEncrypted("TestKeyring.ResetPassword"))
+	assert.False(t, IsEncrypted("ProcessExample"))
+
+	newJob := func(t *testing.T) *Job {
+		j, err := NewJob("TestKeyring.ResetPassword", resetPassword{Token: "secret"}, 1, 0)
+		require.NoError(t, err)
+		return j
+	}
+
+	t.Run("the payload is encrypted with the current key", func(t *testing.T) {
+		keyring, err := NewKeyring("new-key", "old-key")
+		require.NoError(t, err)
+
+		j := newJob(t)
+		require.NoError(t, keyring.Encrypt(j))
+		assert.NotContains(t, string(j.Payload), "secret")
+		assert.Contains(t, string(j.Payload), KeyID("new-key"))
+
+		encrypted := j.Payload
+		require.NoError(t, keyring.Encrypt(j))
+		assert.Equal(t, encrypted, j.Payload, "a payload is not encrypted twice")
+
+		payload, err := keyring.Decrypt(j)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"token":"secret"}`, string(payload))
+	})
+
+	t.Run("a payload encrypted with a previous key is decrypted", func(t *testing.T) {
+		old, _ := NewKeyring("old-key")
+		rotated, _ := NewKeyring("new-key", "old-key")
+
+		j := newJob(t)
+		require.NoError(t, old.Encrypt(j))
+
+		payload, err := rotated.Decrypt(j)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"token":"secret"}`, string(payload))
+
+		forgotten, _ := NewKeyring("new-key")
+		_, err = forgotten.Decrypt(j)
+		assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+	})
+
+	t.Run("a payload cannot be moved to a job of another handler", func(t *testing.T) {
+		keyring, _ := NewKeyring("key")
+		j := newJob(t)
+		require.NoError(t, keyring.Encrypt(j))
+
+		j.HandlerName = "TestKeyring.OtherResetPassword"
+		_, err := keyring.Decrypt(j)
+		assert.Error(t, err)
+	})
+
+	t.Run("only the jobs of handlers that opt in are encrypted", func(t *testing.T) {
+		keyring, _ := NewKeyring("key")
+		plain, _ := NewJob("ProcessExample", &ProcessExample{Data: "a"}, 1, 0)
+		chain, err := NewChain(plain, newJob(t))
+		require.NoError(t, err)
+
+		require.NoError(t, keyring.Encrypt(chain))
+		assert.JSONEq(t, `{"data":"a"}`, string(chain.Payload))
+		assert.NotContains(t, string(chain.Chain[0].Payload), "secret", "the jobs of the chain are encrypted too")
+
+		payload, err := keyring.Decrypt(chain)
+		require.NoError(t, err)
+		assert.Equal(t, chain.Payload, json.RawMessage(payload))
+	})
+
+	t.Run("a payload is not encrypted without key", func(t *testing.T) {
+		keyring, _ := NewKeyring("")
+		assert.ErrorIs(t, keyring.Encrypt(newJob(t)), ErrNoEncryptionKey)
+	})
+}
@@ -0,0 +1,64 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCancelled is the cause of the context of a handler whose job was cancelled, the job is not retried.
+var ErrCancelled = errors.New("job was cancelled")
+
+// ErrNoRetry marks a handler error as permanent, the job goes to the failed list without further attempts.
+var ErrNoRetry = errors.New("job must not be retried")
+
+// NoRetry wraps err so that the job is not retried.
+func NoRetry(err error) error {
+	return fmt.Errorf("%w: %w", ErrNoRetry, err)
+}
+
+// RetryAfterError asks for the job to be retried after Delay instead of its backoff policy.
+type RetryAfterError struct {
+	Delay time.Duration
+	Err   error
+}
+
+// RetryAfter wraps err so that the job is retried after delay.
+func RetryAfter(delay time.Duration, err error) error {
+	return &RetryAfterError{Delay: delay, Err: err}
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("retry after %s", e.Delay)
+	}
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// ReleaseError puts the job back to the queue after Delay, without counting the attempt nor recording an error.
+type ReleaseError struct {
+	Delay time.Duration
+}
+
+// Release returns an error that puts the job back to the queue after delay, see Middleware.
+func Release(delay time.Duration) error {
+	return &ReleaseError{Delay: delay}
+}
+
+func (e *ReleaseError) Error() string {
+	return fmt.Sprintf("job released for %s", e.Delay)
+}
+
+// PanicError is returned for a handler that panicked, with the stack trace of the panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic occurred while processing job: %v", e.Value)
+}
@@ -0,0 +1,146 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"go.uber.org/zap"
+)
+
+// EventType is a transition in the lifecycle of a job.
+type EventType string
+
+const (
+	EventEnqueued  EventType = "job.enqueued"
+	EventStarted   EventType = "job.started"
+	EventSucceeded EventType = "job.succeeded"
+	EventFailed    EventType = "job.failed"  // an attempt failed, followed by EventRetried or EventDead
+	EventRetried   EventType = "job.retried" // the job goes back to the queue for another attempt
+	EventDead      EventType = "job.dead"    // the job is moved to the failed list without further attempts
+	EventCancelled EventType = "job.cancelled"
+)
+
+// EventTypes lists every event type in lifecycle order.
+var EventTypes = []EventType{EventEnqueued, EventStarted, EventSucceeded, EventFailed, EventRetried, EventDead, EventCancelled}
+
+// Event is published when a job changes status, see Subscribe.
+type Event struct {
+	Type        EventType  `json:"type"`
+	JobID       uuid.UUID  `json:"job_id"`
+	Queue       string     `json:"queue"`
+	HandlerName string     `json:"handler_name"`
+	Attempt     int        `json:"attempt"`
+	MaxAttempts int        `json:"max_attempts"`
+	BatchID     *uuid.UUID `json:"batch_id,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+}
+
+// NewEvent returns the event of a job of the queue, jobError is the error of the attempt if any.
+func NewEvent(eventType EventType, queue string, j *Job, jobError error) Event {
+	event := Event{
+		Type:        eventType,
+		JobID:       j.ID,
+		Queue:       queue,
+		HandlerName: j.HandlerName,
+		Attempt:     j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		BatchID:     j.BatchID,
+		OccurredAt:  time.Now(),
+	}
+	if jobError != nil {
+		event.Error = jobError.Error()
+	}
+
+	return event
+}
+
+// Subscriber receives the events it subscribed to. It runs on the goroutine of the worker or the caller that
+// enqueued the job, so slow work such as a network call belongs in a job of its own, see queue.SubscribeWebhooks.
+type Subscriber func(ctx context.Context, event Event)
+
+type subscription struct {
+	id         int
+	subscriber Subscriber
+	types      map[EventType]bool // all types if empty
+}
+
+var (
+	subscriptionsMu    sync.RWMutex
+	subscriptions      []subscription
+	lastSubscriptionID int
+)
+
+/*
+Subscribe calls subscriber with the events of the given types, or of all types if none is given,
+for the jobs of every handler. It returns a function removing the subscription:
+
+	func init() {
+		job.Subscribe(func(ctx context.Context, event job.Event) {
+			metrics.JobsDead.WithLabelValues(event.Queue).Inc()
+		}, job.EventDead)
+	}
+*/
+func Subscribe(subscriber Subscriber, types ...EventType) (unsubscribe func()) {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+
+	lastSubscriptionID++
+	s := subscription{id: lastSubscriptionID, subscriber: subscriber, types: make(map[EventType]bool)}
+	for _, eventType := range types {
+		s.types[eventType] = true
+	}
+	subscriptions = append(subscriptions, s)
+
+	return func() {
+		subscriptionsMu.Lock()
+		defer subscriptionsMu.Unlock()
+
+		for i := range subscriptions {
+			if subscriptions[i].id == s.id {
+				subscriptions = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SubscribeHandler is Subscribe for the jobs of the handler registered under the name only.
+func SubscribeHandler(name string, subscriber Subscriber, types ...EventType) (unsubscribe func()) {
+	return Subscribe(func(ctx context.Context, event Event) {
+		if event.HandlerName == name {
+			subscriber(ctx, event)
+		}
+	}, types...)
+}
+
+// Publish calls the subscribers of the event one after another. A subscriber that panics is logged
+// and does not keep the others from receiving the event, nor fail the job.
+func Publish(ctx context.Context, event Event) {
+	subscriptionsMu.RLock()
+	// Subscribers may publish events themselves, so they are called without the lock
+	current := make([]subscription, len(subscriptions))
+	copy(current, subscriptions)
+	subscriptionsMu.RUnlock()
+
+	for _, s := range current {
+		if len(s.types) > 0 && !s.types[event.Type] {
+			continue
+		}
+		notify(ctx, s.subscriber, event)
+	}
+}
+
+func notify(ctx context.Context, subscriber Subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Error("Recovered from panic in job event subscriber", zap.String("event", string(event.Type)), zap.String("job_id", event.JobID.String()), zap.Any("panic", r))
+		}
+	}()
+
+	subscriber(ctx, event)
+}
@@ -0,0 +1,56 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	j, _ := NewJob("ProcessExample", nil, 3, 0)
+	other, _ := NewJob("TestSubscribe.Other", nil, 3, 0)
+
+	var all, dead, handler []EventType
+	unsubscribeAll := Subscribe(func(ctx context.Context, event Event) {
+		all = append(all, event.Type)
+	})
+	unsubscribeDead := Subscribe(func(ctx context.Context, event Event) {
+		dead = append(dead, event.Type)
+	}, EventDead)
+	unsubscribeHandler := SubscribeHandler("ProcessExample", func(ctx context.Context, event Event) {
+		handler = append(handler, event.Type)
+	})
+	unsubscribePanic := Subscribe(func(ctx context.Context, event Event) {
+		panic("boom")
+	})
+	defer unsubscribeDead()
+	defer unsubscribeHandler()
+	defer unsubscribePanic()
+
+	Publish(ctx, NewEvent(EventStarted, "default", j, nil))
+	Publish(ctx, NewEvent(EventDead, "default", j, errors.New("failed")))
+	Publish(ctx, NewEvent(EventSucceeded, "default", other, nil))
+
+	assert.Equal(t, []EventType{EventStarted, EventDead, EventSucceeded}, all, "a panicking subscriber does not stop the others")
+	assert.Equal(t, []EventType{EventDead}, dead)
+	assert.Equal(t, []EventType{EventStarted, EventDead}, handler)
+
+	unsubscribeAll()
+	Publish(ctx, NewEvent(EventEnqueued, "default", j, nil))
+	assert.Len(t, all, 3, "an unsubscribed subscriber receives no more events")
+}
+
+func TestNewEvent(t *testing.T) {
+	j, _ := NewJob("ProcessExample", nil, 3, 0)
+	j.Attempts = 2
+
+	event := NewEvent(EventFailed, "emails", j, errors.New("smtp is down"))
+	assert.Equal(t, j.ID, event.JobID)
+	assert.Equal(t, "emails", event.Queue)
+	assert.Equal(t, 2, event.Attempt)
+	assert.Equal(t, 3, event.MaxAttempts)
+	assert.Equal(t, "smtp is down", event.Error)
+}
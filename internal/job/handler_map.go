@@ -1,9 +1,66 @@
 package job
 
-type HandlerMap map[string]func() JobHandler
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
 
+// ErrInvalidPayload is returned for a payload that does not decode into the handler of its job.
+var ErrInvalidPayload = errors.New("payload does not decode into the handler")
+
+// HandlerMap holds the handler constructors by name.
+type HandlerMap map[string]func() ContextJobHandler
+
+// NewHandlerMap returns the registered handlers by name, see Register.
 func NewHandlerMap() HandlerMap {
-	return HandlerMap{
-		"ProcessExample": func() JobHandler { return new(ProcessExample) },
+	handlerMap := make(HandlerMap)
+	for _, registration := range Registrations() {
+		handlerMap[registration.Name] = registration.New
+	}
+
+	return handlerMap
+}
+
+// Decode returns the handler with the given name, with the payload decoded into it the way a worker does.
+// It returns ErrUnknownHandler if no handler has the name and ErrInvalidPayload if the payload does not decode.
+func (m HandlerMap) Decode(name string, payload json.RawMessage) (ContextJobHandler, error) {
+	newHandler, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHandler, name)
+	}
+
+	handler := newHandler()
+	if err := json.Unmarshal(payload, handler); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, name, err)
+	}
+
+	return handler, nil
+}
+
+// NewGlobalMiddleware returns the middleware every job runs through, before the middleware of its handler.
+func NewGlobalMiddleware() []Middleware {
+	return []Middleware{}
+}
+
+// Adapt turns a JobHandler constructor into a ContextJobHandler constructor.
+func Adapt(newHandler func() JobHandler) func() ContextJobHandler {
+	return func() ContextJobHandler {
+		return &jobHandlerAdapter{handler: newHandler()}
 	}
 }
+
+// jobHandlerAdapter runs a JobHandler as a ContextJobHandler, the context is ignored.
+type jobHandlerAdapter struct {
+	handler JobHandler
+}
+
+func (a *jobHandlerAdapter) HandleContext(_ context.Context) error {
+	return a.handler.Handle()
+}
+
+// UnmarshalJSON decodes the job payload into the wrapped handler.
+func (a *jobHandlerAdapter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, a.handler)
+}
@@ -1,16 +1,29 @@
 package job
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrTimeout is returned for a job that did not finish within its timeout.
+var ErrTimeout = errors.New("job timed out")
+
+// JobHandler is the original handler interface, it has no way to observe cancellation.
+// Register it with RegisterHandler through Adapt.
 type JobHandler interface {
 	Handle() error
 }
 
+// ContextJobHandler is a handler that receives a context carrying the job Info.
+// The context is canceled when the worker shuts down or the job exceeds its timeout.
+type ContextJobHandler interface {
+	HandleContext(ctx context.Context) error
+}
+
 // Job represents a job in the queue with a unique ID, queue name, payload, and creation timestamp.
 type Job struct {
 	ID          uuid.UUID       `json:"id"`
@@ -19,8 +32,26 @@ type Job struct {
 	CreatedAt   time.Time       `json:"created_at"`
 	MaxAttempts int             `json:"max_attempts"`
 	Attempts    int             `json:"attempts"`
-	Delay       int             `json:"delay"` // in seconds
+	Delay       int             `json:"delay"`   // in seconds
+	Timeout     int             `json:"timeout"` // in seconds, 0 means no timeout
+	Backoff     *Backoff        `json:"backoff,omitempty"`
+	Priority    int             `json:"priority"` // jobs with a priority above 0 go to the start of the queue
 	Errors      []string        `json:"errors"`
+
+	// UniqueKey, UniqueUntil and UniqueFor (in seconds) deduplicate the job, see Unique.
+	UniqueKey   string `json:"unique_key,omitempty"`
+	UniqueUntil string `json:"unique_until,omitempty"`
+	UniqueFor   int    `json:"unique_for,omitempty"`
+
+	// Chain holds the jobs dispatched one after another once the job succeeds, see NewChain.
+	Chain []*Job `json:"chain,omitempty"`
+	// Input is the output of the previous job of the chain, see SetOutput.
+	Input json.RawMessage `json:"input,omitempty"`
+	// BatchID is the ID of the batch the job belongs to, if any.
+	BatchID *uuid.UUID `json:"batch_id,omitempty"`
+
+	// LeaseExpiresAt is the deadline of the lease taken when the job was dequeued.
+	LeaseExpiresAt time.Time `json:"-"`
 }
 
 // NewJob creates a new Job with the given queue name and payload.
@@ -0,0 +1,206 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// HandleFunc runs the rest of the middleware chain and the handler.
+type HandleFunc func(ctx context.Context) error
+
+// Middleware wraps the handling of a job. It calls next to carry on, returns nil without calling it
+// to skip the job, or returns Release to put the job back to the queue.
+// The handler is the one the middleware wraps, see Unwrap to reach the registered handler.
+type Middleware func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error
+
+// KeyFunc returns the key a middleware scopes a job to, e.g. the ID of the user the job syncs.
+type KeyFunc func(ctx context.Context, handler ContextJobHandler) string
+
+// WithMiddleware turns a handler constructor into one whose handlers run through the middleware, in order.
+func WithMiddleware(newHandler func() ContextJobHandler, middleware ...Middleware) func() ContextJobHandler {
+	return func() ContextJobHandler {
+		return Pipeline(newHandler(), middleware...)
+	}
+}
+
+// Pipeline returns a handler that runs the middleware, in order, around the handler.
+func Pipeline(handler ContextJobHandler, middleware ...Middleware) ContextJobHandler {
+	if len(middleware) == 0 {
+		return handler
+	}
+	return &middlewareHandler{handler: handler, middleware: middleware}
+}
+
+// middlewareHandler runs a handler through its middleware.
+type middlewareHandler struct {
+	handler    ContextJobHandler
+	middleware []Middleware
+}
+
+func (m *middlewareHandler) HandleContext(ctx context.Context) error {
+	next := m.handler.HandleContext
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		middleware, rest := m.middleware[i], next
+		next = func(ctx context.Context) error {
+			return middleware(ctx, m.handler, rest)
+		}
+	}
+	return next(ctx)
+}
+
+// UnmarshalJSON decodes the job payload into the wrapped handler.
+func (m *middlewareHandler) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, m.handler)
+}
+
+// Unwrap returns the registered handler, looking through the middleware and Adapt wrappers.
+// For a handler registered with Register, it returns a pointer to the decoded payload.
+func Unwrap(handler ContextJobHandler) any {
+	for {
+		switch h := handler.(type) {
+		case *middlewareHandler:
+			handler = h.handler
+		case *jobHandlerAdapter:
+			return h.handler
+		case payloadHandler:
+			return h.payloadPointer()
+		default:
+			return handler
+		}
+	}
+}
+
+// Skip skips the job, which then counts as processed successfully, when the condition holds.
+func Skip(condition func(ctx context.Context, handler ContextJobHandler) bool) Middleware {
+	return func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error {
+		if condition(ctx, handler) {
+			return nil
+		}
+		return next(ctx)
+	}
+}
+
+// redisClient returns the client, or the default redis client if it is nil. The redis middleware resolve their
+// client when a job runs, so that they can be registered before redis is set up.
+func redisClient(client redis.Cmdable) redis.Cmdable {
+	if client == nil {
+		return rdb.GetRedisClient()
+	}
+	return client
+}
+
+// RateLimit lets at most limit jobs run per period under the key, across all workers.
+// Jobs over the limit are released back to the queue until the next period. A nil client uses the default redis client.
+// The period is counted in milliseconds, a shorter one is raised to a millisecond.
+func RateLimit(client redis.Cmdable, key string, limit int, per time.Duration) Middleware {
+	if per < time.Millisecond {
+		per = time.Millisecond
+	}
+
+	return func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error {
+		client := redisClient(client)
+		now := time.Now()
+		window := now.UnixMilli() / per.Milliseconds()
+		windowKey := rdb.AddPrefix(fmt.Sprintf("rate_limit:%s:%d", key, window))
+
+		pipe := client.TxPipeline()
+		count := pipe.Incr(ctx, windowKey)
+		pipe.PExpire(ctx, windowKey, per)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("error checking rate limit %s: %w", key, err)
+		}
+
+		if count.Val() > int64(limit) {
+			windowEnd := time.UnixMilli((window + 1) * per.Milliseconds())
+			return Release(windowEnd.Sub(now))
+		}
+
+		return next(ctx)
+	}
+}
+
+// WithoutOverlapping prevents jobs of the same handler with the same key from running at the same time,
+// a nil key locks on the handler alone. A job that finds the lock taken is released back to the queue
+// for releaseAfter, and the lock expires after expireAfter in case its worker dies. A nil client uses the default redis client.
+func WithoutOverlapping(client redis.Cmdable, key KeyFunc, releaseAfter time.Duration, expireAfter time.Duration) Middleware {
+	return func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error {
+		client := redisClient(client)
+		info, _ := InfoFromContext(ctx)
+		lockKey := "without_overlapping:" + info.HandlerName
+		if key != nil {
+			lockKey += ":" + key(ctx, handler)
+		}
+		lockKey = rdb.AddPrefix(lockKey)
+
+		token := uuid.NewString()
+		acquired, err := client.SetNX(ctx, lockKey, token, expireAfter).Result()
+		if err != nil {
+			return fmt.Errorf("error acquiring overlap lock: %w", err)
+		}
+		if !acquired {
+			return Release(releaseAfter)
+		}
+
+		// The job context may be canceled already, the lock must be released anyway
+		defer rdb.ReleaseLock(context.Background(), client, lockKey, token)
+
+		return next(ctx)
+	}
+}
+
+// ThrottleExceptions stops running jobs under the key for decay once maxExceptions of them failed in a row
+// within decay, e.g. to back off from an external service that is down. Throttled jobs are released back to
+// the queue until the decay ends, and a successful job resets the count. A nil client uses the default redis client.
+func ThrottleExceptions(client redis.Cmdable, key string, maxExceptions int, decay time.Duration) Middleware {
+	return func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error {
+		client := redisClient(client)
+		countKey := rdb.AddPrefix("throttle_exceptions:" + key)
+
+		count, err := client.Get(ctx, countKey).Int()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("error checking exception throttle %s: %w", key, err)
+		}
+		if count >= maxExceptions {
+			ttl, err := client.PTTL(ctx, countKey).Result()
+			if err != nil {
+				return fmt.Errorf("error checking exception throttle %s: %w", key, err)
+			}
+			if ttl < 0 {
+				ttl = decay
+			}
+			return Release(ttl)
+		}
+
+		handlerError := next(ctx)
+		if handlerError == nil {
+			// The job succeeded, failing to reset the count only throttles sooner
+			if err := client.Del(ctx, countKey).Err(); err != nil {
+				logger.Log.Error("Error resetting exception throttle", zap.String("key", key), zap.Error(err))
+			}
+			return nil
+		}
+
+		var release *ReleaseError
+		if errors.As(handlerError, &release) {
+			return handlerError
+		}
+
+		pipe := client.TxPipeline()
+		pipe.Incr(ctx, countKey)
+		pipe.PExpire(ctx, countKey, decay)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return errors.Join(handlerError, fmt.Errorf("error counting exception for throttle %s: %w", key, err))
+		}
+
+		return handlerError
+	}
+}
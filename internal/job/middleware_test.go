@@ -0,0 +1,133 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	configFile := "../../config/config.testing.yaml"
+	config.SetConfig(configFile)
+	logger.InitLogger("zap")
+}
+
+type contextHandlerFunc func(ctx context.Context) error
+
+func (f contextHandlerFunc) HandleContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+func newTestRedisClient(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	server := miniredis.RunT(t)
+	return server, redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func assertReleased(t *testing.T, err error) *ReleaseError {
+	var release *ReleaseError
+	require.ErrorAs(t, err, &release)
+	return release
+}
+
+func TestPipeline(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(ctx context.Context, handler ContextJobHandler, next HandleFunc) error {
+			calls = append(calls, name)
+			return next(ctx)
+		}
+	}
+
+	newHandler := WithMiddleware(Adapt(func() JobHandler { return new(ProcessExample) }), record("handler"))
+	handler := Pipeline(newHandler(), record("global"))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"data":"hello"}`), handler))
+	assert.Equal(t, "hello", Unwrap(handler).(*ProcessExample).Data, "the payload reaches the registered handler")
+
+	skipped := Pipeline(contextHandlerFunc(func(ctx context.Context) error {
+		calls = append(calls, "skipped handler")
+		return nil
+	}), record("global"), Skip(func(ctx context.Context, handler ContextJobHandler) bool { return true }))
+	require.NoError(t, skipped.HandleContext(context.Background()))
+	assert.Equal(t, []string{"global"}, calls)
+}
+
+func TestRateLimit(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	middleware := RateLimit(client, "ncb", 2, time.Minute)
+
+	ran := 0
+	handler := Pipeline(contextHandlerFunc(func(ctx context.Context) error {
+		ran++
+		return nil
+	}), middleware)
+
+	require.NoError(t, handler.HandleContext(context.Background()))
+	require.NoError(t, handler.HandleContext(context.Background()))
+	release := assertReleased(t, handler.HandleContext(context.Background()))
+	assert.Equal(t, 2, ran)
+	assert.LessOrEqual(t, release.Delay, time.Minute, "the job waits for the next window at most")
+}
+
+func TestRateLimit_subMillisecondPeriod(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	handler := Pipeline(contextHandlerFunc(func(ctx context.Context) error { return nil }), RateLimit(client, "ncb", 1, time.Microsecond))
+
+	assert.NotPanics(t, func() {
+		_ = handler.HandleContext(context.Background())
+	}, "the period is raised to a millisecond")
+}
+
+func TestWithoutOverlapping(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := WithInfo(context.Background(), Info{HandlerName: "SyncUser"})
+	middleware := WithoutOverlapping(client, func(ctx context.Context, handler ContextJobHandler) string {
+		return "user:42"
+	}, 10*time.Second, time.Minute)
+
+	inner := Pipeline(contextHandlerFunc(func(ctx context.Context) error { return nil }), middleware)
+	outer := Pipeline(contextHandlerFunc(func(ctx context.Context) error {
+		// Another job for the same user while this one runs
+		release := assertReleased(t, inner.HandleContext(ctx))
+		assert.Equal(t, 10*time.Second, release.Delay)
+		return nil
+	}), middleware)
+
+	require.NoError(t, outer.HandleContext(ctx))
+	require.NoError(t, inner.HandleContext(ctx), "the lock is released once the job finished")
+}
+
+func TestThrottleExceptions(t *testing.T) {
+	server, client := newTestRedisClient(t)
+	middleware := ThrottleExceptions(client, "ncb", 2, time.Minute)
+
+	failing := Pipeline(contextHandlerFunc(func(ctx context.Context) error { return errors.New("ncb is down") }), middleware)
+	succeeding := Pipeline(contextHandlerFunc(func(ctx context.Context) error { return nil }), middleware)
+
+	assert.EqualError(t, failing.HandleContext(context.Background()), "ncb is down")
+	require.NoError(t, succeeding.HandleContext(context.Background()), "a success resets the count")
+
+	assert.Error(t, failing.HandleContext(context.Background()))
+	assert.Error(t, failing.HandleContext(context.Background()))
+	release := assertReleased(t, succeeding.HandleContext(context.Background()))
+	assert.Equal(t, time.Minute, release.Delay)
+
+	server.FastForward(time.Minute)
+	require.NoError(t, succeeding.HandleContext(context.Background()), "jobs run again once the decay ended")
+
+	redisDown := Pipeline(contextHandlerFunc(func(ctx context.Context) error {
+		server.SetError("redis is down")
+		return nil
+	}), middleware)
+	assert.NoError(t, redisDown.HandleContext(context.Background()), "a job that succeeded is not failed by the count reset")
+	server.SetError("")
+}
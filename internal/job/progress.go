@@ -0,0 +1,49 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoProgress is returned by ReportProgress when the context was not given to a handler by a worker.
+var ErrNoProgress = errors.New("context does not report job progress")
+
+// Progress is the progress of a running job, as last reported by its handler.
+type Progress struct {
+	Percent   float64   `json:"percent"` // between 0 and 100
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx whose progress reports are passed to report, see ReportProgress.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+/*
+ReportProgress reports the progress of the job handled with ctx, the percentage is clamped between 0 and 100.
+Handlers may report as often as they like, the worker throttles the writes:
+
+	for i, row := range rows {
+		...
+		job.ReportProgress(ctx, float64(i+1)*100/float64(len(rows)), fmt.Sprintf("imported %d of %d rows", i+1, len(rows)))
+	}
+*/
+func ReportProgress(ctx context.Context, percent float64, message string) error {
+	report, ok := ctx.Value(progressKey{}).(func(Progress))
+	if !ok {
+		return ErrNoProgress
+	}
+
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	report(Progress{Percent: percent, Message: message, UpdatedAt: time.Now()})
+	return nil
+}
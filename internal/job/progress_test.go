@@ -0,0 +1,29 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportProgress(t *testing.T) {
+	assert.ErrorIs(t, ReportProgress(context.Background(), 50, "halfway"), ErrNoProgress)
+
+	var reported []Progress
+	ctx := WithProgress(context.Background(), func(progress Progress) {
+		reported = append(reported, progress)
+	})
+
+	require.NoError(t, ReportProgress(ctx, 50, "halfway"))
+	require.NoError(t, ReportProgress(ctx, -10, ""))
+	require.NoError(t, ReportProgress(ctx, 150, "done"))
+
+	require.Len(t, reported, 3)
+	assert.Equal(t, 50.0, reported[0].Percent)
+	assert.Equal(t, "halfway", reported[0].Message)
+	assert.False(t, reported[0].UpdatedAt.IsZero())
+	assert.Equal(t, 0.0, reported[1].Percent, "the percentage is clamped")
+	assert.Equal(t, 100.0, reported[2].Percent, "the percentage is clamped")
+}
@@ -0,0 +1,161 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownHandler is returned for a job whose handler is not registered.
+var ErrUnknownHandler = errors.New("handler is not registered")
+
+// Registration describes a registered handler.
+type Registration struct {
+	Name       string
+	Payload    string // the type the payload is decoded into
+	Middleware []Middleware
+	Encrypted  bool // the payload of its jobs is encrypted at rest, see EncryptedPayload
+	New        func() ContextJobHandler
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Registration)
+)
+
+/*
+Register registers the handler of the jobs with the given name, they receive their payload decoded into a T.
+Handlers register themselves from an init function next to their payload type:
+
+	type SyncUser struct {
+		UserID int `json:"user_id"`
+	}
+
+	func init() {
+		job.Register("SyncUser", func(ctx context.Context, payload SyncUser) error {
+			...
+		}, job.RateLimit(nil, "ncb", 10, time.Minute))
+	}
+
+Registering the same name twice panics, so a duplicate fails the application at startup.
+*/
+func Register[T any](name string, handle func(ctx context.Context, payload T) error, middleware ...Middleware) {
+	var payload T
+	register(Registration{
+		Name:       name,
+		Payload:    fmt.Sprintf("%T", payload),
+		Middleware: middleware,
+		New: func() ContextJobHandler {
+			return &typedHandler[T]{handle: handle}
+		},
+	})
+}
+
+// RegisterWithResult is Register for a handler that returns a result. The result is stored with the job
+// once it succeeded, and given to the next job of its chain as its input, see SetOutput.
+func RegisterWithResult[T any, R any](name string, handle func(ctx context.Context, payload T) (R, error), middleware ...Middleware) {
+	Register(name, func(ctx context.Context, payload T) error {
+		result, err := handle(ctx, payload)
+		if err != nil {
+			return err
+		}
+		return SetOutput(ctx, result)
+	}, middleware...)
+}
+
+// RegisterHandler registers a handler constructor, whose handlers decode their payload into themselves.
+// Use Adapt to register a JobHandler.
+func RegisterHandler(name string, newHandler func() ContextJobHandler, middleware ...Middleware) {
+	register(Registration{
+		Name:       name,
+		Payload:    fmt.Sprintf("%T", Unwrap(newHandler())),
+		Middleware: middleware,
+		New:        newHandler,
+	})
+}
+
+func register(registration Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registration.Name == "" {
+		panic("job: handler name must not be empty")
+	}
+	if _, ok := registry[registration.Name]; ok {
+		panic(fmt.Sprintf("job: handler %q is registered twice", registration.Name))
+	}
+
+	registration.Encrypted = handlerEncrypts(registration.New())
+
+	if len(registration.Middleware) > 0 {
+		registration.New = WithMiddleware(registration.New, registration.Middleware...)
+	}
+	registry[registration.Name] = registration
+}
+
+// Registrations returns the registered handlers sorted by name.
+func Registrations() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registrations := make([]Registration, 0, len(registry))
+	for _, registration := range registry {
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+
+	return registrations
+}
+
+// IsRegistered reports whether a handler is registered under the name.
+func IsRegistered(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	_, ok := registry[name]
+	return ok
+}
+
+// CheckRegistered returns ErrUnknownHandler if the handler of the job, or of a job of its chain, is not registered.
+func CheckRegistered(j *Job) error {
+	if !IsRegistered(j.HandlerName) {
+		return fmt.Errorf("%w: %s", ErrUnknownHandler, j.HandlerName)
+	}
+
+	for _, next := range j.Chain {
+		if err := CheckRegistered(next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// payloadHandler is implemented by the handlers registered with Register.
+type payloadHandler interface {
+	payloadPointer() any
+}
+
+// typedHandler decodes the job payload into a T and passes it to the registered function.
+type typedHandler[T any] struct {
+	payload T
+	handle  func(ctx context.Context, payload T) error
+}
+
+func (h *typedHandler[T]) HandleContext(ctx context.Context) error {
+	return h.handle(ctx, h.payload)
+}
+
+// UnmarshalJSON decodes the job payload.
+func (h *typedHandler[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.payload)
+}
+
+func (h *typedHandler[T]) payloadPointer() any {
+	return &h.payload
+}
@@ -0,0 +1,87 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncUser struct {
+	UserID int `json:"user_id"`
+}
+
+func TestRegister(t *testing.T) {
+	var synced []int
+	Register("TestRegister.SyncUser", func(ctx context.Context, payload syncUser) error {
+		synced = append(synced, payload.UserID)
+		return nil
+	})
+
+	handler := NewHandlerMap()["TestRegister.SyncUser"]()
+	require.NoError(t, json.Unmarshal([]byte(`{"user_id":42}`), handler))
+	assert.Equal(t, 42, Unwrap(handler).(*syncUser).UserID, "Unwrap returns the typed payload")
+
+	require.NoError(t, handler.HandleContext(context.Background()))
+	assert.Equal(t, []int{42}, synced)
+
+	assert.Panics(t, func() {
+		Register("TestRegister.SyncUser", func(ctx context.Context, payload syncUser) error { return nil })
+	}, "a duplicate name is rejected")
+}
+
+func TestRegistrations(t *testing.T) {
+	RegisterHandler("TestRegistrations.Example", Adapt(func() JobHandler { return new(ProcessExample) }), Skip(nil))
+
+	var found bool
+	for _, registration := range Registrations() {
+		if registration.Name == "TestRegistrations.Example" {
+			found = true
+			assert.Equal(t, "*job.ProcessExample", registration.Payload)
+			assert.Len(t, registration.Middleware, 1)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestCheckRegistered(t *testing.T) {
+	known, _ := NewJob("ProcessExample", nil, 1, 0)
+	unknown, _ := NewJob("DoesNotExist", nil, 1, 0)
+
+	assert.NoError(t, CheckRegistered(known))
+	assert.ErrorIs(t, CheckRegistered(unknown), ErrUnknownHandler)
+
+	chain, err := NewChain(known, unknown)
+	require.NoError(t, err)
+	assert.ErrorIs(t, CheckRegistered(chain), ErrUnknownHandler, "every job of the chain must be registered")
+}
+
+func TestRegisterWithResult(t *testing.T) {
+	RegisterWithResult("TestRegisterWithResult.SyncUser", func(ctx context.Context, payload syncUser) (map[string]int, error) {
+		return map[string]int{"synced": payload.UserID}, nil
+	})
+
+	handler := NewHandlerMap()["TestRegisterWithResult.SyncUser"]()
+	require.NoError(t, json.Unmarshal([]byte(`{"user_id":42}`), handler))
+
+	ctx := WithOutput(context.Background())
+	require.NoError(t, handler.HandleContext(ctx))
+	assert.JSONEq(t, `{"synced":42}`, string(OutputFromContext(ctx)))
+}
+
+func TestHandlerMapDecode(t *testing.T) {
+	Register("TestHandlerMapDecode.SyncUser", func(ctx context.Context, payload syncUser) error { return nil })
+	handlerMap := NewHandlerMap()
+
+	handler, err := handlerMap.Decode("TestHandlerMapDecode.SyncUser", json.RawMessage(`{"user_id":7}`))
+	require.NoError(t, err)
+	assert.Equal(t, 7, Unwrap(handler).(*syncUser).UserID)
+
+	_, err = handlerMap.Decode("TestHandlerMapDecode.SyncUser", json.RawMessage(`{"user_id":"seven"}`))
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+
+	_, err = handlerMap.Decode("DoesNotExist", json.RawMessage(`{}`))
+	assert.ErrorIs(t, err, ErrUnknownHandler)
+}
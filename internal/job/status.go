@@ -5,4 +5,18 @@ const (
 	StatusProcessing = "processing" // StatusProcessing is the status of a job that is currently being processed.
 	StatusCompleted  = "completed"  // StatusCompleted is the status of a job that has been successfully processed.
 	StatusFailed     = "failed"     // StatusFailed is the status of a job that has failed to be processed.
+	StatusCancelled  = "cancelled"  // StatusCancelled is the status of a job that was cancelled before it finished.
+)
+
+const (
+	FailureReasonError        = "error"         // FailureReasonError is the failure reason of a job whose handler returned an error.
+	FailureReasonTimeout      = "timeout"       // FailureReasonTimeout is the failure reason of a job that exceeded its timeout.
+	FailureReasonLeaseExpired = "lease_expired" // FailureReasonLeaseExpired is the failure reason of a job abandoned by its worker.
+)
+
+const (
+	AttemptCompleted = "completed" // AttemptCompleted is the status of an attempt whose handler succeeded.
+	AttemptFailed    = "failed"    // AttemptFailed is the status of an attempt whose handler returned an error.
+	AttemptReleased  = "released"  // AttemptReleased is the status of an attempt that put the job back to the queue, see Release.
+	AttemptCancelled = "cancelled" // AttemptCancelled is the status of an attempt stopped by the cancellation of its job.
 )
@@ -0,0 +1,52 @@
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	UniqueUntilProcessing = "processing" // UniqueUntilProcessing holds the lock of a unique job until a worker takes it.
+	UniqueUntilCompleted  = "completed"  // UniqueUntilCompleted holds the lock of a unique job until it succeeds or fails for good.
+	UniqueUntilExpired    = "expired"    // UniqueUntilExpired holds the lock of a unique job for as long as it was asked for, e.g. for an idempotency key.
+)
+
+// DefaultUniqueFor is how long the lock of a unique job is held at most when UniqueFor is not set.
+const DefaultUniqueFor = time.Hour
+
+// Unique makes the job unique: enqueueing it while another job holds the same lock does not add it
+// to the queue, and sets its ID to the ID of that job instead.
+// An empty key locks on the handler name and the payload, lockFor bounds how long the lock is held.
+func (j *Job) Unique(key string, until string, lockFor time.Duration) *Job {
+	j.UniqueKey = key
+	j.UniqueUntil = until
+	j.UniqueFor = int(lockFor / time.Second)
+	return j
+}
+
+// IsUnique reports whether the job was made unique with Unique.
+func (j *Job) IsUnique() bool {
+	return j.UniqueUntil != ""
+}
+
+// UniqueLockKey returns the key the job locks on, see Unique.
+func (j *Job) UniqueLockKey() string {
+	if j.UniqueKey != "" {
+		return j.UniqueKey
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(j.HandlerName))
+	hash.Write([]byte{0})
+	hash.Write(j.Payload)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// UniqueLockTTL returns how long the lock of the job is held at most.
+func (j *Job) UniqueLockTTL() time.Duration {
+	if j.UniqueFor > 0 {
+		return time.Duration(j.UniqueFor) * time.Second
+	}
+	return DefaultUniqueFor
+}
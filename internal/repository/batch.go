@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+)
+
+// ErrBatchNotFound is returned for a batch that does not exist.
+var ErrBatchNotFound = errors.New("batch not found")
+
+type BatchRepository interface {
+	AddBatch(ctx context.Context, batch model.Batch) error
+	GetBatch(ctx context.Context, batchID uuid.UUID) (model.Batch, error)
+	// RecordBatchJob counts a job of the batch that succeeded, or failed for good, and returns the updated batch.
+	// It returns ErrBatchNotFound if the batch does not exist or has no pending jobs left.
+	RecordBatchJob(ctx context.Context, batchID uuid.UUID, jobID uuid.UUID, failed bool) (model.Batch, error)
+	// CancelBatch cancels an unfinished batch, it reports false if the batch was already finished or canceled.
+	CancelBatch(ctx context.Context, batchID uuid.UUID) (bool, error)
+}
+
+type BatchRepositoryImpl struct {
+	pgxPool *pgxpool.Pool
+}
+
+func NewBatchRepository(pgxPool *pgxpool.Pool) BatchRepository {
+	return &BatchRepositoryImpl{
+		pgxPool: pgxPool,
+	}
+}
+
+const batchColumns = `id, name, queue, total_jobs, pending_jobs, failed_jobs, failed_job_ids, then_job, catch_job, finally_job, created_at, cancelled_at, finished_at`
+
+func scanBatch(row pgx.Row) (model.Batch, error) {
+	var batch model.Batch
+	err := row.Scan(&batch.ID, &batch.Name, &batch.Queue, &batch.TotalJobs, &batch.PendingJobs, &batch.FailedJobs, &batch.FailedJobIDs,
+		&batch.ThenJob, &batch.CatchJob, &batch.FinallyJob, &batch.CreatedAt, &batch.CancelledAt, &batch.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Batch{}, ErrBatchNotFound
+	}
+
+	return batch, err
+}
+
+func (b *BatchRepositoryImpl) AddBatch(ctx context.Context, batch model.Batch) error {
+	_, err := b.pgxPool.Exec(ctx, `
+		INSERT INTO job_batches (id, name, queue, total_jobs, pending_jobs, failed_jobs, then_job, catch_job, finally_job, created_at)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $7, $8, $9)
+	`, batch.ID, batch.Name, batch.Queue, batch.TotalJobs, batch.PendingJobs, batch.ThenJob, batch.CatchJob, batch.FinallyJob, batch.CreatedAt)
+
+	return err
+}
+
+func (b *BatchRepositoryImpl) GetBatch(ctx context.Context, batchID uuid.UUID) (model.Batch, error) {
+	return scanBatch(b.pgxPool.QueryRow(ctx, `SELECT `+batchColumns+` FROM job_batches WHERE id = $1`, batchID))
+}
+
+func (b *BatchRepositoryImpl) RecordBatchJob(ctx context.Context, batchID uuid.UUID, jobID uuid.UUID, failed bool) (model.Batch, error) {
+	// The row lock taken by the update makes the counts exact when many workers settle jobs of the batch at once,
+	// so exactly one caller sees the batch finish
+	return scanBatch(b.pgxPool.QueryRow(ctx, `
+		UPDATE job_batches SET
+			pending_jobs = pending_jobs - 1,
+			failed_jobs = failed_jobs + CASE WHEN $2 THEN 1 ELSE 0 END,
+			failed_job_ids = CASE WHEN $2 THEN failed_job_ids || to_jsonb($3::TEXT) ELSE failed_job_ids END,
+			finished_at = CASE WHEN pending_jobs = 1 THEN NOW() ELSE finished_at END
+		WHERE id = $1 AND pending_jobs > 0
+		RETURNING `+batchColumns, batchID, failed, jobID))
+}
+
+func (b *BatchRepositoryImpl) CancelBatch(ctx context.Context, batchID uuid.UUID) (bool, error) {
+	tag, err := b.pgxPool.Exec(ctx, `
+		UPDATE job_batches SET cancelled_at = NOW() WHERE id = $1 AND cancelled_at IS NULL AND finished_at IS NULL
+	`, batchID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,8 +11,12 @@ import (
 	"github.com/kondohiroki/go-boilerplate/internal/db/model"
 )
 
+// ErrJobNotFound is returned for a job that does not exist.
+var ErrJobNotFound = errors.New("job not found")
+
 type JobRepository interface {
 	AddJob(ctx context.Context, job model.Job) (jobID uuid.UUID, err error)
+	AddJobs(ctx context.Context, jobs ...model.Job) error
 	AddFailedJob(ctx context.Context, job model.FaildJob) (failedJobID int, err error)
 	UpdateJobStatus(ctx context.Context, jobID uuid.UUID, status string) error
 	ResetProcessingJobsToPending(ctx context.Context) error
@@ -19,6 +24,16 @@ type JobRepository interface {
 	GetUnfinishedJobs(ctx context.Context) ([]model.Job, error)
 	GetFailedJobs(ctx context.Context) ([]model.FaildJob, error)
 	RemoveFailedJob(ctx context.Context, jobID uuid.UUID) error
+	GetJobByID(ctx context.Context, jobID uuid.UUID) (model.Job, error)
+	SetJobResult(ctx context.Context, jobID uuid.UUID, result []byte) error
+	SetJobProgress(ctx context.Context, jobID uuid.UUID, percent float64, message string, updatedAt time.Time) error
+	GetJobProgress(ctx context.Context, jobID uuid.UUID) (model.JobProgress, error)
+	AddJobAttempt(ctx context.Context, attempt model.JobAttempt) (attemptID int, err error)
+	GetJobAttempts(ctx context.Context, jobID uuid.UUID) ([]model.JobAttempt, error)
+	CountFinishedAttempts(ctx context.Context, queue string, since time.Time) (int64, error)
+	GetFinishedJobQueues(ctx context.Context) ([]string, error)
+	CountPrunableJobs(ctx context.Context, queue string, status string, before time.Time) (int64, error)
+	PruneJobs(ctx context.Context, queue string, status string, before time.Time, limit int, archive bool) (int64, error)
 }
 
 type JobRepositoryImpl struct {
@@ -39,10 +54,10 @@ func (j *JobRepositoryImpl) AddJob(ctx context.Context, job model.Job) (jobID uu
 	defer tx.Rollback(ctx)
 
 	err = tx.QueryRow(ctx, `
-		INSERT INTO jobs (id, queue, handler_name, payload, max_attempts, delay, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO jobs (id, queue, handler_name, payload, max_attempts, delay, timeout, backoff, priority, unique_key, unique_until, unique_for, chain, input, batch_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		RETURNING id
-	`, job.ID, job.Queue, job.HandlerName, job.Payload, job.MaxAttempts, job.Delay, job.Status, job.CreatedAt, job.UpdatedAt).Scan(&jobID)
+	`, job.ID, job.Queue, job.HandlerName, job.Payload, job.MaxAttempts, job.Delay, job.Timeout, job.Backoff, job.Priority, job.UniqueKey, job.UniqueUntil, job.UniqueFor, job.Chain, job.Input, job.BatchID, job.Status, job.CreatedAt, job.UpdatedAt).Scan(&jobID)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -55,6 +70,29 @@ func (j *JobRepositoryImpl) AddJob(ctx context.Context, job model.Job) (jobID uu
 	return jobID, nil
 }
 
+// AddJobs inserts the jobs in a single batch, either all of them are added or none.
+func (j *JobRepositoryImpl) AddJobs(ctx context.Context, jobs ...model.Job) error {
+	batch := &pgx.Batch{}
+	for _, job := range jobs {
+		batch.Queue(`
+			INSERT INTO jobs (id, queue, handler_name, payload, max_attempts, delay, timeout, backoff, priority, unique_key, unique_until, unique_for, chain, input, batch_id, status, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		`, job.ID, job.Queue, job.HandlerName, job.Payload, job.MaxAttempts, job.Delay, job.Timeout, job.Backoff, job.Priority, job.UniqueKey, job.UniqueUntil, job.UniqueFor, job.Chain, job.Input, job.BatchID, job.Status, job.CreatedAt, job.UpdatedAt)
+	}
+
+	tx, err := j.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 func (j *JobRepositoryImpl) AddFailedJob(ctx context.Context, job model.FaildJob) (failedJobID int, err error) {
 	tx, err := j.pgxPool.Begin(ctx)
 	if err != nil {
@@ -63,10 +101,10 @@ func (j *JobRepositoryImpl) AddFailedJob(ctx context.Context, job model.FaildJob
 	defer tx.Rollback(ctx)
 
 	err = tx.QueryRow(ctx, `
-		INSERT INTO failed_jobs (job_id, queue, payload, error, failed_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO failed_jobs (job_id, queue, payload, error, reason, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
-	`, job.JobID, job.Queue, job.Payload, job.Error, job.FailedAt).Scan(&failedJobID)
+	`, job.JobID, job.Queue, job.Payload, job.Error, job.Reason, job.FailedAt).Scan(&failedJobID)
 	if err != nil {
 		return failedJobID, err
 	}
@@ -127,7 +165,7 @@ func handleSelectJob(rows pgx.Rows) ([]model.Job, error) {
 	var jobs []model.Job
 	for rows.Next() {
 		var job model.Job
-		err := rows.Scan(&job.ID, &job.Queue, &job.HandlerName, &job.Payload, &job.MaxAttempts, &job.Delay, &job.Status, &job.CreatedAt, &job.UpdatedAt)
+		err := rows.Scan(&job.ID, &job.Queue, &job.HandlerName, &job.Payload, &job.MaxAttempts, &job.Delay, &job.Timeout, &job.Backoff, &job.Priority, &job.UniqueKey, &job.UniqueUntil, &job.UniqueFor, &job.Chain, &job.Input, &job.BatchID, &job.Result, &job.Status, &job.CreatedAt, &job.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -139,7 +177,7 @@ func handleSelectJob(rows pgx.Rows) ([]model.Job, error) {
 
 func (j *JobRepositoryImpl) GetJobs(ctx context.Context) ([]model.Job, error) {
 	rows, err := j.pgxPool.Query(ctx, `
-		SELECT id, queue, handler_name, payload, max_attempts, delay, status, created_at, updated_at FROM jobs
+		SELECT id, queue, handler_name, payload, max_attempts, delay, timeout, backoff, priority, COALESCE(unique_key, ''), COALESCE(unique_until, ''), COALESCE(unique_for, 0), chain, input, batch_id, result, status, created_at, updated_at FROM jobs
 	`)
 	if err != nil {
 		return nil, err
@@ -153,7 +191,7 @@ func (j *JobRepositoryImpl) GetJobs(ctx context.Context) ([]model.Job, error) {
 
 func (j *JobRepositoryImpl) GetUnfinishedJobs(ctx context.Context) ([]model.Job, error) {
 	rows, err := j.pgxPool.Query(ctx, `
-		SELECT id, queue, handler_name, payload, max_attempts, delay, status, created_at, updated_at FROM jobs WHERE status != 'completed' ORDER BY created_at ASC
+		SELECT id, queue, handler_name, payload, max_attempts, delay, timeout, backoff, priority, COALESCE(unique_key, ''), COALESCE(unique_until, ''), COALESCE(unique_for, 0), chain, input, batch_id, result, status, created_at, updated_at FROM jobs WHERE status NOT IN ('completed', 'cancelled') ORDER BY created_at ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -167,7 +205,7 @@ func (j *JobRepositoryImpl) GetUnfinishedJobs(ctx context.Context) ([]model.Job,
 
 func (j *JobRepositoryImpl) GetFailedJobs(ctx context.Context) ([]model.FaildJob, error) {
 	rows, err := j.pgxPool.Query(ctx, `
-		SELECT id, job_id, queue, payload, error, failed_at FROM failed_jobs ORDER BY failed_at ASC
+		SELECT id, job_id, queue, payload, error, reason, failed_at FROM failed_jobs ORDER BY failed_at ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -177,7 +215,7 @@ func (j *JobRepositoryImpl) GetFailedJobs(ctx context.Context) ([]model.FaildJob
 	var jobs []model.FaildJob
 	for rows.Next() {
 		var job model.FaildJob
-		err := rows.Scan(&job.ID, &job.JobID, &job.Queue, &job.Payload, &job.Error, &job.FailedAt)
+		err := rows.Scan(&job.ID, &job.JobID, &job.Queue, &job.Payload, &job.Error, &job.Reason, &job.FailedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -208,3 +246,170 @@ func (j *JobRepositoryImpl) RemoveFailedJob(ctx context.Context, jobID uuid.UUID
 
 	return nil
 }
+
+func (j *JobRepositoryImpl) GetJobByID(ctx context.Context, jobID uuid.UUID) (model.Job, error) {
+	rows, err := j.pgxPool.Query(ctx, `
+		SELECT id, queue, handler_name, payload, max_attempts, delay, timeout, backoff, priority, COALESCE(unique_key, ''), COALESCE(unique_until, ''), COALESCE(unique_for, 0), chain, input, batch_id, result, status, created_at, updated_at FROM jobs WHERE id = $1
+	`, jobID)
+	if err != nil {
+		return model.Job{}, err
+	}
+	defer rows.Close()
+
+	jobs, err := handleSelectJob(rows)
+	if err != nil {
+		return model.Job{}, err
+	}
+
+	if len(jobs) == 0 {
+		return model.Job{}, ErrJobNotFound
+	}
+
+	return jobs[0], nil
+}
+
+func (j *JobRepositoryImpl) SetJobResult(ctx context.Context, jobID uuid.UUID, result []byte) error {
+	_, err := j.pgxPool.Exec(ctx, `
+		UPDATE jobs SET result = $1, updated_at = $2 WHERE id = $3
+	`, result, time.Now(), jobID)
+
+	return err
+}
+
+func (j *JobRepositoryImpl) SetJobProgress(ctx context.Context, jobID uuid.UUID, percent float64, message string, updatedAt time.Time) error {
+	_, err := j.pgxPool.Exec(ctx, `
+		UPDATE jobs SET progress = $1, progress_message = $2, progress_updated_at = $3 WHERE id = $4
+	`, percent, message, updatedAt, jobID)
+
+	return err
+}
+
+func (j *JobRepositoryImpl) GetJobProgress(ctx context.Context, jobID uuid.UUID) (model.JobProgress, error) {
+	progress := model.JobProgress{JobID: jobID}
+	err := j.pgxPool.QueryRow(ctx, `
+		SELECT COALESCE(progress, 0), COALESCE(progress_message, ''), progress_updated_at FROM jobs WHERE id = $1
+	`, jobID).Scan(&progress.Percent, &progress.Message, &progress.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.JobProgress{}, ErrJobNotFound
+	}
+
+	return progress, err
+}
+
+func (j *JobRepositoryImpl) AddJobAttempt(ctx context.Context, attempt model.JobAttempt) (attemptID int, err error) {
+	err = j.pgxPool.QueryRow(ctx, `
+		INSERT INTO job_attempts (job_id, queue, attempt, worker, status, error, stack_trace, started_at, finished_at, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`, attempt.JobID, attempt.Queue, attempt.Attempt, attempt.Worker, attempt.Status, attempt.Error, attempt.StackTrace, attempt.StartedAt, attempt.FinishedAt, attempt.DurationMs).Scan(&attemptID)
+
+	return attemptID, err
+}
+
+func (j *JobRepositoryImpl) GetJobAttempts(ctx context.Context, jobID uuid.UUID) ([]model.JobAttempt, error) {
+	rows, err := j.pgxPool.Query(ctx, `
+		SELECT id, job_id, queue, attempt, worker, status, COALESCE(error, ''), COALESCE(stack_trace, ''), started_at, finished_at, duration_ms FROM job_attempts WHERE job_id = $1 ORDER BY id ASC
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []model.JobAttempt
+	for rows.Next() {
+		var attempt model.JobAttempt
+		err := rows.Scan(&attempt.ID, &attempt.JobID, &attempt.Queue, &attempt.Attempt, &attempt.Worker, &attempt.Status, &attempt.Error, &attempt.StackTrace, &attempt.StartedAt, &attempt.FinishedAt, &attempt.DurationMs)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// CountFinishedAttempts counts the attempts of the jobs of a queue that completed or failed since the given time.
+func (j *JobRepositoryImpl) CountFinishedAttempts(ctx context.Context, queue string, since time.Time) (int64, error) {
+	var count int64
+	err := j.pgxPool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM job_attempts WHERE queue = $1 AND finished_at >= $2 AND status IN ('completed', 'failed')
+	`, queue, since).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetFinishedJobQueues returns the queues that have completed, failed or cancelled jobs.
+func (j *JobRepositoryImpl) GetFinishedJobQueues(ctx context.Context) ([]string, error) {
+	rows, err := j.pgxPool.Query(ctx, `
+		SELECT DISTINCT queue FROM jobs WHERE status IN ('completed', 'failed', 'cancelled') ORDER BY queue
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queues []string
+	for rows.Next() {
+		var queue string
+		if err := rows.Scan(&queue); err != nil {
+			return nil, err
+		}
+		queues = append(queues, queue)
+	}
+
+	return queues, rows.Err()
+}
+
+// CountPrunableJobs counts the jobs of a queue with the given status that were last updated before the given time.
+func (j *JobRepositoryImpl) CountPrunableJobs(ctx context.Context, queue string, status string, before time.Time) (int64, error) {
+	var count int64
+	err := j.pgxPool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM jobs WHERE queue = $1 AND status = $2 AND updated_at < $3
+	`, queue, status, before).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// PruneJobs deletes up to limit jobs of a queue with the given status that were last updated before the given time,
+// along with their failed_jobs rows and attempts, and returns how many were deleted. When archive is set they are
+// copied to archived_jobs first. Jobs locked by another transaction are skipped, so concurrent prunes do not wait on each other.
+func (j *JobRepositoryImpl) PruneJobs(ctx context.Context, queue string, status string, before time.Time, limit int, archive bool) (int64, error) {
+	archiveStatement := ""
+	if archive {
+		archiveStatement = `, archived AS (
+			INSERT INTO archived_jobs (job_id, queue, status, job, failed_job, attempts, archived_at)
+			SELECT deleted.id, deleted.queue, deleted.status, to_jsonb(deleted),
+				(SELECT to_jsonb(deleted_failed_jobs) FROM deleted_failed_jobs WHERE deleted_failed_jobs.job_id = deleted.id LIMIT 1),
+				(SELECT jsonb_agg(to_jsonb(deleted_attempts) ORDER BY deleted_attempts.id) FROM deleted_attempts WHERE deleted_attempts.job_id = deleted.id),
+				NOW()
+			FROM deleted
+			ON CONFLICT (job_id) DO NOTHING
+		)`
+	}
+
+	var count int64
+	err := j.pgxPool.QueryRow(ctx, `
+		WITH pruned AS (
+			SELECT id FROM jobs WHERE queue = $1 AND status = $2 AND updated_at < $3
+			ORDER BY updated_at LIMIT $4 FOR UPDATE SKIP LOCKED
+		), deleted AS (
+			DELETE FROM jobs WHERE id IN (SELECT id FROM pruned) RETURNING *
+		), deleted_failed_jobs AS (
+			DELETE FROM failed_jobs WHERE job_id IN (SELECT id FROM deleted) RETURNING *
+		), deleted_attempts AS (
+			DELETE FROM job_attempts WHERE job_id IN (SELECT id FROM deleted) RETURNING *
+		)`+archiveStatement+`
+		SELECT COUNT(*) FROM deleted
+	`, queue, status, before, limit).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
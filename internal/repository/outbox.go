@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+)
+
+// OutboxRetryDelay is how long the relay waits before publishing again a job it failed to publish,
+// multiplied by the number of failed attempts up to MaxOutboxRetryDelay.
+const (
+	OutboxRetryDelay    = time.Second
+	MaxOutboxRetryDelay = time.Minute
+)
+
+type OutboxRepository interface {
+	// AddOutboxJobs adds jobs to the outbox within the transaction of the caller, so they exist only if it commits.
+	AddOutboxJobs(ctx context.Context, tx pgx.Tx, jobs ...model.OutboxJob) error
+	// RelayOutboxJobs takes up to limit jobs of the outbox that are available, in the order they were added,
+	// and gives them to publish. The jobs published without error are deleted from the outbox, the others are
+	// retried later, all in one transaction whose row locks make concurrent relays skip the jobs being relayed.
+	// It returns the number of jobs given to publish.
+	RelayOutboxJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []model.OutboxJob) []error) (int, error)
+	// CountOutboxJobs counts the jobs waiting in the outbox.
+	CountOutboxJobs(ctx context.Context) (int64, error)
+}
+
+type OutboxRepositoryImpl struct {
+	pgxPool *pgxpool.Pool
+}
+
+func NewOutboxRepository(pgxPool *pgxpool.Pool) OutboxRepository {
+	return &OutboxRepositoryImpl{
+		pgxPool: pgxPool,
+	}
+}
+
+func (o *OutboxRepositoryImpl) AddOutboxJobs(ctx context.Context, tx pgx.Tx, jobs ...model.OutboxJob) error {
+	batch := &pgx.Batch{}
+	for _, job := range jobs {
+		batch.Queue(`
+			INSERT INTO job_outbox (queue, job, created_at) VALUES ($1, $2, $3)
+		`, job.Queue, job.Job, job.CreatedAt)
+	}
+
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+func (o *OutboxRepositoryImpl) RelayOutboxJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []model.OutboxJob) []error) (int, error) {
+	tx, err := o.pgxPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, queue, job, attempts, COALESCE(last_error, ''), available_at, created_at FROM job_outbox
+		WHERE available_at <= NOW()
+		ORDER BY id LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var jobs []model.OutboxJob
+	for rows.Next() {
+		var job model.OutboxJob
+		if err := rows.Scan(&job.ID, &job.Queue, &job.Job, &job.Attempts, &job.LastError, &job.AvailableAt, &job.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	errs := publish(ctx, jobs)
+
+	var published []int64
+	batch := &pgx.Batch{}
+	for i, job := range jobs {
+		if errs[i] == nil {
+			published = append(published, job.ID)
+			continue
+		}
+
+		retryDelay := OutboxRetryDelay * time.Duration(job.Attempts+1)
+		if retryDelay > MaxOutboxRetryDelay {
+			retryDelay = MaxOutboxRetryDelay
+		}
+		batch.Queue(`
+			UPDATE job_outbox SET attempts = attempts + 1, last_error = $2, available_at = $3 WHERE id = $1
+		`, job.ID, errs[i].Error(), time.Now().Add(retryDelay))
+	}
+	if len(published) > 0 {
+		batch.Queue(`DELETE FROM job_outbox WHERE id = ANY($1)`, published)
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, err
+	}
+
+	return len(jobs), tx.Commit(ctx)
+}
+
+func (o *OutboxRepositoryImpl) CountOutboxJobs(ctx context.Context) (int64, error) {
+	var count int64
+	err := o.pgxPool.QueryRow(ctx, `SELECT COUNT(*) FROM job_outbox`).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
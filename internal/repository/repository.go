@@ -6,8 +6,10 @@ import (
 )
 
 type Repository struct {
-	User UserRepository
-	Job  JobRepository
+	User   UserRepository
+	Job    JobRepository
+	Batch  BatchRepository
+	Outbox OutboxRepository
 }
 
 func NewRepository() *Repository {
@@ -15,7 +17,9 @@ func NewRepository() *Repository {
 	redisClient := rdb.GetRedisClient()
 
 	return &Repository{
-		User: NewUserRepository(pgxPool, redisClient),
-		Job:  NewJobRepository(pgxPool),
+		User:   NewUserRepository(pgxPool, redisClient),
+		Job:    NewJobRepository(pgxPool),
+		Batch:  NewBatchRepository(pgxPool),
+		Outbox: NewOutboxRepository(pgxPool),
 	}
 }
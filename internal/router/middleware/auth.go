@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/pkg/exception"
+)
+
+// AdminAuth only lets through requests carrying one of the configured admin tokens
+// in an "Authorization: Bearer <token>" header, every request is rejected if none is configured.
+func AdminAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || token == "" {
+			return exception.UnauthorizedError
+		}
+
+		for _, adminToken := range config.GetConfig().HttpServer.AdminTokens {
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+				return c.Next()
+			}
+		}
+
+		return exception.UnauthorizedError
+	}
+}
@@ -1,12 +1,17 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/go-co-op/gocron"
 	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
+	"github.com/kondohiroki/go-boilerplate/internal/logger"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+	"go.uber.org/zap"
 )
 
 var Timezone = time.Now().Location()
@@ -44,6 +49,12 @@ func Start() {
 					fmt.Printf("\nNext run: %s / %s\n", task.NextRun().UTC().String(), task.NextRun().In(asiaBangkok).String())
 
 				})
+			case "QueuePrune":
+				_, err := s.CronWithSeconds(schedule.Cron).Do(pruneQueues)
+				if err != nil {
+					fmt.Printf("Failed to schedule QueuePrune job: %v", err)
+					continue
+				}
 			}
 		}
 	}
@@ -55,3 +66,15 @@ func Start() {
 	s.StartImmediately()
 	s.StartBlocking()
 }
+
+// pruneQueues deletes the finished jobs older than the retention of the queue.prune config, see queue:prune.
+func pruneQueues() {
+	opts := queue.PruneOptionsFromConfig(config.GetConfig().Queue.Prune)
+	results, err := queue.Prune(context.Background(), repository.NewRepository().Job, opts)
+	for _, result := range results {
+		logger.Log.Info("Pruned jobs", zap.String("queue", result.Queue), zap.String("status", result.Status), zap.Int64("count", result.Count))
+	}
+	if err != nil {
+		logger.Log.Error("Queue prune failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,8 @@
+package color
+
+import "fmt"
+
+// Sprint returns s wrapped in the escape codes printing it in the given color on a true color terminal.
+func Sprint(color int, s string) string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm%s\x1b[0m", color>>16&0xFF, color>>8&0xFF, color&0xFF, s)
+}
@@ -77,6 +77,24 @@ var (
 		SUBCODE_USER_EMAIL_ALREADY_TAKEN,
 		"user email already taken",
 	)
+	UnknownJobHandlerError *ExceptionErrors = createFixedExceptionErrors(
+		http.StatusUnprocessableEntity,
+		ERROR_TYPE_VALIDATION_ERROR,
+		SUBCODE_UNKNOWN_JOB_HANDLER,
+		"job handler is not registered",
+	)
+	InvalidJobPayloadError *ExceptionErrors = createFixedExceptionErrors(
+		http.StatusUnprocessableEntity,
+		ERROR_TYPE_VALIDATION_ERROR,
+		SUBCODE_INVALID_JOB_PAYLOAD,
+		"job payload does not match its handler",
+	)
+	JobAlreadyFinishedError *ExceptionErrors = createFixedExceptionErrors(
+		http.StatusUnprocessableEntity,
+		ERROR_TYPE_VALIDATION_ERROR,
+		SUBCODE_JOB_ALREADY_FINISHED,
+		"job is already finished",
+	)
 
 	// JobError
 	BackgroundJobFailedError *ExceptionErrors = createFixedExceptionErrors(
@@ -22,6 +22,9 @@ var (
 	SUBCODE_INVALID_FIELD_VALUE_FORMAT     errorSubcode = newErrorSubcode(762)
 	SUBCODE_NUM_MULTIPLE_VALUES_ERROR      errorSubcode = newErrorSubcode(763)
 	SUBCODE_RESPONSE_FIELD_NOT_FOUND_ERROR errorSubcode = newErrorSubcode(764)
+	SUBCODE_UNKNOWN_JOB_HANDLER            errorSubcode = newErrorSubcode(765)
+	SUBCODE_INVALID_JOB_PAYLOAD            errorSubcode = newErrorSubcode(766)
+	SUBCODE_JOB_ALREADY_FINISHED           errorSubcode = newErrorSubcode(767)
 
 	// 8xx server errors
 	SUBCODE_UNKNOWN_ERROR          errorSubcode = newErrorSubcode(800)
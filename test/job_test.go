@@ -0,0 +1,222 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kondohiroki/go-boilerplate/config"
+	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
+	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
+	"github.com/kondohiroki/go-boilerplate/internal/job"
+	"github.com/kondohiroki/go-boilerplate/internal/repository"
+)
+
+func TestGetJobByID(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+
+	j, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "Hello World"}, 1, 0)
+
+	testJobQueue := queue.NewQueue("test_get_job")
+	require.NoError(t, testJobQueue.Enqueue(ctx, j))
+
+	t.Cleanup(func() {
+		testJobQueue.Clear(ctx)
+	})
+
+	startedAt := time.Now()
+	_, err := repo.Job.AddJobAttempt(ctx, model.JobAttempt{
+		JobID:      j.ID,
+		Queue:      "test_get_job",
+		Attempt:    1,
+		Worker:     queue.NewConsumerName(0),
+		Status:     job.AttemptCompleted,
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(time.Second),
+		DurationMs: 1000,
+	})
+	require.NoError(t, err)
+	require.NoError(t, repo.Job.SetJobResult(ctx, j.ID, []byte(`{"greeted":true}`)))
+	require.NoError(t, repo.Job.SetJobProgress(ctx, j.ID, 40, "greeted 4 of 10 worlds", time.Now()))
+
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedSchema     string
+		expectedCode       int
+		expectedMessage    string
+	}{
+		{
+			name:               "test get job by id",
+			id:                 j.ID.String(),
+			expectedStatusCode: http.StatusOK,
+			expectedSchema:     readJSONToString(t, "json_response_schema/get_job.json"),
+			expectedCode:       0,
+			expectedMessage:    "OK",
+		},
+		{
+			name:               "test get job with an unknown id",
+			id:                 uuid.NewString(),
+			expectedStatusCode: http.StatusNotFound,
+			expectedSchema:     readJSONToString(t, "json_response_schema/misc_not_found.json"),
+			expectedCode:       404,
+			expectedMessage:    "data is not found",
+		},
+		{
+			name:               "test get job with an invalid id",
+			id:                 "not-a-uuid",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedSchema:     readJSONToString(t, "json_response_schema/misc_not_found.json"),
+			expectedCode:       400,
+			expectedMessage:    "invalid ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := fastHTTPTester(t, r.Handler())
+
+			resp := e.GET("/api/v1/jobs/"+tt.id).WithHeader("Authorization", adminToken).Expect()
+
+			resp.Status(tt.expectedStatusCode)
+			resp.JSON().Schema(tt.expectedSchema)
+			resp.JSON().Object().Value("response_code").IsEqual(tt.expectedCode)
+			resp.JSON().Object().Value("response_message").IsEqual(tt.expectedMessage)
+
+			if tt.expectedStatusCode == http.StatusOK {
+				data := resp.JSON().Object().Value("data").Object()
+				data.Value("result").Object().Value("greeted").IsEqual(true)
+				data.Value("attempts").Array().Length().IsEqual(1)
+				data.Value("progress").Object().Value("percent").IsEqual(40)
+				data.Value("progress").Object().Value("message").IsEqual("greeted 4 of 10 worlds")
+			}
+		})
+	}
+
+	t.Run("test get job without admin token", func(t *testing.T) {
+		e := fastHTTPTester(t, r.Handler())
+
+		e.GET("/api/v1/jobs/" + j.ID.String()).Expect().Status(http.StatusUnauthorized)
+	})
+}
+
+func TestStreamJobProgress(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+
+	j, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "Hello World"}, 1, 0)
+
+	testJobQueue := queue.NewQueue("test_stream_job_progress")
+	require.NoError(t, testJobQueue.Enqueue(ctx, j))
+
+	t.Cleanup(func() {
+		testJobQueue.Clear(ctx)
+	})
+
+	require.NoError(t, repo.Job.SetJobProgress(ctx, j.ID, 100, "greeted the world", time.Now()))
+	require.NoError(t, repo.Job.UpdateJobStatus(ctx, j.ID, job.StatusCompleted))
+
+	e := fastHTTPTester(t, r.Handler())
+
+	t.Run("test stream the progress of a finished job", func(t *testing.T) {
+		resp := e.GET("/api/v1/jobs/"+j.ID.String()+"/progress").WithHeader("Authorization", adminToken).Expect()
+
+		resp.Status(http.StatusOK)
+		resp.Header("Content-Type").IsEqual("text/event-stream")
+		body := resp.Body().Raw()
+		assert.Contains(t, body, "event: done\n")
+		assert.Contains(t, body, `"percent":100`)
+		assert.Contains(t, body, `"status":"completed"`)
+	})
+
+	t.Run("test stream the progress of an unknown job", func(t *testing.T) {
+		resp := e.GET("/api/v1/jobs/"+uuid.NewString()+"/progress").WithHeader("Authorization", adminToken).Expect()
+
+		resp.Status(http.StatusNotFound)
+		resp.JSON().Schema(readJSONToString(t, "json_response_schema/misc_not_found.json"))
+	})
+
+	t.Run("test stream the progress of a job without admin token", func(t *testing.T) {
+		e.GET("/api/v1/jobs/" + j.ID.String() + "/progress").Expect().Status(http.StatusUnauthorized)
+	})
+}
+
+func TestPruneJobs(t *testing.T) {
+	ctx := context.Background()
+	queueName := "test_prune_jobs"
+
+	addJob := func(status string, age time.Duration) uuid.UUID {
+		updatedAt := time.Now().Add(-age)
+		j := model.Job{
+			ID:          uuid.New(),
+			Queue:       queueName,
+			HandlerName: "ProcessExample",
+			Payload:     []byte(`{"data":"prune me"}`),
+			MaxAttempts: 1,
+			Status:      status,
+			CreatedAt:   updatedAt,
+			UpdatedAt:   updatedAt,
+		}
+		_, err := repo.Job.AddJob(ctx, j)
+		require.NoError(t, err)
+		return j.ID
+	}
+
+	oldCompleted := addJob(job.StatusCompleted, 10*24*time.Hour)
+	oldFailed := addJob(job.StatusFailed, 10*24*time.Hour)
+	recentCompleted := addJob(job.StatusCompleted, time.Hour)
+	oldPending := addJob(job.StatusPending, 10*24*time.Hour)
+	_, err := repo.Job.AddFailedJob(ctx, model.FaildJob{JobID: oldFailed, Queue: queueName, Error: "boom", FailedAt: time.Now().Add(-10 * 24 * time.Hour)})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		for _, status := range []string{job.StatusCompleted, job.StatusPending} {
+			_, _ = repo.Job.PruneJobs(ctx, queueName, status, time.Now().Add(time.Minute), 10, false)
+		}
+		_, _ = pgx.GetPgxPool().Exec(ctx, `DELETE FROM archived_jobs WHERE queue = $1`, queueName)
+	})
+
+	opts := queue.PruneOptions{
+		Default: queue.Retention{Completed: 7 * 24 * time.Hour, Failed: 7 * 24 * time.Hour},
+		Only:    queueName,
+		Archive: true,
+	}
+
+	opts.DryRun = true
+	results, err := queue.Prune(ctx, repo.Job, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, int64(1), results[0].Count)
+	assert.Equal(t, int64(1), results[1].Count)
+	_, err = repo.Job.GetJobByID(ctx, oldCompleted)
+	require.NoError(t, err, "a dry run should not delete anything")
+
+	opts.DryRun = false
+	results, err = queue.Prune(ctx, repo.Job, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, int64(1), results[0].Count)
+	assert.Equal(t, int64(1), results[1].Count)
+
+	for _, id := range []uuid.UUID{oldCompleted, oldFailed} {
+		_, err = repo.Job.GetJobByID(ctx, id)
+		assert.ErrorIs(t, err, repository.ErrJobNotFound)
+	}
+	for _, id := range []uuid.UUID{recentCompleted, oldPending} {
+		_, err = repo.Job.GetJobByID(ctx, id)
+		assert.NoError(t, err, "recent and unfinished jobs should be kept")
+	}
+
+	var archivedError string
+	err = pgx.GetPgxPool().QueryRow(ctx, `SELECT failed_job->>'error' FROM archived_jobs WHERE job_id = $1`, oldFailed).Scan(&archivedError)
+	require.NoError(t, err, "the pruned job should be archived")
+	assert.Equal(t, "boom", archivedError)
+}
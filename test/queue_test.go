@@ -12,7 +12,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/kondohiroki/go-boilerplate/config"
 	"github.com/kondohiroki/go-boilerplate/internal/db/model"
+	"github.com/kondohiroki/go-boilerplate/internal/db/pgx"
 	"github.com/kondohiroki/go-boilerplate/internal/db/rdb"
 	"github.com/kondohiroki/go-boilerplate/internal/helper/queue"
 	"github.com/kondohiroki/go-boilerplate/internal/job"
@@ -35,7 +37,7 @@ func TestQueue(t *testing.T) {
 	// Test Enqueue.
 	job1, _ := job.NewJob("ProcessExample", &job.ProcessExample{
 		Data: "Sawadeee Kaab!",
-	}, 3, 5)
+	}, 3, 0)
 
 	err = q.Enqueue(ctx, job1)
 	require.NoError(t, err, "Enqueue should not return an error")
@@ -70,7 +72,7 @@ func TestQueue(t *testing.T) {
 	// Test RetryFailedByJobID on an existing job.
 	job2, _ := job.NewJob("ProcessExample", &job.ProcessExample{
 		Data: "Sawadeee Kaab2!",
-	}, 1, 5)
+	}, 1, 0)
 
 	err = q.Enqueue(ctx, job2)
 	require.NoError(t, err)
@@ -93,10 +95,10 @@ func TestQueue(t *testing.T) {
 	// Test RetryAllFailed.
 	job3, _ := job.NewJob("ProcessExample", &job.ProcessExample{
 		Data: "Sawadeee Kaab3!",
-	}, 3, 5)
+	}, 3, 0)
 	job4, _ := job.NewJob("ProcessExample", &job.ProcessExample{
 		Data: "Sawadeee Kaab4!",
-	}, 3, 5)
+	}, 3, 0)
 	err = q.Enqueue(ctx, job3, job4)
 	require.NoError(t, err)
 
@@ -111,6 +113,34 @@ func TestQueue(t *testing.T) {
 	_, err = q.RetryAllFailed(ctx)
 	require.NoError(t, err, "retry all failed")
 
+	// Test delayed jobs.
+	delayedJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Later!",
+	}, 3, 1)
+	err = q.Enqueue(ctx, delayedJob)
+	require.NoError(t, err)
+
+	delayedLength, err := q.DelayedLength(ctx)
+	require.NoError(t, err, "DelayedLength should not return an error")
+	assert.Equal(t, int64(1), delayedLength, "DelayedLength should return 1 after enqueueing a delayed job")
+
+	promoted, err := q.PromoteDelayed(ctx)
+	require.NoError(t, err, "PromoteDelayed should not return an error")
+	assert.Equal(t, 0, promoted, "a delayed job should not be promoted before it is due")
+
+	time.Sleep(1100 * time.Millisecond)
+
+	promoted, err = q.PromoteDelayed(ctx)
+	require.NoError(t, err, "PromoteDelayed should not return an error")
+	assert.Equal(t, 1, promoted, "a delayed job should be promoted once it is due")
+
+	dequeuedDelayedJob, err := q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, delayedJob.ID, dequeuedDelayedJob.ID, "the promoted job should be dequeued")
+
+	err = q.RemoveProcessed(ctx, dequeuedDelayedJob.ID, nil)
+	require.NoError(t, err)
+
 	// Test Clear.
 	_, err = q.Clear(ctx)
 	require.NoError(t, err, "Clear should not return an error")
@@ -122,7 +152,7 @@ func TestQueue(t *testing.T) {
 	// Test ListQueueKeys.
 	job5, _ := job.NewJob("ProcessExample", &job.ProcessExample{
 		Data: "Sawadeee Kaab5!",
-	}, 3, 5)
+	}, 3, 0)
 	q.Enqueue(ctx, job5)
 	keys, err := queue.ListQueueKeys(ctx)
 	require.NoError(t, err, "ListQueueKeys should not return an error")
@@ -175,6 +205,174 @@ func TestQueue(t *testing.T) {
 	require.NoError(t, err, "GetUnfinishedJobs should not return an error")
 }
 
+func TestQueueLease(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewQueue("testing_lease")
+	q.VisibilityTimeout = 1 * time.Second
+
+	t.Cleanup(func() {
+		q.Clear(ctx)
+	})
+
+	j, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Lease!",
+	}, 3, 0)
+	err := q.Enqueue(ctx, j)
+	require.NoError(t, err)
+
+	dequeuedJob, err := q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.False(t, dequeuedJob.LeaseExpiresAt.IsZero(), "a dequeued job should carry a lease deadline")
+
+	// A job with a live lease is not reaped.
+	_, err = q.ExtendLease(ctx, dequeuedJob.ID)
+	require.NoError(t, err, "ExtendLease should not return an error while the job holds a lease")
+
+	reaped, err := q.ReapExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, reaped, "a job with a live lease should not be reaped")
+
+	// Assume the worker died, the lease expires and the job goes back to the queue.
+	time.Sleep(1100 * time.Millisecond)
+
+	reaped, err = q.ReapExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reaped, "a job with an expired lease should be reaped")
+
+	_, err = q.ExtendLease(ctx, dequeuedJob.ID)
+	assert.ErrorIs(t, err, queue.ErrLeaseLost, "ExtendLease should fail once the job was reaped")
+
+	redeliveredJob, err := q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, j.ID, redeliveredJob.ID, "the reaped job should be delivered again")
+	assert.Equal(t, 2, redeliveredJob.Attempts, "the lost attempt should be counted")
+
+	err = q.RemoveProcessed(ctx, redeliveredJob.ID, nil)
+	require.NoError(t, err)
+}
+
+func TestQueuePriority(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewQueue("testing_priority")
+
+	t.Cleanup(func() {
+		q.Clear(ctx)
+	})
+
+	normalJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab!",
+	}, 3, 0)
+	urgentJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Urgent!",
+	}, 3, 0)
+	urgentJob.Priority = 1
+
+	err := q.Enqueue(ctx, normalJob, urgentJob)
+	require.NoError(t, err)
+
+	dequeuedJob, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, urgentJob.ID, dequeuedJob.ID, "a job with a priority should be dequeued first")
+	require.NoError(t, q.RemoveProcessed(ctx, dequeuedJob.ID, nil))
+
+	dequeuedJob, err = q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, normalJob.ID, dequeuedJob.ID)
+	require.NoError(t, q.RemoveProcessed(ctx, dequeuedJob.ID, nil))
+
+	dequeuedJob, err = q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dequeuedJob, "TryDequeue should return nil on an empty queue")
+}
+
+func TestQueueStream(t *testing.T) {
+	ctx := queue.WithConsumer(context.Background(), "testing-consumer")
+	q := queue.NewQueueWithDriver("testing_stream", queue.NewStreamDriver(rdb.GetRedisClient(), repo.Job))
+	q.VisibilityTimeout = 1 * time.Second
+
+	t.Cleanup(func() {
+		q.Clear(ctx)
+		q.RemoveAllFailed(ctx)
+	})
+
+	normalJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Stream!",
+	}, 2, 0)
+	urgentJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Urgent Stream!",
+	}, 3, 0)
+	urgentJob.Priority = 1
+
+	err := q.Enqueue(ctx, normalJob, urgentJob)
+	require.NoError(t, err)
+
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), length, "Length should count the ready jobs of the streams")
+
+	peekedJobs, err := q.Peek(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, peekedJobs, 2)
+	assert.Equal(t, urgentJob.ID, peekedJobs[0].ID, "Peek should return the job with a priority first")
+
+	// Acks are done by job ID.
+	dequeuedJob, err := q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, urgentJob.ID, dequeuedJob.ID, "a job with a priority should be dequeued first")
+	require.NoError(t, q.RemoveProcessed(ctx, dequeuedJob.ID, nil))
+
+	// A failed job goes back to the stream, then to the failed list.
+	dequeuedJob, err = q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, normalJob.ID, dequeuedJob.ID)
+	require.NoError(t, q.RemoveProcessed(ctx, dequeuedJob.ID, fmt.Errorf("assume job is failed")))
+
+	dequeuedJob, err = q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dequeuedJob.Attempts)
+	require.NoError(t, q.RemoveProcessed(ctx, dequeuedJob.ID, fmt.Errorf("assume job is failed again")))
+
+	stats, err := q.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, queue.Stats{Failed: 1}, stats)
+
+	// A retried job is read by the consumer group again, then the worker dies.
+	require.NoError(t, q.RetryFailedByJobID(ctx, normalJob.ID))
+	dequeuedJob, err = q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, normalJob.ID, dequeuedJob.ID)
+
+	_, err = q.ExtendLease(ctx, dequeuedJob.ID)
+	require.NoError(t, err, "ExtendLease should not return an error while the consumer owns the entry")
+
+	time.Sleep(1100 * time.Millisecond)
+
+	reaped, err := q.ReapExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reaped, "the pending entry should be claimed by the reaper")
+
+	_, err = q.ExtendLease(ctx, dequeuedJob.ID)
+	assert.ErrorIs(t, err, queue.ErrLeaseLost, "ExtendLease should fail once the entry was claimed")
+
+	redeliveredJob, err := q.Dequeue(ctx, 1*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, normalJob.ID, redeliveredJob.ID, "the reclaimed job should be delivered again")
+	require.NoError(t, q.RemoveProcessed(ctx, redeliveredJob.ID, nil))
+
+	// Clear drops the ready jobs, their entries are skipped when read.
+	clearedJob, _ := job.NewJob("ProcessExample", &job.ProcessExample{
+		Data: "Sawadeee Kaab Cleared!",
+	}, 3, 0)
+	require.NoError(t, q.Enqueue(ctx, clearedJob))
+	cleared, err := q.Clear(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cleared)
+
+	dequeuedJob, err = q.TryDequeue(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dequeuedJob, "a cleared job should not be delivered")
+}
+
 func TestGetQueues(t *testing.T) {
 	type params struct{}
 
@@ -225,3 +423,470 @@ func TestGetQueues(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBatchByID(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+
+	first, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "first"}, 1, 0)
+	second, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "second"}, 1, 0)
+
+	testBatchQueue := queue.NewQueue("test_batch")
+	batchID, err := testBatchQueue.DispatchBatch(ctx, queue.Batch{
+		Name: "test batch",
+		Jobs: []*job.Job{first, second},
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		testBatchQueue.Clear(ctx)
+	})
+
+	dequeuedJob, err := testBatchQueue.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, testBatchQueue.RemoveProcessed(ctx, dequeuedJob.ID, nil))
+
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedSchema     string
+		expectedCode       int
+		expectedMessage    string
+	}{
+		{
+			name:               "test get batch by id",
+			id:                 batchID.String(),
+			expectedStatusCode: http.StatusOK,
+			expectedSchema:     readJSONToString(t, "json_response_schema/get_batch.json"),
+			expectedCode:       0,
+			expectedMessage:    "OK",
+		},
+		{
+			name:               "test get batch with an unknown id",
+			id:                 uuid.NewString(),
+			expectedStatusCode: http.StatusNotFound,
+			expectedSchema:     readJSONToString(t, "json_response_schema/misc_not_found.json"),
+			expectedCode:       404,
+			expectedMessage:    "data is not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := fastHTTPTester(t, r.Handler())
+
+			resp := e.GET("/api/v1/queues/batches/"+tt.id).WithHeader("Authorization", adminToken).Expect()
+
+			resp.Status(tt.expectedStatusCode)
+			resp.JSON().Schema(tt.expectedSchema)
+			resp.JSON().Object().Value("response_code").IsEqual(tt.expectedCode)
+			resp.JSON().Object().Value("response_message").IsEqual(tt.expectedMessage)
+
+			if tt.expectedStatusCode == http.StatusOK {
+				data := resp.JSON().Object().Value("data").Object()
+				data.Value("total_jobs").IsEqual(2)
+				data.Value("pending_jobs").IsEqual(1)
+				data.Value("progress").IsEqual(50)
+			}
+		})
+	}
+
+	t.Run("test get batch without admin token", func(t *testing.T) {
+		e := fastHTTPTester(t, r.Handler())
+
+		e.GET("/api/v1/queues/batches/" + batchID.String()).Expect().Status(http.StatusUnauthorized)
+	})
+}
+
+func TestQueueAdmin(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+
+	testAdminQueue := queue.NewQueue("test_admin_queue")
+	t.Cleanup(func() {
+		testAdminQueue.Clear(ctx)
+		testAdminQueue.RemoveAllFailed(ctx)
+		testAdminQueue.Resume(ctx)
+	})
+
+	failedJob, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "failed"}, 1, 0)
+	readyJob, _ := job.NewJob("ProcessExample", job.ProcessExample{Data: "ready"}, 1, 0)
+	require.NoError(t, testAdminQueue.Enqueue(ctx, failedJob))
+	dequeuedJob, err := testAdminQueue.TryDequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, testAdminQueue.RemoveProcessed(ctx, dequeuedJob.ID, fmt.Errorf("assume job is failed")))
+	require.NoError(t, testAdminQueue.Enqueue(ctx, readyJob))
+
+	e := fastHTTPTester(t, r.Handler())
+
+	t.Run("test queue admin without a token", func(t *testing.T) {
+		for _, token := range []string{"", "Bearer wrong-token"} {
+			resp := e.GET("/api/v1/queues/test_admin_queue").WithHeader("Authorization", token).Expect()
+
+			resp.Status(http.StatusUnauthorized)
+			resp.JSON().Object().Value("response_code").IsEqual(401)
+			resp.JSON().Object().Value("response_message").IsEqual("permission is not granted")
+		}
+	})
+
+	t.Run("test get queue by key", func(t *testing.T) {
+		resp := e.GET("/api/v1/queues/test_admin_queue").WithHeader("Authorization", adminToken).
+			WithQuery("peek", 5).Expect()
+
+		resp.Status(http.StatusOK)
+		resp.JSON().Schema(readJSONToString(t, "json_response_schema/get_queue.json"))
+		data := resp.JSON().Object().Value("data").Object()
+		data.Value("ready").IsEqual(1)
+		data.Value("failed").IsEqual(1)
+		data.Value("paused").IsEqual(false)
+		data.Value("jobs").Array().Length().IsEqual(1)
+		data.Value("jobs").Array().Value(0).Object().Value("id").IsEqual(readyJob.ID.String())
+
+		e.GET("/api/v1/queues/test_admin_queue").WithHeader("Authorization", adminToken).
+			WithQuery("peek", "abc").Expect().Status(http.StatusBadRequest)
+	})
+
+	t.Run("test get failed jobs", func(t *testing.T) {
+		resp := e.GET("/api/v1/queues/test_admin_queue/failed").WithHeader("Authorization", adminToken).
+			WithQuery("page", 1).WithQuery("perPage", 10).Expect()
+
+		resp.Status(http.StatusOK)
+		resp.JSON().Schema(readJSONToString(t, "json_response_schema/get_failed_jobs.json"))
+		data := resp.JSON().Object().Value("data").Object()
+		data.Value("jobs").Array().Length().IsEqual(1)
+		data.Value("jobs").Array().Value(0).Object().Value("id").IsEqual(failedJob.ID.String())
+		data.Value("pagination").Object().Value("total").IsEqual(1)
+		data.Value("pagination").Object().Value("has_more").IsEqual(false)
+	})
+
+	t.Run("test retry and forget failed jobs", func(t *testing.T) {
+		e.POST("/api/v1/queues/test_admin_queue/failed/"+uuid.NewString()+"/retry").WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusNotFound)
+		e.POST("/api/v1/queues/test_admin_queue/failed/not-a-uuid/retry").WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusBadRequest)
+
+		e.POST("/api/v1/queues/test_admin_queue/failed/"+failedJob.ID.String()+"/retry").WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusOK)
+
+		stats, err := testAdminQueue.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, queue.Stats{Ready: 2}, stats)
+
+		e.DELETE("/api/v1/queues/test_admin_queue/failed/"+failedJob.ID.String()).WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusNotFound)
+	})
+
+	t.Run("test pause and resume queue", func(t *testing.T) {
+		e.POST("/api/v1/queues/test_admin_queue/pause").WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusOK)
+
+		paused, err := testAdminQueue.IsPaused(ctx)
+		require.NoError(t, err)
+		assert.True(t, paused)
+
+		e.POST("/api/v1/queues/test_admin_queue/resume").WithHeader("Authorization", adminToken).
+			Expect().Status(http.StatusOK)
+
+		paused, err = testAdminQueue.IsPaused(ctx)
+		require.NoError(t, err)
+		assert.False(t, paused)
+	})
+
+	t.Run("test clear queue and flush failed jobs", func(t *testing.T) {
+		resp := e.DELETE("/api/v1/queues/test_admin_queue").WithHeader("Authorization", adminToken).Expect()
+		resp.Status(http.StatusOK)
+		resp.JSON().Object().Value("data").Object().Value("count").IsEqual(2)
+
+		resp = e.DELETE("/api/v1/queues/test_admin_queue/failed").WithHeader("Authorization", adminToken).Expect()
+		resp.Status(http.StatusOK)
+		resp.JSON().Object().Value("data").Object().Value("count").IsEqual(0)
+	})
+}
+
+func TestEnqueueJob(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+
+	testDispatchQueue := queue.NewQueue("test_dispatch_queue")
+	t.Cleanup(func() {
+		testDispatchQueue.Clear(ctx)
+	})
+
+	e := fastHTTPTester(t, r.Handler())
+
+	t.Run("test enqueue job", func(t *testing.T) {
+		body := map[string]any{
+			"handler_name":    "ProcessExample",
+			"payload":         map[string]any{"data": "Sawadeee Kaab HTTP!"},
+			"max_attempts":    2,
+			"idempotency_key": uuid.NewString(),
+		}
+
+		resp := e.POST("/api/v1/queues/test_dispatch_queue/jobs").WithHeader("Authorization", adminToken).WithJSON(body).Expect()
+		resp.Status(http.StatusCreated)
+		resp.JSON().Schema(readJSONToString(t, "json_response_schema/enqueue_job.json"))
+		data := resp.JSON().Object().Value("data").Object()
+		data.Value("duplicate").IsEqual(false)
+		jobID := data.Value("id").String().Raw()
+
+		// The same idempotency key returns the job dispatched first.
+		resp = e.POST("/api/v1/queues/test_dispatch_queue/jobs").WithHeader("Authorization", adminToken).WithJSON(body).Expect()
+		resp.Status(http.StatusOK)
+		resp.JSON().Object().Value("data").Object().Value("id").IsEqual(jobID)
+		resp.JSON().Object().Value("data").Object().Value("duplicate").IsEqual(true)
+
+		peekedJobs, err := testDispatchQueue.Peek(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, peekedJobs, 1)
+		assert.Equal(t, jobID, peekedJobs[0].ID.String())
+		assert.Equal(t, 2, peekedJobs[0].MaxAttempts)
+	})
+
+	t.Run("test enqueue job with an invalid job", func(t *testing.T) {
+		tests := []struct {
+			name               string
+			body               map[string]any
+			expectedStatusCode int
+			expectedMessage    string
+		}{
+			{
+				name:               "unknown handler",
+				body:               map[string]any{"handler_name": "DoesNotExist"},
+				expectedStatusCode: http.StatusUnprocessableEntity,
+				expectedMessage:    "job handler is not registered",
+			},
+			{
+				name:               "payload of the wrong type",
+				body:               map[string]any{"handler_name": "ProcessExample", "payload": map[string]any{"data": 42}},
+				expectedStatusCode: http.StatusUnprocessableEntity,
+				expectedMessage:    "job payload does not match its handler",
+			},
+			{
+				name:               "missing handler name",
+				body:               map[string]any{"payload": map[string]any{}},
+				expectedStatusCode: http.StatusUnprocessableEntity,
+				expectedMessage:    "validation failed",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				resp := e.POST("/api/v1/queues/test_dispatch_queue/jobs").WithHeader("Authorization", adminToken).WithJSON(tt.body).Expect()
+
+				resp.Status(tt.expectedStatusCode)
+				resp.JSON().Schema(readJSONToString(t, "json_response_schema/error_422.json"))
+				resp.JSON().Object().Value("response_message").IsEqual(tt.expectedMessage)
+			})
+		}
+	})
+
+	t.Run("test enqueue jobs in bulk", func(t *testing.T) {
+		_, err := testDispatchQueue.Clear(ctx)
+		require.NoError(t, err)
+
+		resp := e.POST("/api/v1/queues/test_dispatch_queue/jobs/bulk").WithHeader("Authorization", adminToken).WithJSON(map[string]any{
+			"jobs": []map[string]any{
+				{"handler_name": "ProcessExample", "payload": map[string]any{"data": "first"}},
+				{"handler_name": "ProcessExample", "payload": map[string]any{"data": "second"}, "delay": 60},
+				{"handler_name": "ProcessExample", "payload": map[string]any{"data": "third"}},
+			},
+		}).Expect()
+		resp.Status(http.StatusCreated)
+		resp.JSON().Object().Value("data").Object().Value("jobs").Array().Length().IsEqual(3)
+
+		stats, err := testDispatchQueue.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, queue.Stats{Ready: 2, Delayed: 1}, stats)
+
+		// No job is enqueued if one of them is invalid.
+		resp = e.POST("/api/v1/queues/test_dispatch_queue/jobs/bulk").WithHeader("Authorization", adminToken).WithJSON(map[string]any{
+			"jobs": []map[string]any{
+				{"handler_name": "ProcessExample", "payload": map[string]any{"data": "fourth"}},
+				{"handler_name": "DoesNotExist"},
+			},
+		}).Expect()
+		resp.Status(http.StatusUnprocessableEntity)
+		resp.JSON().Object().Value("errors").Array().Value(0).Object().Value("message").IsEqual("jobs[1]: job handler is not registered")
+
+		stats, err = testDispatchQueue.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, queue.Stats{Ready: 2, Delayed: 1}, stats)
+	})
+}
+
+func TestPauseQueue(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewQueue("test_pause_queue")
+
+	t.Cleanup(func() {
+		q.Resume(ctx)
+		q.Clear(ctx)
+	})
+
+	require.NoError(t, q.Pause(ctx))
+
+	// A paused queue is listed even without jobs.
+	queueInfos, err := queue.ListQueueKeysAndLengths(ctx)
+	require.NoError(t, err)
+	found := false
+	for _, queueInfo := range queueInfos {
+		if queueInfo.KeyWithoutPrefix == q.KeyWithoutPrefix {
+			found = true
+			assert.True(t, queueInfo.Paused, "queue [test_pause_queue] should be paused")
+		}
+	}
+	assert.True(t, found, "queue [test_pause_queue] not found in the list of queue keys and lengths")
+
+	// A worker does not take jobs from a paused queue.
+	j, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "Sawadeee Kaab Paused!"}, 1, 0)
+	require.NoError(t, q.Enqueue(ctx, j))
+
+	runCtx, cancel := context.WithTimeout(ctx, 1500*time.Millisecond)
+	defer cancel()
+	_ = q.Run(runCtx)
+
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length, "the job of a paused queue should stay queued")
+
+	require.NoError(t, q.Resume(ctx))
+	paused, err := q.IsPaused(ctx)
+	require.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestGetWorkers(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+	q := queue.NewQueue("test_workers_queue")
+
+	workerCtx, cancel := context.WithCancel(queue.WithConsumer(ctx, "test-worker"))
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = q.Run(workerCtx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	e := fastHTTPTester(t, r.Handler())
+
+	e.GET("/api/v1/queues/workers").Expect().Status(http.StatusUnauthorized)
+
+	require.Eventually(t, func() bool {
+		workers, err := queue.ListWorkers(ctx)
+		return err == nil && len(workers) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	resp := e.GET("/api/v1/queues/workers").WithHeader("Authorization", adminToken).Expect()
+	resp.Status(http.StatusOK)
+	resp.JSON().Schema(readJSONToString(t, "json_response_schema/get_workers.json"))
+
+	found := false
+	for _, worker := range resp.JSON().Object().Value("data").Array().Iter() {
+		if worker.Object().Value("id").String().Raw() == "test-worker" {
+			found = true
+			worker.Object().Value("queues").IsEqual([]string{"test_workers_queue"})
+		}
+	}
+	assert.True(t, found, "worker [test-worker] not found in the list of workers")
+
+	cancel()
+	<-stopped
+	workers, err := queue.ListWorkers(ctx)
+	require.NoError(t, err)
+	for _, worker := range workers {
+		assert.NotEqual(t, "test-worker", worker.ID, "a stopped worker should be unregistered")
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewQueue("test_outbox_queue")
+
+	t.Cleanup(func() {
+		q.Clear(ctx)
+	})
+
+	// A job enqueued by a rolled back transaction never reaches the queue
+	rolledBack, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "rolled back"}, 1, 0)
+	tx, err := pgx.GetPgxPool().Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.EnqueueTx(ctx, tx, rolledBack))
+	require.NoError(t, tx.Rollback(ctx))
+
+	committed, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "committed"}, 1, 0)
+	tx, err = pgx.GetPgxPool().Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.EnqueueTx(ctx, tx, committed))
+
+	// The relay does not see the job before the transaction commits
+	_, err = queue.RelayOutbox(ctx, repo.Outbox, queue.DefaultRelayBatchSize)
+	require.NoError(t, err)
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, length)
+
+	require.NoError(t, tx.Commit(ctx))
+
+	for {
+		relayed, err := queue.RelayOutbox(ctx, repo.Outbox, queue.DefaultRelayBatchSize)
+		require.NoError(t, err)
+		if relayed < queue.DefaultRelayBatchSize {
+			break
+		}
+	}
+
+	jobs, err := q.Peek(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "the committed job should be relayed exactly once")
+	assert.Equal(t, committed.ID, jobs[0].ID)
+
+	count, err := repo.Outbox.CountOutboxJobs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count, "relayed jobs should be removed from the outbox")
+}
+
+func TestCancelJob(t *testing.T) {
+	ctx := context.Background()
+	adminToken := "Bearer " + config.GetConfig().HttpServer.AdminTokens[0]
+	q := queue.NewQueue("test_cancel_queue")
+
+	t.Cleanup(func() {
+		q.Clear(ctx)
+	})
+
+	j, _ := job.NewJob("ProcessExample", &job.ProcessExample{Data: "Sawadeee Kaab Cancelled!"}, 1, 0)
+	require.NoError(t, q.Enqueue(ctx, j))
+
+	e := fastHTTPTester(t, r.Handler())
+
+	e.POST("/api/v1/queues/test_cancel_queue/jobs/"+uuid.NewString()+"/cancel").WithHeader("Authorization", adminToken).
+		Expect().Status(http.StatusNotFound)
+	e.POST("/api/v1/queues/test_cancel_queue/jobs/not-a-uuid/cancel").WithHeader("Authorization", adminToken).
+		Expect().Status(http.StatusBadRequest)
+	e.POST("/api/v1/queues/other_queue/jobs/"+j.ID.String()+"/cancel").WithHeader("Authorization", adminToken).
+		Expect().Status(http.StatusNotFound)
+
+	e.POST("/api/v1/queues/test_cancel_queue/jobs/"+j.ID.String()+"/cancel").WithHeader("Authorization", adminToken).
+		Expect().Status(http.StatusOK)
+
+	// The worker settles the cancelled job without running it
+	runCtx, cancel := context.WithTimeout(ctx, 1500*time.Millisecond)
+	defer cancel()
+	_ = q.Run(runCtx)
+
+	length, err := q.Length(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, length)
+
+	cancelled, err := repo.Job.GetJobByID(ctx, j.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.StatusCancelled, cancelled.Status)
+
+	e.POST("/api/v1/queues/test_cancel_queue/jobs/"+j.ID.String()+"/cancel").WithHeader("Authorization", adminToken).
+		Expect().Status(http.StatusUnprocessableEntity)
+}